@@ -0,0 +1,190 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"fgo/internal/auth"
+	"fgo/internal/domain"
+	"fgo/internal/httpx"
+	"fgo/internal/observe"
+	"fgo/internal/storage/metastore"
+)
+
+// Branch updates carrying an expected_old lease need write scope, which the
+// route group enforces; forced updates without one need admin.
+
+func (s *Server) handleListBranches(w http.ResponseWriter, r *http.Request) {
+	box, ok := s.loadBox(w, r)
+	if !ok {
+		return
+	}
+	refs, err := s.branches.List(r.Context(), box)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	httpx.JSON(w, http.StatusOK, refs)
+}
+
+func (s *Server) handleGetBranch(w http.ResponseWriter, r *http.Request) {
+	box, ok := s.loadBox(w, r)
+	if !ok {
+		return
+	}
+	ref, err := s.branches.Get(r.Context(), box, r.PathValue("branch"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	httpx.JSON(w, http.StatusOK, ref)
+}
+
+// handleSetBranch serves PUT /v0/boxes/{box}/branches/{branch} with
+// {commit_id, expected_old, reason}.
+func (s *Server) handleSetBranch(w http.ResponseWriter, r *http.Request) {
+	box, ok := s.loadBox(w, r)
+	if !ok {
+		return
+	}
+	var req domain.BranchUpdate
+	if !s.decodeJSON(w, r, &req, httpx.CodeBadRequest) {
+		return
+	}
+	if req.Force() && !httpx.CheckScope(w, r, auth.ScopeAdmin) {
+		return
+	}
+	res, err := s.branches.Set(r.Context(), box, r.PathValue("branch"), req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	observe.AddLogAttrs(r.Context(), slog.String("commit_id", res.CommitID), slog.Bool("forced", res.Forced))
+	httpx.JSON(w, http.StatusOK, res)
+}
+
+// handleDeleteBranch serves DELETE /v0/boxes/{box}/branches/{branch}?expected_old=&reason=.
+func (s *Server) handleDeleteBranch(w http.ResponseWriter, r *http.Request) {
+	box, ok := s.loadBox(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	var expected *string
+	if q.Has("expected_old") {
+		v := q.Get("expected_old")
+		expected = &v
+	}
+	if expected == nil && !httpx.CheckScope(w, r, auth.ScopeAdmin) {
+		return
+	}
+	if _, err := s.branches.Delete(r.Context(), box, r.PathValue("branch"), expected, q.Get("reason")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleReflog serves GET /v0/boxes/{box}/reflog/{branch}?before=&limit=,
+// newest entries first.
+func (s *Server) handleReflog(w http.ResponseWriter, r *http.Request) {
+	box, ok := s.loadBox(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	var before int64
+	if v := q.Get("before"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			httpx.Error(w, r, http.StatusBadRequest, httpx.CodeBadRequest, "before must be a positive reflog entry id", nil)
+			return
+		}
+		before = id
+	}
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 1000 {
+			httpx.Error(w, r, http.StatusBadRequest, httpx.CodeBadRequest, "limit must be between 1 and 1000", nil)
+			return
+		}
+		limit = n
+	}
+	entries, err := s.branches.Reflog(r.Context(), box, r.PathValue("branch"), before, limit)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	httpx.JSON(w, http.StatusOK, map[string]any{"entries": entries})
+}
+
+// handleResetBranch serves POST /v0/boxes/{box}/reset/{branch} with
+// {entry_id, expected_old, reason}. Like a branch PUT, omitting
+// expected_old forces the reset and needs admin scope.
+func (s *Server) handleResetBranch(w http.ResponseWriter, r *http.Request) {
+	box, ok := s.loadBox(w, r)
+	if !ok {
+		return
+	}
+	var req domain.ResetRequest
+	if !s.decodeJSON(w, r, &req, httpx.CodeBadRequest) {
+		return
+	}
+	if req.ExpectedOld == nil && !httpx.CheckScope(w, r, auth.ScopeAdmin) {
+		return
+	}
+	res, err := s.branches.Reset(r.Context(), box, r.PathValue("branch"), req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	observe.AddLogAttrs(r.Context(), slog.String("commit_id", res.CommitID), slog.Int64("reflog_id", req.EntryID))
+	httpx.JSON(w, http.StatusOK, res)
+}
+
+func (s *Server) handleListRules(w http.ResponseWriter, r *http.Request) {
+	box, ok := s.loadBox(w, r)
+	if !ok {
+		return
+	}
+	rules, err := s.branches.Rules(r.Context(), box)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	httpx.JSON(w, http.StatusOK, rules)
+}
+
+// handlePutRule serves PUT /v0/boxes/{box}/protections/{pattern} with
+// {allow_force, allow_delete, require_signed, principals}.
+func (s *Server) handlePutRule(w http.ResponseWriter, r *http.Request) {
+	box, ok := s.loadBox(w, r)
+	if !ok {
+		return
+	}
+	var rule metastore.BranchRule
+	if !s.decodeJSON(w, r, &rule, httpx.CodeBadRequest) {
+		return
+	}
+	rule.Pattern = r.PathValue("pattern")
+	rule, err := s.branches.PutRule(r.Context(), box, rule)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	httpx.JSON(w, http.StatusOK, rule)
+}
+
+func (s *Server) handleDeleteRule(w http.ResponseWriter, r *http.Request) {
+	box, ok := s.loadBox(w, r)
+	if !ok {
+		return
+	}
+	if err := s.branches.DeleteRule(r.Context(), box, r.PathValue("pattern")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
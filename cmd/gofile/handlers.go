@@ -0,0 +1,703 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"fgo/internal/auth"
+	"fgo/internal/domain"
+	"fgo/internal/httpx"
+	"fgo/internal/observe"
+	"fgo/internal/storage/metastore"
+)
+
+func (s *Server) handleBrowse(w http.ResponseWriter, r *http.Request) {
+	boxes, err := s.boxes.ListPublic(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "<html><head><title>fGo Browse</title></head><body><h1>Public Boxes</h1><ul>")
+	for _, b := range boxes {
+		fmt.Fprintf(w, "<li><a href='/browse/%s'>%s</a></li>", b.Name, b.Name)
+	}
+	fmt.Fprintf(w, "</ul></body></html>")
+}
+
+func (s *Server) handleUploadForm(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, `<html><head><title>fGo Upload</title></head><body><h1>Upload File</h1><form method='POST' enctype='multipart/form-data'><input type='file' name='file'><input type='submit'></form></body></html>`)
+}
+
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	f, h, err := r.FormFile("file")
+	if err != nil {
+		httpx.Error(w, r, http.StatusBadRequest, httpx.CodeBadRequest, "file field required", err)
+		return
+	}
+	defer f.Close()
+	// For demo: just show file name and size
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "<html><body>Uploaded: %s (%d bytes)</body></html>", h.Filename, h.Size)
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if s.draining.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("draining"))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
+}
+
+// handleOpenAPI serves the OpenAPI spec from disk.
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	b, err := os.ReadFile(s.openAPIPath)
+	if err != nil {
+		httpx.Error(w, r, http.StatusNotFound, httpx.CodeNotFound, "openapi.yaml not found", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(b)
+}
+
+// handleDocs serves a minimal docs page.
+func (s *Server) handleDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(`<!doctype html><html><head><title>fGo API Docs</title></head><body>
+<h1>fGo API (v0)</h1>
+<ul>
+  <li><a href="/v0/openapi.yaml">OpenAPI Spec</a></li>
+  <li>Health: GET /v0/health</li>
+  <li>Boxes: GET/POST /v0/boxes</li>
+  <li>Box: GET /v0/boxes/{box}</li>
+  <li>Push Plan: POST /v0/boxes/{box}/push/plan</li>
+  <li>Push Finalize: POST /v0/boxes/{box}/push/finalize</li>
+  <li>Compare: GET /v0/boxes/{box}/compare/{base}...{head}?patch=true</li>
+  <li>Latest Commit: GET /v0/boxes/{box}/commits/latest?branch=main</li>
+  <li>Blobs: HEAD/PUT /v0/blobs/{sha256}</li>
+  <li>Batch blobs: POST /v0/blobs/batch/check, POST /v0/blobs/batch</li>
+  <li>Resumable uploads: POST /v0/uploads, HEAD/PATCH/DELETE /v0/uploads/{id}, POST /v0/uploads/{id}/complete</li>
+  <li>Files: GET /v0/files/{commit_id}?path=... (Range supported)</li>
+</ul>
+</body></html>`))
+}
+
+// decodeJSON reads the request body into v, enforcing the body size limit.
+// On failure it writes a 413 or a 400 with code and returns false.
+func (s *Server) decodeJSON(w http.ResponseWriter, r *http.Request, v any, code string) bool {
+	body := r.Body
+	if s.maxBodyBytes > 0 {
+		body = http.MaxBytesReader(w, r.Body, s.maxBodyBytes)
+	}
+	if err := json.NewDecoder(body).Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, r, &domain.LimitError{Limit: "request body", Max: tooLarge.Limit, Got: r.ContentLength})
+			return false
+		}
+		httpx.Error(w, r, http.StatusBadRequest, code, "invalid JSON body", err)
+		return false
+	}
+	return true
+}
+
+func (s *Server) handleListBoxes(w http.ResponseWriter, r *http.Request) {
+	boxes, err := s.boxes.ListPublic(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	httpx.JSON(w, http.StatusOK, boxes)
+}
+
+func (s *Server) handleCreateBox(w http.ResponseWriter, r *http.Request) {
+	var req domain.CreateBoxRequest
+	if !s.decodeJSON(w, r, &req, httpx.CodeBadRequest) {
+		return
+	}
+	slog.DebugContext(r.Context(), "create box", "name", req.Name, "visibility", req.Visibility, "default_branch", req.DefaultBranch)
+	b, err := s.boxes.Create(r.Context(), req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	observe.AddLogAttrs(r.Context(), slog.String("box_id", b.ID))
+	httpx.JSON(w, http.StatusCreated, b)
+}
+
+// loadBox resolves the {box} path parameter, writing a 404 when it does not exist.
+func (s *Server) loadBox(w http.ResponseWriter, r *http.Request) (metastore.Box, bool) {
+	box, err := s.boxes.Get(r.Context(), r.PathValue("box"))
+	if err != nil {
+		writeError(w, r, err)
+		return metastore.Box{}, false
+	}
+	observe.AddLogAttrs(r.Context(), slog.String("box_id", box.ID))
+	return box, true
+}
+
+func (s *Server) handleGetBox(w http.ResponseWriter, r *http.Request) {
+	box, ok := s.loadBox(w, r)
+	if !ok {
+		return
+	}
+	httpx.JSON(w, http.StatusOK, box)
+}
+
+// handleUpdateBox serves PATCH /v0/boxes/{box}; only visibility can change.
+func (s *Server) handleUpdateBox(w http.ResponseWriter, r *http.Request) {
+	box, ok := s.loadBox(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Visibility *string `json:"visibility"`
+	}
+	if !s.decodeJSON(w, r, &req, httpx.CodeBadRequest) {
+		return
+	}
+	if req.Visibility != nil {
+		var err error
+		if box, err = s.boxes.SetVisibility(r.Context(), box, *req.Visibility); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	httpx.JSON(w, http.StatusOK, box)
+}
+
+// handleTree serves GET /v0/boxes/{box}/tree/{ref}?path=src/&recursive=false
+// with sort (name or size), order (asc or desc), limit and offset. ref is a
+// tag, branch or commit ID.
+func (s *Server) handleTree(w http.ResponseWriter, r *http.Request) {
+	box, ok := s.loadBox(w, r)
+	if !ok {
+		return
+	}
+	observe.AddLogAttrs(r.Context(), slog.String("ref", r.PathValue("ref")))
+	q := r.URL.Query()
+	opts := domain.ListOptions{Path: q.Get("path"), Sort: q.Get("sort")}
+	switch q.Get("recursive") {
+	case "", "false":
+	case "true":
+		opts.Recursive = true
+	default:
+		httpx.Error(w, r, http.StatusBadRequest, httpx.CodeBadRequest, "recursive must be true or false", nil)
+		return
+	}
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		opts.Desc = true
+	default:
+		httpx.Error(w, r, http.StatusBadRequest, httpx.CodeBadRequest, "order must be asc or desc", nil)
+		return
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > domain.MaxListLimit {
+			httpx.Error(w, r, http.StatusBadRequest, httpx.CodeBadRequest, "limit must be between 1 and 1000", nil)
+			return
+		}
+		opts.Limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			httpx.Error(w, r, http.StatusBadRequest, httpx.CodeBadRequest, "offset must be a non-negative integer", nil)
+			return
+		}
+		opts.Offset = n
+	}
+	listing, err := s.boxes.ListTree(r.Context(), box, r.PathValue("ref"), opts)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	httpx.JSON(w, http.StatusOK, listing)
+}
+
+// handleCompare serves GET /v0/boxes/{box}/compare/{base}...{head}?patch=true&context=3.
+// base and head are commit IDs or branch names.
+func (s *Server) handleCompare(w http.ResponseWriter, r *http.Request) {
+	box, ok := s.loadBox(w, r)
+	if !ok {
+		return
+	}
+	baseRev, headRev, found := strings.Cut(r.PathValue("spec"), "...")
+	if !found || baseRev == "" || headRev == "" {
+		httpx.Error(w, r, http.StatusBadRequest, httpx.CodeBadRequest, "compare spec must be {base}...{head}", nil)
+		return
+	}
+	q := r.URL.Query()
+	opts := domain.CompareOptions{Patch: q.Get("patch") == "true"}
+	if c := q.Get("context"); c != "" {
+		n, err := strconv.Atoi(c)
+		if err != nil || n < 0 || n > 100 {
+			httpx.Error(w, r, http.StatusBadRequest, httpx.CodeBadRequest, "context must be between 0 and 100", nil)
+			return
+		}
+		opts.Context = n
+	}
+	base, err := s.boxes.Resolve(r.Context(), box, baseRev)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	head, err := s.boxes.Resolve(r.Context(), box, headRev)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	cmp, err := s.compare.Compare(r.Context(), base, head, opts)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	httpx.JSON(w, http.StatusOK, cmp)
+}
+
+// handleListCommits serves GET /v0/boxes/{box}/commits?branch=main&limit=N.
+func (s *Server) handleListCommits(w http.ResponseWriter, r *http.Request) {
+	box, ok := s.loadBox(w, r)
+	if !ok {
+		return
+	}
+	limit := 10
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if n, err := strconv.Atoi(l); err == nil && n > 0 && n <= 100 {
+			limit = n
+		}
+	}
+	commits, err := s.boxes.Commits(r.Context(), box, r.URL.Query().Get("branch"), limit)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	httpx.JSON(w, http.StatusOK, commits)
+}
+
+func (s *Server) handleLatestCommit(w http.ResponseWriter, r *http.Request) {
+	box, ok := s.loadBox(w, r)
+	if !ok {
+		return
+	}
+	commit, err := s.boxes.Latest(r.Context(), box, r.URL.Query().Get("branch"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	httpx.JSON(w, http.StatusOK, commit)
+}
+
+func (s *Server) handlePlan(w http.ResponseWriter, r *http.Request) {
+	box, ok := s.loadBox(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Branch  string            `json:"branch"`
+		Entries []metastore.Entry `json:"entries"`
+	}
+	if !s.decodeJSON(w, r, &req, httpx.CodeInvalidManifest) {
+		return
+	}
+	plan, err := s.push.Plan(r.Context(), box, req.Branch, req.Entries)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	httpx.JSON(w, http.StatusOK, plan)
+}
+
+func (s *Server) handleFinalize(w http.ResponseWriter, r *http.Request) {
+	box, ok := s.loadBox(w, r)
+	if !ok {
+		return
+	}
+	var req domain.FinalizeRequest
+	if !s.decodeJSON(w, r, &req, httpx.CodeInvalidManifest) {
+		return
+	}
+	if req.Force && !httpx.CheckScope(w, r, auth.ScopeAdmin) {
+		return
+	}
+	if p, ok := auth.FromContext(r.Context()); ok {
+		req.Author = p.ID
+	}
+	slog.DebugContext(r.Context(), "finalize", "branch", req.Branch, "parent_commit_id", req.ParentCommitID, "force", req.Force,
+		"entries", len(req.Entries), "upserts", len(req.Upserts), "deletes", len(req.Deletes))
+	res, err := s.push.Finalize(r.Context(), box, req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	observe.AddLogAttrs(r.Context(), slog.String("commit_id", res.CommitID))
+	httpx.JSON(w, http.StatusCreated, res)
+}
+
+// handleMerge serves POST /v0/boxes/{box}/merge, merging a branch or commit
+// into a branch. A new merge commit is 201 Created; a fast-forward or a
+// no-op is 200 OK.
+func (s *Server) handleMerge(w http.ResponseWriter, r *http.Request) {
+	box, ok := s.loadBox(w, r)
+	if !ok {
+		return
+	}
+	var req domain.MergeRequest
+	if !s.decodeJSON(w, r, &req, httpx.CodeBadRequest) {
+		return
+	}
+	if p, ok := auth.FromContext(r.Context()); ok {
+		req.Author = p.ID
+	}
+	res, err := s.push.Merge(r.Context(), box, req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	observe.AddLogAttrs(r.Context(), slog.String("commit_id", res.CommitID), slog.String("merge", res.Status))
+	status := http.StatusOK
+	if res.Status == domain.MergeCommitted {
+		status = http.StatusCreated
+	}
+	httpx.JSON(w, status, res)
+}
+
+// handleListAudit serves GET /v0/admin/audit, filtered by principal, action,
+// target, box (name), since/until (RFC 3339), before (event ID cursor) and limit.
+func (s *Server) handleListAudit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := metastore.AuditFilter{
+		Principal: q.Get("principal"),
+		Action:    q.Get("action"),
+		Target:    q.Get("target"),
+	}
+	for _, t := range []struct {
+		name string
+		dst  *string
+	}{{"since", &f.Since}, {"until", &f.Until}} {
+		if v := q.Get(t.name); v != "" {
+			ts, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				httpx.Error(w, r, http.StatusBadRequest, httpx.CodeBadRequest, t.name+" must be an RFC 3339 timestamp", nil)
+				return
+			}
+			*t.dst = ts.UTC().Format(metastore.TimeFormat)
+		}
+	}
+	if v := q.Get("before"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			httpx.Error(w, r, http.StatusBadRequest, httpx.CodeBadRequest, "before must be a positive event id", nil)
+			return
+		}
+		f.BeforeID = id
+	}
+	f.Limit = 100
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 1000 {
+			httpx.Error(w, r, http.StatusBadRequest, httpx.CodeBadRequest, "limit must be between 1 and 1000", nil)
+			return
+		}
+		f.Limit = n
+	}
+	if name := q.Get("box"); name != "" {
+		box, err := s.boxes.Get(r.Context(), name)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		f.BoxID = box.ID
+	}
+	events, err := s.audit.List(r.Context(), f)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	resp := struct {
+		Events     []metastore.AuditEvent `json:"events"`
+		NextBefore int64                  `json:"next_before,omitempty"`
+	}{Events: events}
+	if len(events) == f.Limit {
+		resp.NextBefore = events[len(events)-1].ID
+	}
+	httpx.JSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleHeadBlob(w http.ResponseWriter, r *http.Request) {
+	ok, err := s.push.HasBlob(r.Context(), r.PathValue("sha256"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if !ok {
+		writeError(w, r, domain.ErrBlobNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleCheckBlobs serves POST /v0/blobs/batch/check: {"sha256": [...]} → {"missing": [...]}.
+func (s *Server) handleCheckBlobs(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		SHA256 []string `json:"sha256"`
+	}
+	if !s.decodeJSON(w, r, &req, httpx.CodeBadRequest) {
+		return
+	}
+	missing, err := s.push.CheckBlobs(r.Context(), req.SHA256)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	httpx.JSON(w, http.StatusOK, map[string]any{"missing": missing})
+}
+
+// handlePutBlobs serves POST /v0/blobs/batch, a stream of framed blobs (see domain.PushService.PutBlobs).
+func (s *Server) handlePutBlobs(w http.ResponseWriter, r *http.Request) {
+	res, err := s.push.PutBlobs(r.Context(), r.Body)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	httpx.JSON(w, http.StatusOK, res)
+}
+
+func (s *Server) handlePutBlob(w http.ResponseWriter, r *http.Request) {
+	size := r.ContentLength
+	if size < 0 {
+		httpx.Error(w, r, http.StatusLengthRequired, httpx.CodeLengthRequired, "Content-Length required", nil)
+		return
+	}
+	if err := s.push.PutBlob(r.Context(), r.PathValue("sha256"), r.Body, size); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// handleFile serves GET /v0/files/{commit_id}?path=... with ETag and Range support.
+func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		httpx.Error(w, r, http.StatusBadRequest, httpx.CodeBadRequest, "path query parameter required", nil)
+		return
+	}
+	observe.AddLogAttrs(r.Context(), slog.String("commit_id", r.PathValue("commit_id")))
+	f, err := s.files.Open(r.Context(), r.PathValue("commit_id"), p)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer f.Body.Close()
+	serveBlob(w, r, f)
+}
+
+// handleBoxFile serves GET /v0/boxes/{box}/files/{ref}?path=<file>, where
+// ref is a tag, branch or commit ID.
+func (s *Server) handleBoxFile(w http.ResponseWriter, r *http.Request) {
+	box, ok := s.loadBox(w, r)
+	if !ok {
+		return
+	}
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		httpx.Error(w, r, http.StatusBadRequest, httpx.CodeBadRequest, "path query parameter required", nil)
+		return
+	}
+	observe.AddLogAttrs(r.Context(), slog.String("ref", r.PathValue("ref")))
+	f, err := s.files.OpenIn(r.Context(), box, r.PathValue("ref"), p)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer f.Body.Close()
+	serveBlob(w, r, f)
+}
+
+// handleArchive serves GET /v0/boxes/{box}/archive/{ref}?format=zip|tar.gz&path=dir.
+func (s *Server) handleArchive(w http.ResponseWriter, r *http.Request) {
+	box, ok := s.loadBox(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = domain.ArchiveZip
+	}
+	a, err := s.files.Archive(r.Context(), box, r.PathValue("ref"), q.Get("path"), format)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	observe.AddLogAttrs(r.Context(), slog.String("commit_id", a.CommitID))
+	ct := "application/zip"
+	if format == domain.ArchiveTarGz {
+		ct = "application/gzip"
+	}
+	w.Header().Set("Content-Type", ct)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename()}))
+	etag := a.ETag()
+	w.Header().Set("ETag", etag)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && inm == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if err := a.Write(r.Context(), w); err != nil {
+		// Headers are gone; all we can do is log and cut the response short.
+		slog.ErrorContext(r.Context(), "archive write failed", "commit_id", a.CommitID, "error", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// serveBlob streams f honoring If-None-Match and single byte ranges.
+func serveBlob(w http.ResponseWriter, r *http.Request, f domain.File) {
+	rc, size := f.Body, f.Size
+	etag := "W/\"sha256:" + f.Entry.SHA256 + "\""
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Accept-Ranges", "bytes")
+	if ct := mime.TypeByExtension(path.Ext(f.Entry.Path)); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" && inm == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	// Handle Range header: bytes=start-end or bytes=start-
+	rangeHdr := r.Header.Get("Range")
+	if rangeHdr != "" && strings.HasPrefix(rangeHdr, "bytes=") {
+		rng := strings.TrimPrefix(rangeHdr, "bytes=")
+		var start, end int64
+		end = size - 1
+		if strings.Contains(rng, "-") {
+			parts := strings.SplitN(rng, "-", 2)
+			if parts[0] != "" {
+				s, _ := strconv.ParseInt(parts[0], 10, 64)
+				start = s
+			}
+			if parts[1] != "" {
+				e, _ := strconv.ParseInt(parts[1], 10, 64)
+				end = e
+			}
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+		if start < 0 || start >= size || end < start {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			httpx.Error(w, r, http.StatusRequestedRangeNotSatisfiable, httpx.CodeRangeNotSatisfiable, "invalid range", nil)
+			return
+		}
+		// Seek if underlying is *os.File
+		if f, ok := rc.(*os.File); ok {
+			_, _ = f.Seek(start, io.SeekStart)
+		} else {
+			// Fallback: discard bytes
+			_, _ = io.CopyN(io.Discard, rc, start)
+		}
+		length := end - start + 1
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", length))
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = io.CopyN(w, rc, length)
+		return
+	}
+	// No Range: stream full content
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.Copy(w, rc)
+}
+
+// writeError maps domain errors to API error responses.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var missing *domain.MissingBlobError
+	var mismatch *domain.ParentMismatchError
+	var tooLarge *domain.LimitError
+	var quota *domain.QuotaError
+	var offset *domain.OffsetMismatchError
+	var digest *domain.DigestMismatchError
+	var conflict *domain.MergeConflictError
+	var protected *domain.ProtectionError
+	switch {
+	case errors.As(err, &missing):
+		httpx.ErrorDetails(w, r, http.StatusUnprocessableEntity, httpx.CodeMissingBlob, "blob not uploaded",
+			map[string]string{"sha256": missing.SHA256, "path": missing.Path}, nil)
+	case errors.As(err, &mismatch):
+		httpx.ErrorDetails(w, r, http.StatusConflict, httpx.CodeParentMismatch, "branch head does not match parent_commit_id",
+			map[string]string{"branch": mismatch.Branch, "parent_commit_id": mismatch.ParentCommitID}, nil)
+	case errors.As(err, &conflict):
+		httpx.ErrorDetails(w, r, http.StatusConflict, httpx.CodeMergeConflict, err.Error(),
+			map[string]any{"base_commit_id": conflict.BaseCommitID, "conflicts": conflict.Conflicts}, nil)
+	case errors.As(err, &protected):
+		httpx.ErrorDetails(w, r, http.StatusForbidden, httpx.CodeBranchProtected, err.Error(),
+			map[string]string{"branch": protected.Branch, "pattern": protected.Pattern, "reason": protected.Reason}, nil)
+	case errors.As(err, &tooLarge):
+		httpx.ErrorDetails(w, r, http.StatusRequestEntityTooLarge, httpx.CodePayloadTooLarge, err.Error(),
+			map[string]any{"limit": tooLarge.Limit, "max": tooLarge.Max}, nil)
+	case errors.As(err, &quota):
+		httpx.ErrorDetails(w, r, http.StatusInsufficientStorage, httpx.CodeQuotaExceeded, "namespace storage quota exceeded",
+			map[string]any{"namespace": quota.Namespace, "quota_bytes": quota.Quota, "used_bytes": quota.Used, "needed_bytes": quota.Needed}, nil)
+	case errors.As(err, &offset):
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offset.Offset, 10))
+		httpx.ErrorDetails(w, r, http.StatusConflict, httpx.CodeOffsetMismatch, err.Error(),
+			map[string]int64{"offset": offset.Offset}, nil)
+	case errors.As(err, &digest):
+		httpx.ErrorDetails(w, r, http.StatusUnprocessableEntity, httpx.CodeDigestMismatch, err.Error(),
+			map[string]any{"sha256": digest.SHA256, "index": digest.Index}, nil)
+	case errors.Is(err, domain.ErrUploadsUnsupported):
+		httpx.Error(w, r, http.StatusNotImplemented, httpx.CodeNotImplemented, "blob store does not support resumable uploads; use PUT /v0/blobs/{sha256}", nil)
+	case errors.Is(err, domain.ErrUploadNotFound):
+		httpx.Error(w, r, http.StatusNotFound, httpx.CodeUploadNotFound, "upload session not found or expired", nil)
+	case errors.Is(err, domain.ErrUploadIncomplete):
+		httpx.Error(w, r, http.StatusConflict, httpx.CodeUploadIncomplete, "upload has not received all bytes", nil)
+	case errors.Is(err, domain.ErrDigestMismatch):
+		httpx.Error(w, r, http.StatusUnprocessableEntity, httpx.CodeDigestMismatch, "uploaded bytes do not match sha256", nil)
+	case errors.Is(err, domain.ErrBadSignature):
+		httpx.Error(w, r, http.StatusUnprocessableEntity, httpx.CodeBadSignature, err.Error(), nil)
+	case errors.Is(err, domain.ErrInvalidManifest):
+		httpx.Error(w, r, http.StatusBadRequest, httpx.CodeInvalidManifest, err.Error(), nil)
+	case errors.Is(err, domain.ErrInvalidRequest):
+		httpx.Error(w, r, http.StatusBadRequest, httpx.CodeBadRequest, err.Error(), nil)
+	case errors.Is(err, domain.ErrBoxNotFound):
+		httpx.Error(w, r, http.StatusNotFound, httpx.CodeBoxNotFound, "box not found", nil)
+	case errors.Is(err, domain.ErrCommitNotFound):
+		httpx.Error(w, r, http.StatusNotFound, httpx.CodeCommitNotFound, "commit not found", nil)
+	case errors.Is(err, domain.ErrBranchNotFound):
+		httpx.Error(w, r, http.StatusNotFound, httpx.CodeBranchNotFound, "branch not found", nil)
+	case errors.Is(err, domain.ErrTagNotFound):
+		httpx.Error(w, r, http.StatusNotFound, httpx.CodeTagNotFound, "tag not found", nil)
+	case errors.Is(err, domain.ErrTagExists):
+		httpx.Error(w, r, http.StatusConflict, httpx.CodeTagExists, "tag already exists; moving a tag requires admin scope", nil)
+	case errors.Is(err, domain.ErrRuleNotFound):
+		httpx.Error(w, r, http.StatusNotFound, httpx.CodeNotFound, "no branch rule with this pattern", nil)
+	case errors.Is(err, domain.ErrReflogNotFound):
+		httpx.Error(w, r, http.StatusNotFound, httpx.CodeNotFound, "reflog entry not found for this branch", nil)
+	case errors.Is(err, domain.ErrPathNotFound):
+		httpx.Error(w, r, http.StatusNotFound, httpx.CodePathNotFound, "path not found in commit", nil)
+	case errors.Is(err, domain.ErrBlobNotFound):
+		httpx.Error(w, r, http.StatusNotFound, httpx.CodeBlobNotFound, "blob not found", nil)
+	default:
+		httpx.Internal(w, r, err)
+	}
+}
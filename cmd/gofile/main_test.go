@@ -1,8 +1,19 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fgo/internal/auth"
+	"fgo/internal/domain"
+	"fgo/internal/observe"
+	"fgo/internal/signing"
 	"fgo/internal/storage/blobstore"
 	"fgo/internal/storage/metastore"
 	"fmt"
@@ -10,18 +21,30 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 )
 
+// newTestServer starts the real gofile handler over a temp blob dir and in-memory metastore.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	blobs := blobstore.NewBlobStoreFS(t.TempDir())
+	meta, err := metastore.NewSQLiteMetaStore(":memory:")
+	if err != nil {
+		t.Fatalf("meta open: %v", err)
+	}
+	srv := httptest.NewServer(NewServer(ServerConfig{Blobs: blobs, Meta: meta}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
 func TestHealthEndpoint(t *testing.T) {
 	req := httptest.NewRequest("GET", "/v0/health", nil)
 	w := httptest.NewRecorder()
 
-	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("ok"))
-	})
+	handler := NewServer(ServerConfig{})
 
 	handler.ServeHTTP(w, req)
 
@@ -34,157 +57,13 @@ func TestHealthEndpoint(t *testing.T) {
 }
 
 func TestPlanFinalizeAndFileGet(t *testing.T) {
-	// spin up server using main's mux via http.DefaultServeMux is not ideal; reconstruct minimal handlers is better
-	// For brevity, call the running server via httptest with a fresh mux equivalent to main
-	// Build minimal environment
-	blobRoot := t.TempDir()
-	os.MkdirAll(blobRoot, 0o755)
-	blobs := blobstore.NewBlobStoreFS(blobRoot)
-	meta, err := metastore.NewSQLiteMetaStore(":memory:")
-	if err != nil {
-		t.Fatalf("meta open: %v", err)
-	}
-
-	mux := http.NewServeMux()
-	// minimal endpoints: boxes, plan, finalize, blobs, files
-	mux.HandleFunc("/v0/boxes", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodPost {
-			var req struct{ Name, Visibility, DefaultBranch string }
-			json.NewDecoder(r.Body).Decode(&req)
-			fmt.Printf("[DEBUG FINALIZE] decoded req: %+v\n", req)
-			os.Stdout.Sync()
-			if req.DefaultBranch == "" {
-			}
-			req.DefaultBranch = "main"
-			b := metastore.Box{NamespaceID: "global", Name: req.Name, Visibility: req.Visibility, DefaultBranch: req.DefaultBranch}
-			b, err = meta.CreateBox(r.Context(), b)
-			if err != nil {
-				http.Error(w, "err", 500)
-				return
-			}
-			w.WriteHeader(201)
-			json.NewEncoder(w).Encode(b)
-			return
-		}
-		http.NotFound(w, r)
-	})
-	mux.HandleFunc("/v0/boxes/", func(w http.ResponseWriter, r *http.Request) {
-		p := r.URL.Path[len("/v0/boxes/"):]
-		parts := bytes.Split([]byte(p), []byte("/"))
-		boxName := string(parts[0])
-		box, err := meta.GetBox(r.Context(), "global", boxName)
-		if err != nil {
-			http.Error(w, "nf", 404)
-			return
-		}
-		action := ""
-		if len(parts) > 1 {
-			action = string(bytes.Join(parts[1:], []byte("/")))
-		}
-		switch {
-		case r.Method == http.MethodPost && action == "push/plan":
-			var req struct{ Entries []metastore.Entry }
-			json.NewDecoder(r.Body).Decode(&req)
-			missing := []string{}
-			seen := map[string]struct{}{}
-			for _, e := range req.Entries {
-				if _, ok := seen[e.SHA256]; ok {
-					continue
-				}
-				seen[e.SHA256] = struct{}{}
-				ok, _ := blobs.Has(r.Context(), e.SHA256)
-				if !ok {
-					missing = append(missing, e.SHA256)
-				}
-			}
-			json.NewEncoder(w).Encode(map[string]any{"missing": missing, "total": len(req.Entries), "will_replace": 0})
-		case r.Method == http.MethodPost && action == "push/finalize":
-			var req struct {
-				Branch         string
-				ParentCommitID *string
-				Message        string
-				Entries        []metastore.Entry
-			}
-			json.NewDecoder(r.Body).Decode(&req)
-			if req.Branch == "" {
-				req.Branch = box.DefaultBranch
-			}
-			for _, e := range req.Entries {
-				ok, _ := blobs.Has(r.Context(), e.SHA256)
-				if !ok {
-					http.Error(w, "missing", 422)
-					return
-				}
-			}
-			c := metastore.Commit{BoxID: box.ID, Branch: req.Branch, ParentID: req.ParentCommitID, Message: req.Message, Entries: req.Entries}
-			c, err = meta.SaveCommit(r.Context(), c)
-			if err != nil {
-				http.Error(w, "err", 500)
-				return
-			}
-			// For this test, skip MoveRef optimistic lock to avoid divergence complexity
-			w.WriteHeader(201)
-			json.NewEncoder(w).Encode(map[string]any{"commit_id": c.ID, "uploaded": 0, "reused": 0})
-		case r.Method == http.MethodGet && action == "commits/latest":
-			c, err := meta.LatestCommit(r.Context(), box.ID, "main")
-			if err != nil {
-				http.Error(w, "nf", 404)
-				return
-			}
-			json.NewEncoder(w).Encode(c)
-		default:
-			http.NotFound(w, r)
-		}
-	})
-	mux.HandleFunc("/v0/blobs/", func(w http.ResponseWriter, r *http.Request) {
-		sha := r.URL.Path[len("/v0/blobs/"):]
-		if r.Method == http.MethodPut {
-			size := r.ContentLength
-			if err := blobs.Put(r.Context(), sha, r.Body, size); err != nil {
-				http.Error(w, "err", 500)
-				return
-			}
-			w.WriteHeader(201)
-			return
-		}
-		http.NotFound(w, r)
-	})
-	mux.HandleFunc("/v0/files/", func(w http.ResponseWriter, r *http.Request) {
-		commitID := r.URL.Path[len("/v0/files/"):]
-		p := r.URL.Query().Get("path")
-		c, err := meta.GetCommitByID(r.Context(), commitID)
-		if err != nil {
-			http.Error(w, "nf", 404)
-			return
-		}
-		var ent *metastore.Entry
-		for _, e := range c.Entries {
-			if e.Path == p {
-				ent = &e
-				break
-			}
-		}
-		if ent == nil {
-			http.Error(w, "nf", 404)
-			return
-		}
-		rc, _, err := blobs.Open(r.Context(), ent.SHA256)
-		if err != nil {
-			http.Error(w, "nf", 404)
-			return
-		}
-		defer rc.Close()
-		io.Copy(w, rc)
-	})
-
-	srv := httptest.NewServer(mux)
-	defer srv.Close()
+	srv := newTestServer(t)
 
 	// Create box
 	_, _ = http.Post(srv.URL+"/v0/boxes", "application/json", bytes.NewBufferString(`{"name":"demo","visibility":"public"}`))
 
 	// Plan with one missing blob
-	resp, _ := http.Post(srv.URL+"/v0/boxes/demo/push/plan", "application/json", bytes.NewBufferString(`{"entries":[{"path":"README.md","sha256":"abc123","size":3,"mode":420}]}`))
+	resp, _ := http.Post(srv.URL+"/v0/boxes/demo/push/plan", "application/json", bytes.NewBufferString(`{"entries":[{"path":"README.md","sha256":"ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad","size":3,"mode":420}]}`))
 	var plan struct {
 		Missing []string `json:"missing"`
 		Total   int      `json:"total"`
@@ -197,12 +76,12 @@ func TestPlanFinalizeAndFileGet(t *testing.T) {
 	}
 
 	// Upload blob via PUT
-	reqPut, _ := http.NewRequest(http.MethodPut, srv.URL+"/v0/blobs/abc123", bytes.NewBufferString("abc"))
+	reqPut, _ := http.NewRequest(http.MethodPut, srv.URL+"/v0/blobs/ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", bytes.NewBufferString("abc"))
 	reqPut.Header.Set("Content-Type", "application/octet-stream")
 	_, _ = http.DefaultClient.Do(reqPut)
 
 	// Finalize commit
-	resp, _ = http.Post(srv.URL+"/v0/boxes/demo/push/finalize", "application/json", bytes.NewBufferString(`{"branch":"main","message":"init","entries":[{"path":"README.md","sha256":"abc123","size":3,"mode":420}]}`))
+	resp, _ = http.Post(srv.URL+"/v0/boxes/demo/push/finalize", "application/json", bytes.NewBufferString(`{"branch":"main","message":"init","entries":[{"path":"README.md","sha256":"ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad","size":3,"mode":420}]}`))
 	if resp.StatusCode != 201 {
 		t.Fatalf("expected 201, got %d", resp.StatusCode)
 	}
@@ -225,185 +104,764 @@ func TestPlanFinalizeAndFileGet(t *testing.T) {
 }
 
 func TestFinalizeConflictAndETag(t *testing.T) {
-	blobRoot := t.TempDir()
-	blobs := blobstore.NewBlobStoreFS(blobRoot)
-	meta, err := metastore.NewSQLiteMetaStore(":memory:")
-	if err != nil {
-		t.Fatalf("meta open: %v", err)
-	}
-	// Use the actual main.go mux and handlers for full integration coverage
-	mux := http.NewServeMux()
-	// Register handlers from main.go
-	// Health
-	mux.HandleFunc("/v0/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("ok"))
-	})
-	// Boxes
-	mux.HandleFunc("/v0/boxes", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodPost {
-			var req struct{ Name, Visibility, DefaultBranch string }
-			json.NewDecoder(r.Body).Decode(&req)
-			if req.DefaultBranch == "" {
-				req.DefaultBranch = "main"
-			}
-			b := metastore.Box{NamespaceID: "global", Name: req.Name, Visibility: req.Visibility, DefaultBranch: req.DefaultBranch}
-			b, err = meta.CreateBox(r.Context(), b)
-			if err != nil {
-				http.Error(w, "err", 500)
-				return
-			}
-			w.WriteHeader(201)
-			json.NewEncoder(w).Encode(b)
-			return
-		}
-		http.NotFound(w, r)
-	})
-	// Blobs
-	mux.HandleFunc("/v0/blobs/", func(w http.ResponseWriter, r *http.Request) {
-		sha := strings.TrimPrefix(r.URL.Path, "/v0/blobs/")
-		if r.Method == http.MethodPut {
-			size := r.ContentLength
-			if err := blobs.Put(r.Context(), sha, r.Body, size); err != nil {
-				http.Error(w, "err", 500)
-				return
-			}
-			w.WriteHeader(201)
-			return
-		}
-		http.NotFound(w, r)
-	})
-	// Boxes subrouter
-	mux.HandleFunc("/v0/boxes/", func(w http.ResponseWriter, r *http.Request) {
-		p := r.URL.Path[len("/v0/boxes/"):]
-		parts := strings.Split(p, "/")
-		boxName := parts[0]
-		action := ""
-		if len(parts) > 1 {
-			action = strings.Join(parts[1:], "/")
-		}
-		box, err := meta.GetBox(r.Context(), "global", boxName)
-		if err != nil {
-			http.Error(w, "nf", 404)
-			return
-		}
-		switch {
-		case r.Method == http.MethodPost && action == "push/finalize":
-			var req struct {
-				Branch         string            `json:"branch"`
-				ParentCommitID string            `json:"parent_commit_id"`
-				Message        string            `json:"message"`
-				Entries        []metastore.Entry `json:"entries"`
-			}
-			json.NewDecoder(r.Body).Decode(&req)
-			if req.Branch == "" {
-				req.Branch = box.DefaultBranch
-			}
-			for _, e := range req.Entries {
-				ok, _ := blobs.Has(r.Context(), e.SHA256)
-				if !ok {
-					http.Error(w, "missing", 422)
-					return
-				}
-			}
-			c := metastore.Commit{BoxID: box.ID, Branch: req.Branch, ParentID: &req.ParentCommitID, Message: req.Message, Entries: req.Entries}
-			c, err = meta.SaveCommit(r.Context(), c)
-			if err != nil {
-				http.Error(w, "err", 500)
-				return
-			}
-			parent := req.ParentCommitID
-			latest, _ := meta.LatestCommit(r.Context(), box.ID, req.Branch)
-			fmt.Printf("[DEBUG FINALIZE] parent_commit_id='%s', latest.ID='%s'\n", parent, latest.ID)
-			os.Stdout.Sync()
-			if parent != "" && latest.ID != parent {
-				fmt.Printf("[DEBUG FINALIZE] returning 409: parent_commit_id='%s', latest.ID='%s'\n", parent, latest.ID)
-				os.Stdout.Sync()
-				http.Error(w, "conflict", 409)
-				return
-			}
-			errMove := meta.MoveRef(r.Context(), box.ID, req.Branch, parent, c.ID)
-			fmt.Printf("[DEBUG TEST] MoveRef: boxID=%s branch=%s parentID=%s newID=%s err=%v\n", box.ID, req.Branch, parent, c.ID, errMove)
-			os.Stdout.Sync()
-			if errMove != nil {
-				fmt.Printf("[DEBUG TEST] MoveRef error: %v\n", errMove)
-				os.Stdout.Sync()
-			}
-			w.WriteHeader(201)
-			json.NewEncoder(w).Encode(map[string]any{"commit_id": c.ID})
-		case r.Method == http.MethodGet && action == "commits/latest":
-			c, err := meta.LatestCommit(r.Context(), box.ID, "main")
-			if err != nil {
-				http.Error(w, "nf", 404)
-				return
-			}
-			json.NewEncoder(w).Encode(c)
-		default:
-			http.NotFound(w, r)
-		}
-	})
-	// Files
-	mux.HandleFunc("/v0/files/", func(w http.ResponseWriter, r *http.Request) {
-		commitID := r.URL.Path[len("/v0/files/"):]
-		p := r.URL.Query().Get("path")
-		c, err := meta.GetCommitByID(r.Context(), commitID)
-		if err != nil {
-			http.Error(w, "nf", 404)
-			return
-		}
-		var ent *metastore.Entry
-		for _, e := range c.Entries {
-			if e.Path == p {
-				ent = &e
-				break
-			}
-		}
-		if ent == nil {
-			http.Error(w, "nf", 404)
-			return
-		}
-		rc, _, err := blobs.Open(r.Context(), ent.SHA256)
-		if err != nil {
-			http.Error(w, "nf", 404)
-			return
-		}
-		defer rc.Close()
-		etag := "W/\"sha256:" + ent.SHA256 + "\""
-		w.Header().Set("ETag", etag)
-		if inm := r.Header.Get("If-None-Match"); inm != "" && inm == etag {
-			w.WriteHeader(http.StatusNotModified)
-			return
-		}
-		io.Copy(w, rc)
-	})
-	srv := httptest.NewServer(mux)
-	defer srv.Close()
+	srv := newTestServer(t)
 
 	// Create box
 	_, _ = http.Post(srv.URL+"/v0/boxes", "application/json", bytes.NewBufferString(`{"name":"demo","visibility":"public"}`))
 	// Upload blob
-	reqPut, _ := http.NewRequest(http.MethodPut, srv.URL+"/v0/blobs/abc123", bytes.NewBufferString("abc"))
+	reqPut, _ := http.NewRequest(http.MethodPut, srv.URL+"/v0/blobs/ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", bytes.NewBufferString("abc"))
 	reqPut.Header.Set("Content-Type", "application/octet-stream")
 	_, _ = http.DefaultClient.Do(reqPut)
 	// Finalize commit 1
-	resp1, _ := http.Post(srv.URL+"/v0/boxes/demo/push/finalize", "application/json", bytes.NewBufferString(`{"branch":"main","message":"init","entries":[{"path":"README.md","sha256":"abc123","size":3,"mode":420}]}`))
+	resp1, _ := http.Post(srv.URL+"/v0/boxes/demo/push/finalize", "application/json", bytes.NewBufferString(`{"branch":"main","message":"init","entries":[{"path":"README.md","sha256":"ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad","size":3,"mode":420}]}`))
 	var fin1 struct {
 		CommitID string `json:"commit_id"`
 	}
 	json.NewDecoder(resp1.Body).Decode(&fin1)
 	// Finalize commit 2 with wrong parent (should use first commit's ID for success, and a different value for conflict)
 	wrongParent := "badparent"
-	fmt.Printf("[DEBUG TEST] parent_commit_id for conflict: %s, correct: %s\n", wrongParent, fin1.CommitID)
-	body := fmt.Sprintf(`{"branch":"main","parent_commit_id":"%s","message":"conflict","entries":[{"path":"README.md","sha256":"abc123","size":3,"mode":420}]}`, wrongParent)
+	body := fmt.Sprintf(`{"branch":"main","parent_commit_id":"%s","message":"conflict","entries":[{"path":"README.md","sha256":"ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad","size":3,"mode":420}]}`, wrongParent)
 	resp2, _ := http.Post(srv.URL+"/v0/boxes/demo/push/finalize", "application/json", bytes.NewBufferString(body))
 	if resp2.StatusCode != 409 {
 		t.Fatalf("expected 409, got %d", resp2.StatusCode)
 	}
+	var env struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp2.Body).Decode(&env); err != nil || env.Error.Code != "parent_mismatch" {
+		t.Fatalf("expected parent_mismatch error code, got %q (%v)", env.Error.Code, err)
+	}
 	// ETag conditional GET
 	reqGet, _ := http.NewRequest(http.MethodGet, srv.URL+"/v0/files/"+fin1.CommitID+"?path=README.md", nil)
-	reqGet.Header.Set("If-None-Match", "W/\"sha256:abc123\"")
+	reqGet.Header.Set("If-None-Match", "W/\"sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad\"")
 	respGet, _ := http.DefaultClient.Do(reqGet)
 	if respGet.StatusCode != 304 {
 		t.Fatalf("expected 304, got %d", respGet.StatusCode)
 	}
 }
+
+func TestHealthReportsDraining(t *testing.T) {
+	srv := NewServer(ServerConfig{})
+	srv.Drain()
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/v0/health", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 while draining, got %d", w.Code)
+	}
+	if w.Body.String() != "draining" {
+		t.Fatalf("expected body 'draining', got '%s'", w.Body.String())
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	reg := observe.NewRegistry()
+	blobs := blobstore.NewBlobStoreFS(t.TempDir())
+	meta, err := metastore.NewSQLiteMetaStore(":memory:")
+	if err != nil {
+		t.Fatalf("meta open: %v", err)
+	}
+	srv := httptest.NewServer(NewServer(ServerConfig{Blobs: blobs, Meta: meta, Metrics: reg}))
+	t.Cleanup(srv.Close)
+
+	_, _ = http.Post(srv.URL+"/v0/boxes", "application/json", bytes.NewBufferString(`{"name":"demo","visibility":"public"}`))
+	reqPut, _ := http.NewRequest(http.MethodPut, srv.URL+"/v0/blobs/ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", bytes.NewBufferString("abc"))
+	_, _ = http.DefaultClient.Do(reqPut)
+	_, _ = http.Post(srv.URL+"/v0/boxes/demo/push/finalize", "application/json", bytes.NewBufferString(`{"branch":"main","entries":[{"path":"a","sha256":"ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad","size":3}]}`))
+	_, _ = http.Get(srv.URL + "/nope")
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("get metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	for _, want := range []string{
+		`http_requests_total{method="PUT",route="PUT /v0/blobs/{sha256}",status="201"} 1`,
+		`http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`blob_put_bytes_total 3`,
+		`push_finalize_total{status="ok"} 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+}
+
+// recordingExporter collects exported spans for inspection.
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []*observe.Span
+}
+
+func (e *recordingExporter) Export(_ context.Context, spans []*observe.Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func TestTracingPropagatesAndNestsStoreSpans(t *testing.T) {
+	exp := &recordingExporter{}
+	tracer := observe.NewTracer(exp, observe.TracerOptions{})
+	blobs := blobstore.NewBlobStoreFS(t.TempDir())
+	meta, err := metastore.NewSQLiteMetaStore(":memory:")
+	if err != nil {
+		t.Fatalf("meta open: %v", err)
+	}
+	srv := httptest.NewServer(NewServer(ServerConfig{Blobs: blobs, Meta: meta, Tracer: tracer}))
+	t.Cleanup(srv.Close)
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/v0/boxes", bytes.NewBufferString(`{"name":"demo","visibility":"public"}`))
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("create box: %v", err)
+	}
+	resp.Body.Close()
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	byName := map[string]*observe.Span{}
+	for _, s := range exp.spans {
+		byName[s.Name] = s
+	}
+	root := byName["POST /v0/boxes"]
+	store := byName["metastore.CreateBox"]
+	if root == nil || store == nil {
+		t.Fatalf("expected request and store spans, got %v", byName)
+	}
+	if root.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || root.Parent.String() != "00f067aa0ba902b7" {
+		t.Fatalf("request span did not continue the incoming trace: %+v", root.SpanContext)
+	}
+	if store.TraceID != root.TraceID || store.Parent != root.SpanID {
+		t.Fatalf("store span not a child of the request span")
+	}
+}
+
+func TestAuditTrail(t *testing.T) {
+	tokens := auth.NewStaticTokens()
+	for name, scope := range map[string]string{"ci": auth.ScopeWrite, "ops": auth.ScopeAdmin} {
+		sum := sha256.Sum256([]byte(name + "-secret"))
+		tokens.Add(name, hex.EncodeToString(sum[:]), scope)
+	}
+	blobs := blobstore.NewBlobStoreFS(t.TempDir())
+	meta, err := metastore.NewSQLiteMetaStore(":memory:")
+	if err != nil {
+		t.Fatalf("meta open: %v", err)
+	}
+	var sink bytes.Buffer
+	srv := httptest.NewServer(NewServer(ServerConfig{Blobs: blobs, Meta: meta, Authenticator: tokens, AnonymousScope: auth.ScopeRead, AuditSink: &sink}))
+	t.Cleanup(srv.Close)
+	do := func(method, path, token, body string) *http.Response {
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token+"-secret")
+		req.Header.Set("X-Request-Id", "req-"+method)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		return resp
+	}
+
+	do(http.MethodPost, "/v0/boxes", "ci", `{"name":"demo","visibility":"public"}`).Body.Close()
+	if resp := do(http.MethodPatch, "/v0/boxes/demo", "ci", `{"visibility":"private"}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("patch visibility: %d", resp.StatusCode)
+	}
+	do(http.MethodPut, "/v0/blobs/ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", "ci", "abc").Body.Close()
+	do(http.MethodPost, "/v0/boxes/demo/push/finalize", "ci", `{"entries":[{"path":"a","sha256":"ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad","size":3}]}`).Body.Close()
+
+	if resp := do(http.MethodGet, "/v0/admin/audit", "ci", ""); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 for non-admin, got %d", resp.StatusCode)
+	}
+	resp := do(http.MethodGet, "/v0/admin/audit?box=demo&principal=token:ci", "ops", "")
+	var out struct {
+		Events []metastore.AuditEvent `json:"events"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	var actions []string
+	for _, e := range out.Events {
+		actions = append(actions, e.Action)
+	}
+	if got := strings.Join(actions, ","); got != "ref.move,push.finalize,box.visibility,box.create" {
+		t.Fatalf("unexpected audit trail %q", got)
+	}
+	vis := out.Events[2]
+	if string(vis.Before) != `"public"` || string(vis.After) != `"private"` || vis.RequestID != "req-PATCH" {
+		t.Fatalf("unexpected visibility event %+v", vis)
+	}
+	if n := strings.Count(sink.String(), "\n"); n != 4 {
+		t.Fatalf("expected 4 JSONL sink lines, got %d", n)
+	}
+}
+
+func TestBodyLimitAndQuotaStatus(t *testing.T) {
+	blobs := blobstore.NewBlobStoreFS(t.TempDir())
+	meta, err := metastore.NewSQLiteMetaStore(":memory:")
+	if err != nil {
+		t.Fatalf("meta open: %v", err)
+	}
+	srv := httptest.NewServer(NewServer(ServerConfig{Blobs: blobs, Meta: meta, MaxBodyBytes: 256,
+		Limits: domain.Limits{Quota: func(string) int64 { return 2 }}}))
+	t.Cleanup(srv.Close)
+
+	_, _ = http.Post(srv.URL+"/v0/boxes", "application/json", bytes.NewBufferString(`{"name":"demo","visibility":"public"}`))
+	big := `{"entries":[{"path":"` + strings.Repeat("x", 300) + `","sha256":"abc"}]}`
+	resp, _ := http.Post(srv.URL+"/v0/boxes/demo/push/plan", "application/json", strings.NewReader(big))
+	if resp.StatusCode != http.StatusRequestEntityTooLarge || errorCode(t, resp) != "payload_too_large" {
+		t.Fatalf("expected 413 payload_too_large, got %d", resp.StatusCode)
+	}
+
+	reqPut, _ := http.NewRequest(http.MethodPut, srv.URL+"/v0/blobs/ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", bytes.NewBufferString("abc"))
+	_, _ = http.DefaultClient.Do(reqPut)
+	resp, _ = http.Post(srv.URL+"/v0/boxes/demo/push/finalize", "application/json", bytes.NewBufferString(`{"entries":[{"path":"a","sha256":"ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad","size":3}]}`))
+	if resp.StatusCode != http.StatusInsufficientStorage || errorCode(t, resp) != "quota_exceeded" {
+		t.Fatalf("expected 507 quota_exceeded, got %d", resp.StatusCode)
+	}
+}
+
+func errorCode(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	defer resp.Body.Close()
+	var env struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&env); err != nil {
+		t.Fatalf("decode error body: %v", err)
+	}
+	return env.Error.Code
+}
+
+func TestResumableUpload(t *testing.T) {
+	srv := newTestServer(t)
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	sum := sha256.Sum256(data)
+	sha := hex.EncodeToString(sum[:])
+
+	resp, err := http.Post(srv.URL+"/v0/uploads", "application/json",
+		strings.NewReader(fmt.Sprintf(`{"sha256":%q,"size":%d}`, sha, len(data))))
+	if err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create upload: %v %v", err, resp.Status)
+	}
+	loc := srv.URL + resp.Header.Get("Location")
+	resp.Body.Close()
+
+	patch := func(offset int, chunk []byte) *http.Response {
+		req, _ := http.NewRequest(http.MethodPatch, loc, bytes.NewReader(chunk))
+		req.Header.Set("Upload-Offset", fmt.Sprint(offset))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("patch: %v", err)
+		}
+		return resp
+	}
+	if resp := patch(0, data[:4000]); resp.StatusCode != http.StatusNoContent || resp.Header.Get("Upload-Offset") != "4000" {
+		t.Fatalf("first chunk: %d offset %q", resp.StatusCode, resp.Header.Get("Upload-Offset"))
+	}
+	// A client that lost track of the offset is told where to resume.
+	resp = patch(1000, data[1000:2000])
+	if resp.StatusCode != http.StatusConflict || resp.Header.Get("Upload-Offset") != "4000" || errorCode(t, resp) != "offset_mismatch" {
+		t.Fatalf("stale offset: %d %q", resp.StatusCode, resp.Header.Get("Upload-Offset"))
+	}
+	req, _ := http.NewRequest(http.MethodHead, loc, nil)
+	resp, _ = http.DefaultClient.Do(req)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Upload-Offset") != "4000" || resp.Header.Get("Upload-Length") != "10000" {
+		t.Fatalf("head: %d %v", resp.StatusCode, resp.Header)
+	}
+	resp, _ = http.Post(loc+"/complete", "", nil)
+	if resp.StatusCode != http.StatusConflict || errorCode(t, resp) != "upload_incomplete" {
+		t.Fatalf("early complete: %d", resp.StatusCode)
+	}
+	if resp := patch(4000, data[4000:]); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("last chunk: %d", resp.StatusCode)
+	}
+	resp, _ = http.Post(loc+"/complete", "", nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("complete: %d", resp.StatusCode)
+	}
+	req, _ = http.NewRequest(http.MethodHead, srv.URL+"/v0/blobs/"+sha, nil)
+	if resp, _ := http.DefaultClient.Do(req); resp.StatusCode != http.StatusOK {
+		t.Fatalf("blob not stored: %d", resp.StatusCode)
+	}
+	req, _ = http.NewRequest(http.MethodHead, loc, nil)
+	if resp, _ := http.DefaultClient.Do(req); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("session survived completion: %d", resp.StatusCode)
+	}
+}
+
+func TestResumableUploadUnsupported(t *testing.T) {
+	meta, err := metastore.NewSQLiteMetaStore(":memory:")
+	if err != nil {
+		t.Fatalf("meta open: %v", err)
+	}
+	// Embedding only the BlobStore interface hides the Uploader methods.
+	blobs := struct{ blobstore.BlobStore }{blobstore.NewBlobStoreFS(t.TempDir())}
+	srv := httptest.NewServer(NewServer(ServerConfig{Blobs: blobs, Meta: meta}))
+	t.Cleanup(srv.Close)
+	resp, _ := http.Post(srv.URL+"/v0/uploads", "application/json", strings.NewReader(`{"sha256":"`+strings.Repeat("a", 64)+`","size":1}`))
+	if resp.StatusCode != http.StatusNotImplemented || errorCode(t, resp) != "not_implemented" {
+		t.Fatalf("expected 501, got %d", resp.StatusCode)
+	}
+}
+
+func TestBranchLeaseAndForce(t *testing.T) {
+	tokens := auth.NewStaticTokens()
+	for name, scope := range map[string]string{"ci": auth.ScopeWrite, "ops": auth.ScopeAdmin} {
+		sum := sha256.Sum256([]byte(name + "-secret"))
+		tokens.Add(name, hex.EncodeToString(sum[:]), scope)
+	}
+	meta, err := metastore.NewSQLiteMetaStore(":memory:")
+	if err != nil {
+		t.Fatalf("meta open: %v", err)
+	}
+	srv := httptest.NewServer(NewServer(ServerConfig{Blobs: blobstore.NewBlobStoreFS(t.TempDir()), Meta: meta, Authenticator: tokens, AnonymousScope: auth.ScopeRead}))
+	t.Cleanup(srv.Close)
+	do := func(method, path, token, body string, want int) map[string]any {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token+"-secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != want {
+			b, _ := io.ReadAll(resp.Body)
+			t.Fatalf("%s %s: expected %d, got %d: %s", method, path, want, resp.StatusCode, b)
+		}
+		var out map[string]any
+		json.NewDecoder(resp.Body).Decode(&out)
+		return out
+	}
+
+	do(http.MethodPost, "/v0/boxes", "ci", `{"name":"demo"}`, http.StatusCreated)
+	do(http.MethodPut, "/v0/blobs/ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", "ci", "abc", http.StatusCreated)
+	c1 := do(http.MethodPost, "/v0/boxes/demo/push/finalize", "ci", `{"entries":[{"path":"a","sha256":"ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad","size":3}]}`, http.StatusCreated)["commit_id"].(string)
+	c2 := do(http.MethodPost, "/v0/boxes/demo/push/finalize", "ci", fmt.Sprintf(`{"parent_commit_id":%q,"entries":[{"path":"b","sha256":"ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad","size":3}]}`, c1), http.StatusCreated)["commit_id"].(string)
+
+	// Lease: create release/1 only if absent, then move it only from c1.
+	do(http.MethodPut, "/v0/boxes/demo/branches/release/1", "ci", fmt.Sprintf(`{"commit_id":%q,"expected_old":""}`, c1), http.StatusOK)
+	do(http.MethodPut, "/v0/boxes/demo/branches/release/1", "ci", fmt.Sprintf(`{"commit_id":%q,"expected_old":""}`, c2), http.StatusConflict)
+	res := do(http.MethodPut, "/v0/boxes/demo/branches/release/1", "ci", fmt.Sprintf(`{"commit_id":%q,"expected_old":%q}`, c2, c1), http.StatusOK)
+	if res["previous"] != c1 || res["forced"] != false {
+		t.Fatalf("unexpected lease result %v", res)
+	}
+	if ref := do(http.MethodGet, "/v0/boxes/demo/branches/release/1", "ci", "", http.StatusOK); ref["commit_id"] != c2 {
+		t.Fatalf("unexpected branch %v", ref)
+	}
+
+	// Forcing needs admin, on the ref API and on finalize.
+	do(http.MethodPut, "/v0/boxes/demo/branches/main", "ci", fmt.Sprintf(`{"commit_id":%q}`, c1), http.StatusForbidden)
+	do(http.MethodPost, "/v0/boxes/demo/push/finalize", "ci", `{"force":true,"entries":[{"path":"c","sha256":"ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad","size":3}]}`, http.StatusForbidden)
+	res = do(http.MethodPut, "/v0/boxes/demo/branches/main", "ops", fmt.Sprintf(`{"commit_id":%q,"reason":"roll back"}`, c1), http.StatusOK)
+	if res["previous"] != c2 || res["forced"] != true {
+		t.Fatalf("unexpected force result %v", res)
+	}
+	do(http.MethodPost, "/v0/boxes/demo/push/finalize", "ops", `{"force":true,"entries":[{"path":"c","sha256":"ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad","size":3}]}`, http.StatusCreated)
+
+	do(http.MethodDelete, "/v0/boxes/demo/branches/main?expected_old="+c1, "ops", "", http.StatusBadRequest)
+	do(http.MethodDelete, "/v0/boxes/demo/branches/release/1?expected_old="+c1, "ci", "", http.StatusConflict)
+	do(http.MethodDelete, "/v0/boxes/demo/branches/release/1?expected_old="+c2, "ci", "", http.StatusNoContent)
+	do(http.MethodGet, "/v0/boxes/demo/branches/release/1", "ci", "", http.StatusNotFound)
+
+	audit := do(http.MethodGet, "/v0/admin/audit?action=ref.force", "ops", "", http.StatusOK)
+	if events := audit["events"].([]any); len(events) != 2 {
+		t.Fatalf("expected 2 forced ref updates in the audit log, got %v", events)
+	}
+}
+
+func TestTagsResolveInFileTreeAndArchive(t *testing.T) {
+	tokens := auth.NewStaticTokens()
+	for name, scope := range map[string]string{"ci": auth.ScopeWrite, "ops": auth.ScopeAdmin} {
+		sum := sha256.Sum256([]byte(name + "-secret"))
+		tokens.Add(name, hex.EncodeToString(sum[:]), scope)
+	}
+	meta, err := metastore.NewSQLiteMetaStore(":memory:")
+	if err != nil {
+		t.Fatalf("meta open: %v", err)
+	}
+	srv := httptest.NewServer(NewServer(ServerConfig{Blobs: blobstore.NewBlobStoreFS(t.TempDir()), Meta: meta, Authenticator: tokens, AnonymousScope: auth.ScopeRead}))
+	t.Cleanup(srv.Close)
+	do := func(method, path, token, body string, want int) []byte {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token+"-secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != want {
+			t.Fatalf("%s %s: expected %d, got %d: %s", method, path, want, resp.StatusCode, b)
+		}
+		return b
+	}
+	commitID := func(b []byte) string {
+		var out struct {
+			CommitID string `json:"commit_id"`
+		}
+		json.Unmarshal(b, &out)
+		return out.CommitID
+	}
+
+	do(http.MethodPost, "/v0/boxes", "ci", `{"name":"demo"}`, http.StatusCreated)
+	do(http.MethodPut, "/v0/blobs/ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", "ci", "abc", http.StatusCreated)
+	do(http.MethodPut, "/v0/blobs/4c8a43980498636e9c1d1595fa5d115af7937c2422dfe68a2520a52b7a5fb4de", "ci", "defg", http.StatusCreated)
+	c1 := commitID(do(http.MethodPost, "/v0/boxes/demo/push/finalize", "ci", `{"entries":[{"path":"src/a.txt","sha256":"ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad","size":3,"mode":420}]}`, http.StatusCreated))
+	c2 := commitID(do(http.MethodPost, "/v0/boxes/demo/push/finalize", "ci", fmt.Sprintf(`{"parent_commit_id":%q,"entries":[{"path":"src/a.txt","sha256":"4c8a43980498636e9c1d1595fa5d115af7937c2422dfe68a2520a52b7a5fb4de","size":4},{"path":"README","sha256":"ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad","size":3}]}`, c1), http.StatusCreated))
+
+	var tag metastore.Tag
+	json.Unmarshal(do(http.MethodPost, "/v0/boxes/demo/tags", "ci", fmt.Sprintf(`{"name":"v1.2.3","target":%q,"message":"first release"}`, c1), http.StatusCreated), &tag)
+	if tag.CommitID != c1 || !tag.Annotated || tag.Tagger != "token:ci" {
+		t.Fatalf("unexpected tag %+v", tag)
+	}
+	do(http.MethodPost, "/v0/boxes/demo/tags", "ci", `{"name":"latest","target":"main"}`, http.StatusCreated)
+	do(http.MethodPost, "/v0/boxes/demo/tags", "ci", `{"name":"v1.2.3","target":"main"}`, http.StatusConflict)
+	do(http.MethodPut, "/v0/boxes/demo/tags/v1.2.3", "ci", `{"target":"main"}`, http.StatusForbidden)
+	do(http.MethodDelete, "/v0/boxes/demo/tags/latest", "ci", "", http.StatusForbidden)
+
+	if got := string(do(http.MethodGet, "/v0/boxes/demo/files/v1.2.3?path=src/a.txt", "ci", "", http.StatusOK)); got != "abc" {
+		t.Fatalf("file at tag: %q", got)
+	}
+	if got := string(do(http.MethodGet, "/v0/boxes/demo/files/main?path=src/a.txt", "ci", "", http.StatusOK)); got != "defg" {
+		t.Fatalf("file at branch: %q", got)
+	}
+	var listing domain.Listing
+	json.Unmarshal(do(http.MethodGet, "/v0/boxes/demo/tree/v1.2.3", "ci", "", http.StatusOK), &listing)
+	if listing.CommitID != c1 || listing.Total != 1 || listing.Files != 1 {
+		t.Fatalf("tree at tag: %+v", listing)
+	}
+
+	zipped := do(http.MethodGet, "/v0/boxes/demo/archive/latest?format=zip", "ci", "", http.StatusOK)
+	zr, err := zip.NewReader(bytes.NewReader(zipped), int64(len(zipped)))
+	if err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if strings.Join(names, ",") != "demo-latest/README,demo-latest/src/a.txt" {
+		t.Fatalf("unexpected zip entries %v", names)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(do(http.MethodGet, "/v0/boxes/demo/archive/v1.2.3?format=tar.gz&path=src", "ci", "", http.StatusOK)))
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	h, err := tr.Next()
+	if err != nil || h.Name != "demo-v1.2.3/src/a.txt" || h.Mode != 0o644 {
+		t.Fatalf("unexpected tar entry %+v %v", h, err)
+	}
+	if body, _ := io.ReadAll(tr); string(body) != "abc" {
+		t.Fatalf("unexpected tar content %q", body)
+	}
+	do(http.MethodGet, "/v0/boxes/demo/archive/v1.2.3?format=rar", "ci", "", http.StatusBadRequest)
+	do(http.MethodGet, "/v0/boxes/demo/archive/v1.2.3?path=docs", "ci", "", http.StatusNotFound)
+
+	json.Unmarshal(do(http.MethodPut, "/v0/boxes/demo/tags/v1.2.3", "ops", fmt.Sprintf(`{"target":%q}`, c2), http.StatusOK), &tag)
+	if tag.CommitID != c2 || tag.Annotated {
+		t.Fatalf("unexpected moved tag %+v", tag)
+	}
+	do(http.MethodDelete, "/v0/boxes/demo/tags/latest", "ops", "", http.StatusNoContent)
+	do(http.MethodGet, "/v0/boxes/demo/tags/latest", "ci", "", http.StatusNotFound)
+}
+
+func TestBranchProtection(t *testing.T) {
+	tokens := auth.NewStaticTokens()
+	for name, scope := range map[string]string{"ci": auth.ScopeWrite, "bot": auth.ScopeWrite, "ops": auth.ScopeAdmin} {
+		sum := sha256.Sum256([]byte(name + "-secret"))
+		tokens.Add(name, hex.EncodeToString(sum[:]), scope)
+	}
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := metastore.NewSQLiteMetaStore(":memory:")
+	if err != nil {
+		t.Fatalf("meta open: %v", err)
+	}
+	srv := httptest.NewServer(NewServer(ServerConfig{Blobs: blobstore.NewBlobStoreFS(t.TempDir()), Meta: meta, Authenticator: tokens,
+		AnonymousScope: auth.ScopeRead, Verifier: signing.Keyring{"release": pub}}))
+	t.Cleanup(srv.Close)
+	do := func(method, path, token, body string, want int, code string) map[string]any {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token+"-secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != want || (code != "" && !strings.Contains(string(b), `"code":"`+code+`"`)) {
+			t.Fatalf("%s %s: expected %d %s, got %d: %s", method, path, want, code, resp.StatusCode, b)
+		}
+		var out map[string]any
+		json.Unmarshal(b, &out)
+		return out
+	}
+
+	do(http.MethodPost, "/v0/boxes", "ci", `{"name":"demo"}`, http.StatusCreated, "")
+	do(http.MethodPut, "/v0/blobs/ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", "ci", "abc", http.StatusCreated, "")
+	c1 := do(http.MethodPost, "/v0/boxes/demo/push/finalize", "ci", `{"entries":[{"path":"a","sha256":"ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad","size":3}]}`, http.StatusCreated, "")["commit_id"].(string)
+
+	do(http.MethodPut, "/v0/boxes/demo/protections/main", "ci", `{"require_signed":true}`, http.StatusForbidden, "")
+	do(http.MethodPut, "/v0/boxes/demo/protections/main", "ops", `{"require_signed":true}`, http.StatusOK, "")
+	do(http.MethodPut, "/v0/boxes/demo/protections/release/*", "ops", `{"principals":["token:bot"]}`, http.StatusOK, "")
+	do(http.MethodPut, "/v0/boxes/demo/protections/[", "ops", `{}`, http.StatusBadRequest, "")
+
+	// main only accepts signed commits, and only moves forward.
+	entries := []metastore.Entry{{Path: "b", SHA256: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", Size: 3}}
+	push := func(sig string) string {
+		return fmt.Sprintf(`{"parent_commit_id":%q,"message":"ship","entries":[{"path":"b","sha256":"ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad","size":3}],"signature":%q}`, c1, sig)
+	}
+	do(http.MethodPost, "/v0/boxes/demo/push/finalize", "ci", push(""), http.StatusForbidden, "branch_protected")
+
+	// A refused push stores nothing: no quota charge and no commit.
+	ctx := context.Background()
+	box, err := meta.GetBox(ctx, domain.DefaultNamespace, "demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	do(http.MethodPut, "/v0/blobs/4c8a43980498636e9c1d1595fa5d115af7937c2422dfe68a2520a52b7a5fb4de", "ci", "defg", http.StatusCreated, "")
+	do(http.MethodPost, "/v0/boxes/demo/push/finalize", "ci", fmt.Sprintf(`{"branch":"dev","parent_commit_id":%q,"upserts":[{"path":"e","sha256":"ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad","size":3}]}`, c1), http.StatusCreated, "")
+	usage, _ := meta.NamespaceUsage(ctx, domain.DefaultNamespace)
+	commits, _ := meta.ListCommits(ctx, box.ID, "main", 100)
+	do(http.MethodPost, "/v0/boxes/demo/push/finalize", "ci", fmt.Sprintf(`{"parent_commit_id":%q,"entries":[{"path":"d","sha256":"4c8a43980498636e9c1d1595fa5d115af7937c2422dfe68a2520a52b7a5fb4de","size":4}]}`, c1), http.StatusForbidden, "branch_protected")
+	do(http.MethodPost, "/v0/boxes/demo/merge", "ci", `{"source":"dev","no_ff":true}`, http.StatusForbidden, "branch_protected")
+	if after, _ := meta.NamespaceUsage(ctx, domain.DefaultNamespace); after != usage {
+		t.Fatalf("refused push changed namespace usage from %d to %d", usage, after)
+	}
+	if after, _ := meta.ListCommits(ctx, box.ID, "main", 100); len(after) != len(commits) {
+		t.Fatalf("refused push stored a commit: %d commits before, %d after", len(commits), len(after))
+	}
+	do(http.MethodPost, "/v0/boxes/demo/push/finalize", "ci", push(signing.Sign("release", priv, []byte("something else"))), http.StatusUnprocessableEntity, "bad_signature")
+	signed := signing.Sign("release", priv, domain.SigningPayload(c1, "ship", entries))
+	c2 := do(http.MethodPost, "/v0/boxes/demo/push/finalize", "ci", push(signed), http.StatusCreated, "")["commit_id"].(string)
+
+	// Admins are bound by the rules too.
+	do(http.MethodPost, "/v0/boxes/demo/push/finalize", "ops", `{"force":true,"entries":[{"path":"c","sha256":"ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad","size":3}]}`, http.StatusForbidden, "branch_protected")
+	do(http.MethodPut, "/v0/boxes/demo/branches/main", "ops", fmt.Sprintf(`{"commit_id":%q,"expected_old":%q}`, c1, c2), http.StatusForbidden, "branch_protected")
+	do(http.MethodPut, "/v0/boxes/demo/branches/feature", "ci", fmt.Sprintf(`{"commit_id":%q,"expected_old":""}`, c1), http.StatusOK, "")
+
+	// release/* may only be moved by bot and never deleted.
+	do(http.MethodPut, "/v0/boxes/demo/branches/release/1", "ci", fmt.Sprintf(`{"commit_id":%q,"expected_old":""}`, c1), http.StatusForbidden, "branch_protected")
+	do(http.MethodPut, "/v0/boxes/demo/branches/release/1", "bot", fmt.Sprintf(`{"commit_id":%q,"expected_old":""}`, c1), http.StatusOK, "")
+	do(http.MethodPut, "/v0/boxes/demo/branches/release/1", "bot", fmt.Sprintf(`{"commit_id":%q,"expected_old":%q}`, c2, c1), http.StatusOK, "")
+	do(http.MethodDelete, "/v0/boxes/demo/branches/release/1?expected_old="+c2, "bot", "", http.StatusForbidden, "branch_protected")
+	do(http.MethodDelete, "/v0/boxes/demo/protections/release/*", "ops", "", http.StatusNoContent, "")
+	do(http.MethodDelete, "/v0/boxes/demo/protections/release/*", "ops", "", http.StatusNotFound, "")
+	do(http.MethodDelete, "/v0/boxes/demo/branches/release/1?expected_old="+c2, "bot", "", http.StatusNoContent, "")
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/v0/boxes/demo/protections", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var rules []metastore.BranchRule
+	if err := json.NewDecoder(resp.Body).Decode(&rules); err != nil || len(rules) != 1 || rules[0].Pattern != "main" || !rules[0].RequireSigned {
+		t.Fatalf("unexpected rules %+v (%v)", rules, err)
+	}
+	latest := do(http.MethodGet, "/v0/boxes/demo/commits/latest", "ci", "", http.StatusOK, "")
+	if latest["ID"] != c2 || latest["Signer"] != "release" {
+		t.Fatalf("unexpected head %v", latest)
+	}
+	audit := do(http.MethodGet, "/v0/admin/audit?action=branch_rule.set", "ops", "", http.StatusOK, "")
+	events := audit["events"].([]any)
+	if len(events) != 2 {
+		t.Fatalf("expected 2 rule changes in the audit log, got %v", events)
+	}
+	// Both rules were new, so neither event has a before.
+	for _, e := range events {
+		if before, ok := e.(map[string]any)["before"]; ok {
+			t.Fatalf("new rule has before %v", before)
+		}
+	}
+}
+
+func TestStaleFinalizeStoresNothing(t *testing.T) {
+	meta, err := metastore.NewSQLiteMetaStore(":memory:")
+	if err != nil {
+		t.Fatalf("meta open: %v", err)
+	}
+	srv := httptest.NewServer(NewServer(ServerConfig{Blobs: blobstore.NewBlobStoreFS(t.TempDir()), Meta: meta}))
+	t.Cleanup(srv.Close)
+	post := func(path, body string, want int) map[string]any {
+		t.Helper()
+		resp, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != want {
+			b, _ := io.ReadAll(resp.Body)
+			t.Fatalf("POST %s: expected %d, got %d: %s", path, want, resp.StatusCode, b)
+		}
+		var out map[string]any
+		json.NewDecoder(resp.Body).Decode(&out)
+		return out
+	}
+	for sha, body := range map[string]string{"ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad": "abc", "4c8a43980498636e9c1d1595fa5d115af7937c2422dfe68a2520a52b7a5fb4de": "defg"} {
+		req, _ := http.NewRequest(http.MethodPut, srv.URL+"/v0/blobs/"+sha, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	post("/v0/boxes", `{"name":"demo"}`, http.StatusCreated)
+	c1 := post("/v0/boxes/demo/push/finalize", `{"entries":[{"path":"a","sha256":"ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad","size":3}]}`, http.StatusCreated)["commit_id"].(string)
+	post("/v0/boxes/demo/push/finalize", fmt.Sprintf(`{"parent_commit_id":%q,"upserts":[{"path":"b","sha256":"ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad","size":3}]}`, c1), http.StatusCreated)
+
+	ctx := context.Background()
+	box, err := meta.GetBox(ctx, domain.DefaultNamespace, "demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	usage, _ := meta.NamespaceUsage(ctx, domain.DefaultNamespace)
+	commits, _ := meta.ListCommits(ctx, box.ID, "main", 100)
+	// c1 is no longer the head: the push must not charge 4c8a43980498636e9c1d1595fa5d115af7937c2422dfe68a2520a52b7a5fb4de or keep its commit.
+	post("/v0/boxes/demo/push/finalize", fmt.Sprintf(`{"parent_commit_id":%q,"upserts":[{"path":"c","sha256":"4c8a43980498636e9c1d1595fa5d115af7937c2422dfe68a2520a52b7a5fb4de","size":4}]}`, c1), http.StatusConflict)
+	if after, _ := meta.NamespaceUsage(ctx, domain.DefaultNamespace); after != usage {
+		t.Fatalf("stale push changed namespace usage from %d to %d", usage, after)
+	}
+	if after, _ := meta.ListCommits(ctx, box.ID, "main", 100); len(after) != len(commits) {
+		t.Fatalf("stale push stored a commit: %d commits before, %d after", len(commits), len(after))
+	}
+}
+
+func TestDigestsCannotEscapeBlobRoot(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "secret"), []byte("top secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "blobs")
+	if err := os.Mkdir(root, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := metastore.NewSQLiteMetaStore(":memory:")
+	if err != nil {
+		t.Fatalf("meta open: %v", err)
+	}
+	srv := httptest.NewServer(NewServer(ServerConfig{Blobs: blobstore.NewBlobStoreFS(root), Meta: meta}))
+	t.Cleanup(srv.Close)
+	do := func(method, path, body string, want int, code string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != want {
+			b, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			t.Fatalf("%s %s: expected %d, got %d: %s", method, path, want, resp.StatusCode, b)
+		}
+		if code != "" {
+			if got := errorCode(t, resp); got != code {
+				t.Fatalf("%s %s: expected %s, got %s", method, path, code, got)
+			}
+		}
+		resp.Body.Close()
+	}
+
+	do(http.MethodPost, "/v0/boxes", `{"name":"demo"}`, http.StatusCreated, "")
+	do(http.MethodPost, "/v0/boxes/demo/push/finalize", `{"entries":[{"path":"a","sha256":"../secret","size":10}]}`, http.StatusBadRequest, "invalid_manifest")
+	do(http.MethodGet, "/v0/boxes/demo/files/main?path=a", "", http.StatusNotFound, "")
+	do(http.MethodPut, "/v0/blobs/..%2Fsecret", "top secret", http.StatusBadRequest, "bad_request")
+	do(http.MethodHead, "/v0/blobs/..%2Fsecret", "", http.StatusBadRequest, "")
+	if _, err := os.Stat(filepath.Join(dir, "secret")); err != nil {
+		t.Fatalf("secret touched: %v", err)
+	}
+
+	// A well-formed digest still has to match the bytes uploaded under it.
+	do(http.MethodPut, "/v0/blobs/ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", "abd", http.StatusUnprocessableEntity, "digest_mismatch")
+	do(http.MethodHead, "/v0/blobs/ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", "", http.StatusNotFound, "")
+}
+
+func TestCompareSlashedBranches(t *testing.T) {
+	srv := newTestServer(t)
+	do := func(method, path, body string, want int) []byte {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != want {
+			t.Fatalf("%s %s: expected %d, got %d: %s", method, path, want, resp.StatusCode, b)
+		}
+		return b
+	}
+	abc := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	defg := "4c8a43980498636e9c1d1595fa5d115af7937c2422dfe68a2520a52b7a5fb4de"
+	do(http.MethodPost, "/v0/boxes", `{"name":"demo"}`, http.StatusCreated)
+	do(http.MethodPut, "/v0/blobs/"+abc, "abc", http.StatusCreated)
+	do(http.MethodPut, "/v0/blobs/"+defg, "defg", http.StatusCreated)
+	var c1 struct {
+		CommitID string `json:"commit_id"`
+	}
+	json.Unmarshal(do(http.MethodPost, "/v0/boxes/demo/push/finalize", fmt.Sprintf(`{"entries":[{"path":"a.txt","sha256":%q,"size":3}]}`, abc), http.StatusCreated), &c1)
+	do(http.MethodPost, "/v0/boxes/demo/push/finalize", fmt.Sprintf(`{"branch":"feature/x","parent_commit_id":%q,"entries":[{"path":"a.txt","sha256":%q,"size":4}]}`, c1.CommitID, defg), http.StatusCreated)
+
+	for _, spec := range []string{"main...feature/x", "feature/x...main"} {
+		var cmp domain.Comparison
+		json.Unmarshal(do(http.MethodGet, "/v0/boxes/demo/compare/"+spec, "", http.StatusOK), &cmp)
+		if cmp.Summary.Modified != 1 || len(cmp.Files) != 1 || cmp.Files[0].Path != "a.txt" {
+			t.Fatalf("%s: unexpected comparison %+v", spec, cmp)
+		}
+	}
+	do(http.MethodGet, "/v0/boxes/demo/compare/feature/x", "", http.StatusBadRequest)
+}
+
+func TestArchiveETagCoversPath(t *testing.T) {
+	srv := newTestServer(t)
+	abc := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	http.Post(srv.URL+"/v0/boxes", "application/json", strings.NewReader(`{"name":"demo"}`))
+	req, _ := http.NewRequest(http.MethodPut, srv.URL+"/v0/blobs/"+abc, strings.NewReader("abc"))
+	http.DefaultClient.Do(req)
+	resp, _ := http.Post(srv.URL+"/v0/boxes/demo/push/finalize", "application/json",
+		strings.NewReader(fmt.Sprintf(`{"entries":[{"path":"README","sha256":%q},{"path":"src/a.txt","sha256":%q}]}`, abc, abc)))
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("finalize: %d", resp.StatusCode)
+	}
+	get := func(path, inm string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		if inm != "" {
+			req.Header.Set("If-None-Match", inm)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+	full := get("/v0/boxes/demo/archive/main", "").Header.Get("ETag")
+	src := get("/v0/boxes/demo/archive/main?path=src", "").Header.Get("ETag")
+	if full == "" || full == src || !strings.HasPrefix(full, `W/"`) || !strings.HasSuffix(full, `"`) {
+		t.Fatalf("expected distinct quoted ETags, got %s and %s", full, src)
+	}
+	if again := get("/v0/boxes/demo/archive/main?path=/src/", "").Header.Get("ETag"); again != src {
+		t.Fatalf("same directory, different ETag: %s vs %s", again, src)
+	}
+	if resp := get("/v0/boxes/demo/archive/main?path=src", src); resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("expected 304 for matching ETag, got %d", resp.StatusCode)
+	}
+	if resp := get("/v0/boxes/demo/archive/main", src); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 for the full archive, got %d", resp.StatusCode)
+	}
+}
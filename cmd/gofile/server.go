@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/netip"
+	"sync/atomic"
+	"time"
+
+	"fgo/internal/auth"
+	"fgo/internal/domain"
+	"fgo/internal/httpx"
+	"fgo/internal/observe"
+	"fgo/internal/signing"
+	"fgo/internal/storage/blobstore"
+	"fgo/internal/storage/metastore"
+)
+
+// ServerConfig holds the dependencies of the gofile HTTP API.
+type ServerConfig struct {
+	Blobs blobstore.BlobStore
+	Meta  metastore.MetadataStore
+	// OpenAPIPath is the spec served at /v0/openapi.yaml.
+	OpenAPIPath string
+	// Authenticator resolves request credentials; nil treats every request as anonymous.
+	Authenticator auth.Authenticator
+	// AnonymousScope is granted to requests without credentials (default write).
+	AnonymousScope string
+	// Metrics, if set, collects request, blob store and finalize metrics and
+	// is served at /metrics.
+	Metrics *observe.Registry
+	// Tracer, if set, records a span per request and per store call.
+	Tracer *observe.Tracer
+	// AuditSink, if set, receives every audit event as a JSON line.
+	AuditSink io.Writer
+	// CORS, if set, is the cross-origin policy for browser clients.
+	CORS *httpx.CORSOptions
+	// Compression, if set, compresses textual responses.
+	Compression *httpx.CompressOptions
+	// MaxBodyBytes caps JSON request bodies; 0 means unlimited.
+	MaxBodyBytes int64
+	// Limits bounds blob sizes, manifests and namespace storage.
+	Limits domain.Limits
+	// Verifier, if set, checks commit signatures sent with a push.
+	Verifier signing.Verifier
+	// RateLimits throttles API routes per client; the zero value disables throttling.
+	RateLimits RateLimits
+	// UploadTTL bounds how long a resumable upload session may take (default 24h).
+	UploadTTL time.Duration
+}
+
+// RateLimits holds one limiter per route class. A nil limiter leaves its class unthrottled.
+type RateLimits struct {
+	Read  *httpx.RateLimiter
+	Write *httpx.RateLimiter
+	// Check covers existence checks clients issue in bulk: HEAD blob, batch check and push plan.
+	Check *httpx.RateLimiter
+	// TrustedProxies may set X-Forwarded-For / X-Real-IP for IP-keyed clients.
+	TrustedProxies []netip.Prefix
+}
+
+// Server is the gofile HTTP API.
+type Server struct {
+	boxes        *domain.BoxService
+	audit        *domain.AuditLog
+	push         *domain.PushService
+	branches     *domain.BranchService
+	tags         *domain.TagService
+	uploads      *domain.UploadService
+	files        *domain.FileService
+	compare      *domain.CompareService
+	openAPIPath  string
+	metrics      *observe.Registry
+	rateLimits   RateLimits
+	maxBodyBytes int64
+	handler      http.Handler
+	draining     atomic.Bool
+}
+
+// NewServer builds the gofile API handler, including the standard middleware chain.
+func NewServer(cfg ServerConfig) *Server {
+	if cfg.OpenAPIPath == "" {
+		cfg.OpenAPIPath = "openapi.yaml"
+	}
+	if cfg.AnonymousScope == "" {
+		cfg.AnonymousScope = auth.ScopeWrite
+	}
+	blobs, meta := cfg.Blobs, cfg.Meta
+	if cfg.Tracer != nil && blobs != nil {
+		blobs = blobstore.Trace(blobs, cfg.Tracer)
+	}
+	if cfg.Tracer != nil && meta != nil {
+		meta = metastore.Trace(meta, cfg.Tracer)
+	}
+	if cfg.Metrics != nil && blobs != nil {
+		blobs = blobstore.Instrument(blobs, cfg.Metrics)
+	}
+	audit := domain.NewAuditLog(meta, cfg.AuditSink)
+	s := &Server{
+		boxes:        domain.NewBoxService(meta, audit),
+		audit:        audit,
+		push:         domain.NewPushService(blobs, meta, audit),
+		branches:     domain.NewBranchService(meta, audit),
+		tags:         domain.NewTagService(meta, audit),
+		uploads:      domain.NewUploadService(blobs, cfg.UploadTTL),
+		files:        domain.NewFileService(blobs, meta),
+		compare:      domain.NewCompareService(blobs),
+		openAPIPath:  cfg.OpenAPIPath,
+		metrics:      cfg.Metrics,
+		rateLimits:   cfg.RateLimits,
+		maxBodyBytes: cfg.MaxBodyBytes,
+	}
+	anonymous := auth.Principal{ID: "anonymous", Scope: cfg.AnonymousScope}
+	mws := []httpx.Middleware{httpx.RequestID(), httpx.Recover()}
+	if cfg.Tracer != nil {
+		mws = append(mws, httpx.Trace(cfg.Tracer))
+	}
+	s.push.SetLimits(cfg.Limits)
+	if cfg.Verifier != nil {
+		s.push.SetVerifier(cfg.Verifier)
+	}
+	s.uploads.SetLimits(cfg.Limits)
+	if cfg.Metrics != nil {
+		s.push.Instrument(cfg.Metrics)
+		mws = append(mws, httpx.Metrics(cfg.Metrics))
+	}
+	mws = append(mws, httpx.Logger())
+	if cfg.CORS != nil {
+		mws = append(mws, httpx.CORS(*cfg.CORS))
+	}
+	if cfg.Compression != nil {
+		mws = append(mws, httpx.Compress(*cfg.Compression))
+	}
+	mws = append(mws, httpx.Authenticate(cfg.Authenticator, anonymous))
+	s.handler = httpx.Chain(s.routes(), mws...)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// RunUploadExpiry removes expired upload sessions every interval until ctx is done.
+func (s *Server) RunUploadExpiry(ctx context.Context, interval time.Duration) {
+	s.uploads.RunExpiry(ctx, interval)
+}
+
+// Drain marks the server as shutting down; /v0/health then reports 503.
+func (s *Server) Drain() {
+	s.draining.Store(true)
+}
+
+func (s *Server) routes() *httpx.Router {
+	rt := httpx.NewRouter()
+	key := httpx.ClientKey(s.rateLimits.TrustedProxies)
+	read := rt.Group(httpx.RateLimit(s.rateLimits.Read, key), httpx.RequireScope(auth.ScopeRead))
+	write := rt.Group(httpx.RateLimit(s.rateLimits.Write, key), httpx.RequireScope(auth.ScopeWrite))
+	admin := rt.Group(httpx.RateLimit(s.rateLimits.Write, key), httpx.RequireScope(auth.ScopeAdmin))
+	check := rt.Group(httpx.RateLimit(s.rateLimits.Check, key))
+
+	// Basic Web UI
+	read.HandleFunc("GET /browse", s.handleBrowse)
+	write.HandleFunc("GET /upload", s.handleUploadForm)
+	write.HandleFunc("POST /upload", s.handleUpload)
+
+	// System
+	rt.HandleFunc("GET /v0/health", s.handleHealth)
+	rt.HandleFunc("GET /v0/openapi.yaml", s.handleOpenAPI)
+	rt.HandleFunc("GET /v0/docs", s.handleDocs)
+	if s.metrics != nil {
+		rt.Handle("GET /metrics", s.metrics.Handler())
+	}
+
+	// Boxes
+	read.HandleFunc("GET /v0/boxes", s.handleListBoxes)
+	write.HandleFunc("POST /v0/boxes", s.handleCreateBox)
+	read.HandleFunc("GET /v0/boxes/{box}", s.handleGetBox)
+	write.HandleFunc("PATCH /v0/boxes/{box}", s.handleUpdateBox)
+	read.HandleFunc("GET /v0/boxes/{box}/tree/{ref...}", s.handleTree)
+	read.HandleFunc("GET /v0/boxes/{box}/files/{ref...}", s.handleBoxFile)
+	read.HandleFunc("GET /v0/boxes/{box}/archive/{ref...}", s.handleArchive)
+	read.HandleFunc("GET /v0/boxes/{box}/commits", s.handleListCommits)
+	read.HandleFunc("GET /v0/boxes/{box}/commits/latest", s.handleLatestCommit)
+	read.HandleFunc("GET /v0/boxes/{box}/compare/{spec...}", s.handleCompare)
+
+	// Push
+	check.HandleFunc("POST /v0/boxes/{box}/push/plan", s.handlePlan, httpx.RequireScope(auth.ScopeWrite))
+	write.HandleFunc("POST /v0/boxes/{box}/push/finalize", s.handleFinalize)
+	write.HandleFunc("POST /v0/boxes/{box}/merge", s.handleMerge)
+
+	// Branches
+	read.HandleFunc("GET /v0/boxes/{box}/branches", s.handleListBranches)
+	read.HandleFunc("GET /v0/boxes/{box}/branches/{branch...}", s.handleGetBranch)
+	write.HandleFunc("PUT /v0/boxes/{box}/branches/{branch...}", s.handleSetBranch)
+	write.HandleFunc("DELETE /v0/boxes/{box}/branches/{branch...}", s.handleDeleteBranch)
+	read.HandleFunc("GET /v0/boxes/{box}/reflog/{branch...}", s.handleReflog)
+	write.HandleFunc("POST /v0/boxes/{box}/reset/{branch...}", s.handleResetBranch)
+
+	// Branch protection: rules bind everyone, admins included, until an
+	// admin changes them.
+	read.HandleFunc("GET /v0/boxes/{box}/protections", s.handleListRules)
+	admin.HandleFunc("PUT /v0/boxes/{box}/protections/{pattern...}", s.handlePutRule)
+	admin.HandleFunc("DELETE /v0/boxes/{box}/protections/{pattern...}", s.handleDeleteRule)
+
+	// Tags: anyone who can push can create one; only admins move or delete them.
+	read.HandleFunc("GET /v0/boxes/{box}/tags", s.handleListTags)
+	write.HandleFunc("POST /v0/boxes/{box}/tags", s.handleCreateTag)
+	read.HandleFunc("GET /v0/boxes/{box}/tags/{tag...}", s.handleGetTag)
+	admin.HandleFunc("PUT /v0/boxes/{box}/tags/{tag...}", s.handleMoveTag)
+	admin.HandleFunc("DELETE /v0/boxes/{box}/tags/{tag...}", s.handleDeleteTag)
+
+	// Blobs and files
+	check.HandleFunc("HEAD /v0/blobs/{sha256}", s.handleHeadBlob, httpx.RequireScope(auth.ScopeRead))
+	write.HandleFunc("PUT /v0/blobs/{sha256}", s.handlePutBlob)
+	check.HandleFunc("POST /v0/blobs/batch/check", s.handleCheckBlobs, httpx.RequireScope(auth.ScopeRead))
+	write.HandleFunc("POST /v0/blobs/batch", s.handlePutBlobs)
+	write.HandleFunc("POST /v0/uploads", s.handleCreateUpload)
+	write.HandleFunc("HEAD /v0/uploads/{id}", s.handleHeadUpload)
+	write.HandleFunc("PATCH /v0/uploads/{id}", s.handlePatchUpload)
+	write.HandleFunc("DELETE /v0/uploads/{id}", s.handleDeleteUpload)
+	write.HandleFunc("POST /v0/uploads/{id}/complete", s.handleCompleteUpload)
+	read.HandleFunc("GET /v0/files/{commit_id}", s.handleFile)
+
+	// Admin
+	admin.HandleFunc("GET /v0/admin/audit", s.handleListAudit)
+	return rt
+}
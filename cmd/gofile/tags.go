@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+
+	"fgo/internal/domain"
+	"fgo/internal/httpx"
+)
+
+func (s *Server) handleListTags(w http.ResponseWriter, r *http.Request) {
+	box, ok := s.loadBox(w, r)
+	if !ok {
+		return
+	}
+	tags, err := s.tags.List(r.Context(), box)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	httpx.JSON(w, http.StatusOK, tags)
+}
+
+func (s *Server) handleGetTag(w http.ResponseWriter, r *http.Request) {
+	box, ok := s.loadBox(w, r)
+	if !ok {
+		return
+	}
+	t, err := s.tags.Get(r.Context(), box, r.PathValue("tag"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	httpx.JSON(w, http.StatusOK, t)
+}
+
+// handleCreateTag serves POST /v0/boxes/{box}/tags with {name, target, message}.
+func (s *Server) handleCreateTag(w http.ResponseWriter, r *http.Request) {
+	box, ok := s.loadBox(w, r)
+	if !ok {
+		return
+	}
+	var req domain.TagRequest
+	if !s.decodeJSON(w, r, &req, httpx.CodeBadRequest) {
+		return
+	}
+	t, err := s.tags.Create(r.Context(), box, req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	httpx.JSON(w, http.StatusCreated, t)
+}
+
+// handleMoveTag serves PUT /v0/boxes/{box}/tags/{tag} with {target, message}.
+func (s *Server) handleMoveTag(w http.ResponseWriter, r *http.Request) {
+	box, ok := s.loadBox(w, r)
+	if !ok {
+		return
+	}
+	var req domain.TagRequest
+	if !s.decodeJSON(w, r, &req, httpx.CodeBadRequest) {
+		return
+	}
+	req.Name = r.PathValue("tag")
+	t, err := s.tags.Move(r.Context(), box, req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	httpx.JSON(w, http.StatusOK, t)
+}
+
+func (s *Server) handleDeleteTag(w http.ResponseWriter, r *http.Request) {
+	box, ok := s.loadBox(w, r)
+	if !ok {
+		return
+	}
+	if err := s.tags.Delete(r.Context(), box, r.PathValue("tag")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"fgo/internal/config"
+)
+
+// certReloader serves the TLS configuration built from the configured
+// certificate, key and client CA files, and rebuilds it on Reload so
+// certificates can be rotated without a restart.
+type certReloader struct {
+	cfg config.TLS
+
+	mu      sync.RWMutex
+	current *tls.Config
+	stamp   string
+}
+
+func newCertReloader(cfg config.TLS) (*certReloader, error) {
+	r := &certReloader{cfg: cfg}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig returns a config for http.Server that always hands out the latest certificates.
+func (r *certReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return r.current, nil
+		},
+	}
+}
+
+// Reload re-reads the certificate files. On error the previous config stays in use.
+func (r *certReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("load key pair: %w", err)
+	}
+	c := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	switch r.cfg.ClientAuth {
+	case "request":
+		c.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if r.cfg.ClientAuth != "none" {
+		pem, err := os.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("client CA %s: no certificates found", r.cfg.ClientCAFile)
+		}
+		c.ClientCAs = pool
+	}
+	stamp := r.fileStamp()
+	r.mu.Lock()
+	r.current = c
+	r.stamp = stamp
+	r.mu.Unlock()
+	return nil
+}
+
+// fileStamp summarizes the size and mtime of the watched files.
+func (r *certReloader) fileStamp() string {
+	var stamp string
+	for _, p := range []string{r.cfg.CertFile, r.cfg.KeyFile, r.cfg.ClientCAFile} {
+		if p == "" {
+			continue
+		}
+		if fi, err := os.Stat(p); err == nil {
+			stamp += fmt.Sprintf("%s:%d:%d;", p, fi.Size(), fi.ModTime().UnixNano())
+		}
+	}
+	return stamp
+}
+
+// Watch reloads whenever a value arrives on hup or, every interval, when the
+// files changed on disk. It returns when ctx is done.
+func (r *certReloader) Watch(ctx context.Context, interval time.Duration, hup <-chan os.Signal) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.reloadAndLog("SIGHUP")
+		case <-tick:
+			r.mu.RLock()
+			changed := r.fileStamp() != r.stamp
+			r.mu.RUnlock()
+			if changed {
+				r.reloadAndLog("file change")
+			}
+		}
+	}
+}
+
+func (r *certReloader) reloadAndLog(reason string) {
+	if err := r.Reload(); err != nil {
+		slog.Error("tls reload failed, keeping previous certificate", "reason", reason, "error", err)
+		return
+	}
+	slog.Info("tls certificates reloaded", "reason", reason)
+}
+
+// redirectHandler sends plain HTTP clients to the same URL on the HTTPS port.
+func redirectHandler(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"fgo/internal/auth"
+	"fgo/internal/config"
+	"fgo/internal/storage/blobstore"
+	"fgo/internal/storage/metastore"
+)
+
+// writeSelfSigned writes a self-signed certificate usable for both server and
+// client auth and returns the certificate and key paths.
+func writeSelfSigned(t *testing.T, dir, name string, serial int64) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func servedSerial(t *testing.T, r *certReloader) int64 {
+	t.Helper()
+	c, err := r.TLSConfig().GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(c.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.SerialNumber.Int64()
+}
+
+func TestCertReloaderPicksUpNewCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeSelfSigned(t, dir, "server", 1)
+	r, err := newCertReloader(config.TLS{CertFile: certFile, KeyFile: keyFile, ClientAuth: "none"})
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := servedSerial(t, r); got != 1 {
+		t.Fatalf("expected serial 1, got %d", got)
+	}
+
+	writeSelfSigned(t, dir, "server", 2)
+	if err := r.Reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if got := servedSerial(t, r); got != 2 {
+		t.Fatalf("expected serial 2 after reload, got %d", got)
+	}
+
+	// A broken key pair keeps the previous certificate.
+	if err := os.WriteFile(certFile, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload(); err == nil {
+		t.Fatal("expected reload error")
+	}
+	if got := servedSerial(t, r); got != 2 {
+		t.Fatalf("expected serial 2 to be kept, got %d", got)
+	}
+}
+
+func TestClientCertificateAuthenticates(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeSelfSigned(t, dir, "server", 1)
+	clientCert, clientKey := writeSelfSigned(t, dir, "ci-runner", 3)
+	r, err := newCertReloader(config.TLS{CertFile: certFile, KeyFile: keyFile, ClientAuth: "request", ClientCAFile: clientCert})
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	meta, err := metastore.NewSQLiteMetaStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(NewServer(ServerConfig{
+		Blobs:          blobstore.NewBlobStoreFS(dir),
+		Meta:           meta,
+		Authenticator:  auth.Chain{auth.ClientCert{Scope: auth.ScopeRead}},
+		AnonymousScope: auth.ScopeNone,
+	}))
+	srv.TLS = r.TLSConfig()
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	pemBytes, _ := os.ReadFile(certFile)
+	roots.AppendCertsFromPEM(pemBytes)
+	pair, err := tls.LoadX509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	anon := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: "localhost"}}}
+	resp, err := anon.Get(srv.URL + "/v0/boxes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without client cert, got %d", resp.StatusCode)
+	}
+
+	withCert := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: "localhost", Certificates: []tls.Certificate{pair}}}}
+	resp, err = withCert.Get(srv.URL + "/v0/boxes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 with client cert, got %d", resp.StatusCode)
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	redirectHandler(8443).ServeHTTP(w, httptest.NewRequest("GET", "http://files.example:8080/v0/files/x?path=a", nil))
+	if w.Code != http.StatusPermanentRedirect {
+		t.Fatalf("expected 308, got %d", w.Code)
+	}
+	if loc := w.Header().Get("Location"); loc != "https://files.example:8443/v0/files/x?path=a" {
+		t.Fatalf("unexpected Location %q", loc)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"fgo/internal/httpx"
+	"fgo/internal/observe"
+	"fgo/internal/storage/blobstore"
+)
+
+// Resumable uploads follow the tus.io core protocol's headers: the client
+// creates a session, PATCHes chunks with Upload-Offset, asks HEAD for the
+// offset after a disconnect and completes once every byte arrived. Small
+// blobs should keep using a single PUT /v0/blobs/{sha256}.
+
+type createUploadRequest struct {
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// handleCreateUpload serves POST /v0/uploads.
+func (s *Server) handleCreateUpload(w http.ResponseWriter, r *http.Request) {
+	var req createUploadRequest
+	if !s.decodeJSON(w, r, &req, httpx.CodeBadRequest) {
+		return
+	}
+	up, err := s.uploads.Create(r.Context(), req.SHA256, req.Size)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	setUploadHeaders(w, up)
+	w.Header().Set("Location", "/v0/uploads/"+up.ID)
+	httpx.JSON(w, http.StatusCreated, up)
+}
+
+// handleHeadUpload serves HEAD /v0/uploads/{id}, reporting where to resume.
+func (s *Server) handleHeadUpload(w http.ResponseWriter, r *http.Request) {
+	up, err := s.uploads.Get(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	setUploadHeaders(w, up)
+	w.WriteHeader(http.StatusOK)
+}
+
+// handlePatchUpload serves PATCH /v0/uploads/{id}, appending the body at Upload-Offset.
+func (s *Server) handlePatchUpload(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	observe.AddLogAttrs(r.Context(), slog.String("upload_id", id))
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		httpx.Error(w, r, http.StatusBadRequest, httpx.CodeBadRequest, "Upload-Offset header required", nil)
+		return
+	}
+	if r.ContentLength < 0 {
+		httpx.Error(w, r, http.StatusLengthRequired, httpx.CodeLengthRequired, "Content-Length required", nil)
+		return
+	}
+	up, err := s.uploads.Write(r.Context(), id, offset, r.Body, r.ContentLength)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	setUploadHeaders(w, up)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleCompleteUpload serves POST /v0/uploads/{id}/complete.
+func (s *Server) handleCompleteUpload(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	observe.AddLogAttrs(r.Context(), slog.String("upload_id", id))
+	up, err := s.uploads.Complete(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	httpx.JSON(w, http.StatusCreated, map[string]any{"sha256": up.SHA256, "size": up.Size})
+}
+
+// handleDeleteUpload serves DELETE /v0/uploads/{id}.
+func (s *Server) handleDeleteUpload(w http.ResponseWriter, r *http.Request) {
+	if err := s.uploads.Abort(r.Context(), r.PathValue("id")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func setUploadHeaders(w http.ResponseWriter, up blobstore.Upload) {
+	h := w.Header()
+	h.Set("Upload-Offset", strconv.FormatInt(up.Offset, 10))
+	h.Set("Upload-Length", strconv.FormatInt(up.Size, 10))
+	h.Set("Upload-Expires", up.ExpiresAt.UTC().Format(http.TimeFormat))
+	h.Set("Cache-Control", "no-store")
+}
@@ -1,7 +1,27 @@
 package auth
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"net/http"
+	"strings"
+)
+
+// Scopes in increasing order of privilege. Each scope includes the ones before it.
+const (
+	ScopeNone  = "none"
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+var (
+	// ErrNoCredentials means the request carried no credentials for this authenticator.
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials means credentials were present but not accepted.
+	ErrInvalidCredentials = errors.New("invalid credentials")
 )
 
 type Principal struct {
@@ -9,6 +29,102 @@ type Principal struct {
 	Scope string
 }
 
+// Anonymous reports whether p represents an unauthenticated client.
+func (p Principal) Anonymous() bool { return p.ID == "" || p.ID == "anonymous" }
+
+// Allows reports whether p's scope includes scope.
+func (p Principal) Allows(scope string) bool { return rank(p.Scope) >= rank(scope) && rank(scope) > 0 }
+
+func rank(scope string) int {
+	switch scope {
+	case ScopeRead:
+		return 1
+	case ScopeWrite:
+		return 2
+	case ScopeAdmin:
+		return 3
+	}
+	return 0
+}
+
 type Authenticator interface {
 	Authenticate(r *http.Request) (Principal, error)
 }
+
+type ctxKey struct{}
+
+// WithPrincipal returns a context carrying p.
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, ctxKey{}, p)
+}
+
+// FromContext returns the principal stored by WithPrincipal.
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(ctxKey{}).(Principal)
+	return p, ok
+}
+
+// StaticTokens authenticates "Authorization: Bearer <token>" against a fixed
+// set of tokens identified by the hex SHA-256 of their value.
+type StaticTokens struct {
+	byHash map[string]Principal
+}
+
+func NewStaticTokens() *StaticTokens {
+	return &StaticTokens{byHash: map[string]Principal{}}
+}
+
+// Add registers a token by name, hex SHA-256 digest and scope.
+func (s *StaticTokens) Add(name, sha256Hex, scope string) {
+	s.byHash[strings.ToLower(sha256Hex)] = Principal{ID: "token:" + name, Scope: scope}
+}
+
+func (s *StaticTokens) Authenticate(r *http.Request) (Principal, error) {
+	h := r.Header.Get("Authorization")
+	if h == "" {
+		return Principal{}, ErrNoCredentials
+	}
+	token, ok := strings.CutPrefix(h, "Bearer ")
+	if !ok || token == "" {
+		return Principal{}, ErrInvalidCredentials
+	}
+	sum := sha256.Sum256([]byte(token))
+	p, ok := s.byHash[hex.EncodeToString(sum[:])]
+	if !ok {
+		return Principal{}, ErrInvalidCredentials
+	}
+	return p, nil
+}
+
+// ClientCert authenticates TLS clients by a verified client certificate. The
+// principal ID is "cert:" followed by the certificate's common name.
+type ClientCert struct {
+	Scope string
+}
+
+func (c ClientCert) Authenticate(r *http.Request) (Principal, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return Principal{}, ErrNoCredentials
+	}
+	leaf := r.TLS.VerifiedChains[0][0]
+	id := leaf.Subject.CommonName
+	if id == "" {
+		id = leaf.SerialNumber.String()
+	}
+	return Principal{ID: "cert:" + id, Scope: c.Scope}, nil
+}
+
+// Chain tries each authenticator in order and returns the first result that
+// is not ErrNoCredentials.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(r *http.Request) (Principal, error) {
+	for _, a := range c {
+		p, err := a.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return p, err
+	}
+	return Principal{}, ErrNoCredentials
+}
@@ -0,0 +1,658 @@
+// Package config loads the gofile server configuration from YAML, applies
+// GOFILE_* environment overrides on top of built-in defaults and validates
+// the result.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"fgo/internal/signing"
+)
+
+// EnvPrefix prefixes every environment override, e.g. GOFILE_PORT or
+// GOFILE_LIMITS_MAX_BLOB_BYTES for limits.max_blob_bytes.
+const EnvPrefix = "GOFILE"
+
+// DefaultPath is loaded when no --config flag or GOFILE_CONFIG is given and the file exists.
+const DefaultPath = "config.yaml"
+
+type Config struct {
+	Port      int    `yaml:"port"`
+	BlobStore string `yaml:"blob_store"`
+	MetaStore string `yaml:"meta_store"`
+
+	Server      Server      `yaml:"server"`
+	Limits      Limits      `yaml:"limits"`
+	Quotas      Quotas      `yaml:"quotas"`
+	Uploads     Uploads     `yaml:"uploads"`
+	RateLimit   RateLimit   `yaml:"rate_limit"`
+	Auth        Auth        `yaml:"auth"`
+	TLS         TLS         `yaml:"tls"`
+	CORS        CORS        `yaml:"cors"`
+	Compression Compression `yaml:"compression"`
+	Log         Log         `yaml:"log"`
+	Metrics     Metrics     `yaml:"metrics"`
+	Tracing     Tracing     `yaml:"tracing"`
+	Audit       Audit       `yaml:"audit"`
+	Signing     Signing     `yaml:"signing"`
+}
+
+// Server configures HTTP timeouts and graceful shutdown. Zero disables a timeout.
+type Server struct {
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	// DrainDelay keeps serving with /v0/health reporting "draining" before
+	// connections are closed, so load balancers can stop routing first.
+	DrainDelay time.Duration `yaml:"drain_delay"`
+	// ShutdownTimeout bounds how long in-flight requests may run after a signal.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// Limits bounds request and upload sizes. Zero disables a limit.
+type Limits struct {
+	MaxBodyBytes  int64 `yaml:"max_body_bytes"`
+	MaxBlobBytes  int64 `yaml:"max_blob_bytes"`
+	MaxEntries    int   `yaml:"max_entries"`
+	MaxPathLength int   `yaml:"max_path_length"`
+	MaxBatchBlobs int   `yaml:"max_batch_blobs"`
+}
+
+// Quotas caps the blob bytes each namespace may reference. Zero means unlimited.
+type Quotas struct {
+	DefaultBytes int64            `yaml:"default_bytes"`
+	Namespaces   []NamespaceQuota `yaml:"namespaces"`
+}
+
+// NamespaceQuota overrides the default quota for one namespace.
+type NamespaceQuota struct {
+	Name  string `yaml:"name"`
+	Bytes int64  `yaml:"bytes"`
+}
+
+// For returns the quota in bytes for namespace ns.
+func (q Quotas) For(ns string) int64 {
+	for _, n := range q.Namespaces {
+		if n.Name == ns {
+			return n.Bytes
+		}
+	}
+	return q.DefaultBytes
+}
+
+// RateLimit configures per-client token buckets. Clients are keyed by
+// principal when authenticated, otherwise by IP address.
+type RateLimit struct {
+	Enabled bool `yaml:"enabled"`
+	// TrustedProxies are CIDRs (or single IPs) whose X-Forwarded-For and
+	// X-Real-IP headers are believed.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// Read covers downloads and listings, Write uploads and box changes, and
+	// Check the cheap, chatty existence checks (HEAD blob, push plan).
+	Read  RateClass `yaml:"read"`
+	Write RateClass `yaml:"write"`
+	Check RateClass `yaml:"check"`
+}
+
+// RateClass is a token bucket: Rate requests per second sustained, Burst at once.
+type RateClass struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// Prefixes parses TrustedProxies; Validate guarantees they are well formed.
+func (r RateLimit) Prefixes() []netip.Prefix {
+	var out []netip.Prefix
+	for _, s := range r.TrustedProxies {
+		if p, err := parsePrefix(s); err == nil {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(a, a.BitLen()), nil
+}
+
+// Auth configures bearer tokens and what unauthenticated clients may do.
+type Auth struct {
+	// AnonymousScope is granted to requests without credentials: none, read, write or admin.
+	AnonymousScope string  `yaml:"anonymous_scope"`
+	Tokens         []Token `yaml:"tokens"`
+}
+
+// Token is a static bearer token. Only the hex SHA-256 of the token is stored.
+type Token struct {
+	Name   string `yaml:"name"`
+	SHA256 string `yaml:"sha256"`
+	Scope  string `yaml:"scope"`
+}
+
+// TLS configures HTTPS. Certificates are reloaded on SIGHUP and, when
+// ReloadInterval is set, whenever the files change on disk.
+type TLS struct {
+	Enabled  bool   `yaml:"enabled"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientAuth is none, request (verify if presented) or require.
+	ClientAuth   string `yaml:"client_auth"`
+	ClientCAFile string `yaml:"client_ca_file"`
+	// ClientCertScope is granted to clients authenticated by certificate.
+	ClientCertScope string        `yaml:"client_cert_scope"`
+	ReloadInterval  time.Duration `yaml:"reload_interval"`
+	// RedirectHTTPPort, if set, serves a plain HTTP listener redirecting to HTTPS.
+	RedirectHTTPPort int `yaml:"redirect_http_port"`
+}
+
+type Log struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+// Uploads configures resumable upload sessions.
+type Uploads struct {
+	// TTL is how long a session may take before it is discarded.
+	TTL time.Duration `yaml:"ttl"`
+	// CleanupInterval is how often expired sessions are removed.
+	CleanupInterval time.Duration `yaml:"cleanup_interval"`
+}
+
+// CORS configures the cross-origin policy for browser clients.
+type CORS struct {
+	// AllowedOrigins are exact origins, "scheme://*.domain" wildcards or "*".
+	// Empty disables CORS.
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	// AllowedMethods, AllowedHeaders and ExposedHeaders fall back to the
+	// server defaults when empty.
+	AllowedMethods   []string      `yaml:"allowed_methods"`
+	AllowedHeaders   []string      `yaml:"allowed_headers"`
+	ExposedHeaders   []string      `yaml:"exposed_headers"`
+	AllowCredentials bool          `yaml:"allow_credentials"`
+	MaxAge           time.Duration `yaml:"max_age"`
+}
+
+// Compression configures response compression.
+type Compression struct {
+	Enabled bool `yaml:"enabled"`
+	// MinSize is the smallest response body worth compressing, in bytes.
+	MinSize int `yaml:"min_size"`
+	// Level is the deflate level 1-9, or 0 for the default.
+	Level int `yaml:"level"`
+	// Encodings are offered in preference order.
+	Encodings []string `yaml:"encodings"`
+}
+
+// Metrics configures the Prometheus endpoint at /metrics.
+type Metrics struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// Tracing configures span export to an OTLP/HTTP collector.
+type Tracing struct {
+	Enabled bool `yaml:"enabled"`
+	// Endpoint is the collector base URL; spans are POSTed to {endpoint}/v1/traces.
+	Endpoint    string  `yaml:"endpoint"`
+	ServiceName string  `yaml:"service_name"`
+	SampleRatio float64 `yaml:"sample_ratio"`
+	// Headers are "Name=value" pairs sent with every export, e.g. for collector auth.
+	Headers       []string      `yaml:"headers"`
+	Timeout       time.Duration `yaml:"timeout"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+}
+
+// Audit configures the audit log. Events are always stored in the metastore.
+type Audit struct {
+	// JSONLPath, if set, also appends every event as a JSON line to this file.
+	JSONLPath string `yaml:"jsonl_path"`
+}
+
+// Signing lists the keys commit signatures sent with a push are verified
+// against. Without keys, signed pushes are refused.
+type Signing struct {
+	Keys []SigningKey `yaml:"keys"`
+}
+
+// SigningKey is a named ed25519 public key, base64 encoded. Signatures name
+// the key that made them.
+type SigningKey struct {
+	Name      string `yaml:"name"`
+	PublicKey string `yaml:"public_key"`
+}
+
+// Keyring returns the keys as a signing.Keyring, or nil if there are none.
+// Validate guarantees they parse.
+func (s Signing) Keyring() signing.Keyring {
+	if len(s.Keys) == 0 {
+		return nil
+	}
+	k := make(signing.Keyring, len(s.Keys))
+	for _, key := range s.Keys {
+		if pub, err := signing.ParseKey(key.PublicKey); err == nil {
+			k[key.Name] = pub
+		}
+	}
+	return k
+}
+
+// HeaderMap returns Headers as a map.
+func (t Tracing) HeaderMap() map[string]string {
+	m := make(map[string]string, len(t.Headers))
+	for _, h := range t.Headers {
+		k, v, _ := strings.Cut(h, "=")
+		m[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return m
+}
+
+// Default returns the configuration used for any value not set in the file or environment.
+func Default() Config {
+	return Config{
+		Port:      8080,
+		BlobStore: "./blobs",
+		MetaStore: "./meta.db",
+		Server: Server{
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       15 * time.Minute,
+			WriteTimeout:      15 * time.Minute,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   30 * time.Second,
+		},
+		Limits: Limits{
+			MaxBodyBytes:  32 << 20,
+			MaxBlobBytes:  0,
+			MaxEntries:    100000,
+			MaxPathLength: 1024,
+			MaxBatchBlobs: 10000,
+		},
+		RateLimit: RateLimit{
+			Enabled: true,
+			Read:    RateClass{Rate: 50, Burst: 100},
+			Write:   RateClass{Rate: 10, Burst: 50},
+			Check:   RateClass{Rate: 200, Burst: 400},
+		},
+		Auth: Auth{AnonymousScope: "write"},
+		TLS: TLS{
+			ClientAuth:      "none",
+			ClientCertScope: "read",
+			ReloadInterval:  time.Minute,
+		},
+		Log: Log{Level: "info", Format: "json"},
+		Uploads: Uploads{
+			TTL:             24 * time.Hour,
+			CleanupInterval: time.Hour,
+		},
+		CORS: CORS{
+			AllowedOrigins: []string{"*"},
+			MaxAge:         10 * time.Minute,
+		},
+		Compression: Compression{
+			Enabled:   true,
+			MinSize:   1024,
+			Encodings: []string{"gzip", "deflate"},
+		},
+		Metrics: Metrics{Enabled: true},
+		Tracing: Tracing{
+			Endpoint:      "http://localhost:4318",
+			ServiceName:   "gofile",
+			SampleRatio:   1,
+			Timeout:       10 * time.Second,
+			FlushInterval: 5 * time.Second,
+		},
+	}
+}
+
+// Load reads the YAML file at path (skipped when empty) over the defaults,
+// applies environment overrides and validates the result.
+func Load(path string) (Config, error) {
+	cfg := Default()
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("config: %w", err)
+		}
+		defer f.Close()
+		dec := yaml.NewDecoder(f)
+		dec.KnownFields(true)
+		if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+			return Config{}, fmt.Errorf("config: parse %s: %w", path, err)
+		}
+	}
+	if err := ApplyEnv(&cfg, os.LookupEnv); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// Resolve picks the config file: the flag value, then $GOFILE_CONFIG, then
+// DefaultPath if it exists. An empty result means defaults and environment only.
+func Resolve(flagPath string) string {
+	if flagPath != "" {
+		return flagPath
+	}
+	if p := os.Getenv(EnvPrefix + "_CONFIG"); p != "" {
+		return p
+	}
+	if _, err := os.Stat(DefaultPath); err == nil {
+		return DefaultPath
+	}
+	return ""
+}
+
+// ApplyEnv overrides scalar fields from environment variables named after
+// their YAML path, e.g. GOFILE_TLS_CERT_FILE for tls.cert_file. Lists are
+// comma-separated; lists of objects and maps cannot be overridden.
+func ApplyEnv(cfg *Config, lookup func(string) (string, bool)) error {
+	var errs []error
+	applyEnv(reflect.ValueOf(cfg).Elem(), EnvPrefix, lookup, &errs)
+	return errors.Join(errs...)
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func applyEnv(v reflect.Value, prefix string, lookup func(string) (string, bool), errs *[]error) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(tag)
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct {
+			applyEnv(fv, name, lookup, errs)
+			continue
+		}
+		raw, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setField(fv, raw); err != nil {
+			*errs = append(*errs, fmt.Errorf("config: %s: %w", name, err))
+		}
+	}
+}
+
+func setField(fv reflect.Value, raw string) error {
+	if fv.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("cannot be set from the environment")
+		}
+		var items []string
+		for _, s := range strings.Split(raw, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				items = append(items, s)
+			}
+		}
+		fv.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("cannot be set from the environment")
+	}
+	return nil
+}
+
+var sha256Hex = regexp.MustCompile(`^[a-f0-9]{64}$`)
+
+// Validate reports every invalid setting at once.
+func (c Config) Validate() error {
+	var errs []error
+	bad := func(field, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("config: %s: "+format, append([]any{field}, args...)...))
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		bad("port", "must be between 1 and 65535, got %d", c.Port)
+	}
+	if c.BlobStore == "" {
+		bad("blob_store", "is required")
+	}
+	if c.MetaStore == "" {
+		bad("meta_store", "is required")
+	}
+
+	for _, d := range []struct {
+		field string
+		value time.Duration
+	}{
+		{"server.read_header_timeout", c.Server.ReadHeaderTimeout},
+		{"server.read_timeout", c.Server.ReadTimeout},
+		{"server.write_timeout", c.Server.WriteTimeout},
+		{"server.idle_timeout", c.Server.IdleTimeout},
+		{"server.drain_delay", c.Server.DrainDelay},
+	} {
+		if d.value < 0 {
+			bad(d.field, "must not be negative")
+		}
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		bad("server.shutdown_timeout", "must be positive")
+	}
+
+	if c.Limits.MaxBodyBytes < 0 {
+		bad("limits.max_body_bytes", "must not be negative")
+	}
+	if c.Limits.MaxBlobBytes < 0 {
+		bad("limits.max_blob_bytes", "must not be negative")
+	}
+	if c.Limits.MaxEntries < 0 {
+		bad("limits.max_entries", "must not be negative")
+	}
+	if c.Limits.MaxPathLength < 0 {
+		bad("limits.max_path_length", "must not be negative")
+	}
+	if c.Limits.MaxBatchBlobs < 0 {
+		bad("limits.max_batch_blobs", "must not be negative")
+	}
+
+	if c.Quotas.DefaultBytes < 0 {
+		bad("quotas.default_bytes", "must not be negative")
+	}
+	for i, n := range c.Quotas.Namespaces {
+		if n.Name == "" {
+			bad(fmt.Sprintf("quotas.namespaces[%d].name", i), "is required")
+		}
+		if n.Bytes < 0 {
+			bad(fmt.Sprintf("quotas.namespaces[%d].bytes", i), "must not be negative")
+		}
+	}
+
+	for _, rc := range []struct {
+		field string
+		class RateClass
+	}{
+		{"rate_limit.read", c.RateLimit.Read},
+		{"rate_limit.write", c.RateLimit.Write},
+		{"rate_limit.check", c.RateLimit.Check},
+	} {
+		if c.RateLimit.Enabled && (rc.class.Rate <= 0 || rc.class.Burst < 1) {
+			bad(rc.field, "rate and burst must be positive when rate_limit.enabled is true")
+		}
+	}
+	for _, p := range c.RateLimit.TrustedProxies {
+		if _, err := parsePrefix(p); err != nil {
+			bad("rate_limit.trusted_proxies", "%q is not an IP or CIDR", p)
+		}
+	}
+
+	if !slices.Contains([]string{"none", "read", "write", "admin"}, c.Auth.AnonymousScope) {
+		bad("auth.anonymous_scope", "must be none, read, write or admin, got %q", c.Auth.AnonymousScope)
+	}
+	seen := map[string]bool{}
+	for i, t := range c.Auth.Tokens {
+		field := fmt.Sprintf("auth.tokens[%d]", i)
+		if t.Name == "" {
+			bad(field+".name", "is required")
+		} else if seen[t.Name] {
+			bad(field+".name", "duplicate token name %q", t.Name)
+		}
+		seen[t.Name] = true
+		if !sha256Hex.MatchString(t.SHA256) {
+			bad(field+".sha256", "must be a lowercase hex SHA-256 of the token")
+		}
+		if !slices.Contains([]string{"read", "write", "admin"}, t.Scope) {
+			bad(field+".scope", "must be read, write or admin, got %q", t.Scope)
+		}
+	}
+
+	if c.TLS.Enabled {
+		if c.TLS.CertFile == "" {
+			bad("tls.cert_file", "is required when tls.enabled is true")
+		}
+		if c.TLS.KeyFile == "" {
+			bad("tls.key_file", "is required when tls.enabled is true")
+		}
+		if c.TLS.ClientAuth != "none" && c.TLS.ClientCAFile == "" {
+			bad("tls.client_ca_file", "is required when tls.client_auth is %s", c.TLS.ClientAuth)
+		}
+		if c.TLS.RedirectHTTPPort == c.Port {
+			bad("tls.redirect_http_port", "must differ from port")
+		}
+	}
+	if !slices.Contains([]string{"none", "request", "require"}, c.TLS.ClientAuth) {
+		bad("tls.client_auth", "must be none, request or require, got %q", c.TLS.ClientAuth)
+	}
+	if !slices.Contains([]string{"read", "write", "admin"}, c.TLS.ClientCertScope) {
+		bad("tls.client_cert_scope", "must be read, write or admin, got %q", c.TLS.ClientCertScope)
+	}
+	if c.TLS.ReloadInterval < 0 {
+		bad("tls.reload_interval", "must not be negative")
+	}
+	if c.TLS.RedirectHTTPPort < 0 || c.TLS.RedirectHTTPPort > 65535 {
+		bad("tls.redirect_http_port", "must be between 0 and 65535, got %d", c.TLS.RedirectHTTPPort)
+	}
+
+	if !slices.Contains([]string{"debug", "info", "warn", "error"}, c.Log.Level) {
+		bad("log.level", "must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+	if !slices.Contains([]string{"json", "text"}, c.Log.Format) {
+		bad("log.format", "must be json or text, got %q", c.Log.Format)
+	}
+
+	if c.Uploads.TTL <= 0 {
+		bad("uploads.ttl", "must be positive")
+	}
+	if c.Uploads.CleanupInterval <= 0 {
+		bad("uploads.cleanup_interval", "must be positive")
+	}
+
+	for _, o := range c.CORS.AllowedOrigins {
+		if o == "*" {
+			if c.CORS.AllowCredentials {
+				bad("cors.allowed_origins", "\"*\" cannot be combined with allow_credentials; list the origins")
+			}
+			continue
+		}
+		u, err := url.Parse(strings.Replace(o, "://*.", "://", 1))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+			bad("cors.allowed_origins", "must be scheme://host[:port], scheme://*.domain or \"*\", got %q", o)
+		}
+	}
+	for _, m := range c.CORS.AllowedMethods {
+		if m == "" || m != strings.ToUpper(m) || strings.ContainsAny(m, " ,") {
+			bad("cors.allowed_methods", "must be upper-case HTTP methods, got %q", m)
+		}
+	}
+	if c.CORS.MaxAge < 0 {
+		bad("cors.max_age", "must not be negative")
+	}
+
+	if c.Compression.MinSize < 0 {
+		bad("compression.min_size", "must not be negative")
+	}
+	if c.Compression.Level < 0 || c.Compression.Level > 9 {
+		bad("compression.level", "must be between 0 and 9, got %d", c.Compression.Level)
+	}
+	for _, e := range c.Compression.Encodings {
+		if !slices.Contains([]string{"gzip", "deflate"}, e) {
+			bad("compression.encodings", "unsupported encoding %q (gzip, deflate)", e)
+		}
+	}
+
+	if c.Tracing.Enabled {
+		if u, err := url.Parse(c.Tracing.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			bad("tracing.endpoint", "must be an http(s) URL, got %q", c.Tracing.Endpoint)
+		}
+		if c.Tracing.Timeout <= 0 {
+			bad("tracing.timeout", "must be positive")
+		}
+		if c.Tracing.FlushInterval <= 0 {
+			bad("tracing.flush_interval", "must be positive")
+		}
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		bad("tracing.sample_ratio", "must be between 0 and 1, got %g", c.Tracing.SampleRatio)
+	}
+	for _, h := range c.Tracing.Headers {
+		if k, _, ok := strings.Cut(h, "="); !ok || strings.TrimSpace(k) == "" {
+			bad("tracing.headers", "entry %q must be Name=value", h)
+		}
+	}
+
+	keys := map[string]bool{}
+	for i, k := range c.Signing.Keys {
+		field := fmt.Sprintf("signing.keys[%d]", i)
+		if k.Name == "" || strings.Contains(k.Name, ":") {
+			bad(field+".name", "is required and must not contain ':'")
+		} else if keys[k.Name] {
+			bad(field+".name", "duplicate key name %q", k.Name)
+		}
+		keys[k.Name] = true
+		if _, err := signing.ParseKey(k.PublicKey); err != nil {
+			bad(field+".public_key", "must be a base64 ed25519 public key")
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadDefaultsWithoutFile(t *testing.T) {
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.Port != 8080 || cfg.BlobStore != "./blobs" || cfg.Auth.AnonymousScope != "write" {
+		t.Fatalf("unexpected defaults: %+v", cfg)
+	}
+}
+
+func TestLoadFileAndEnvOverrides(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "config.yaml")
+	data := "port: 9000\nblob_store: /data/blobs\nlimits:\n  max_entries: 10\nuploads:\n  ttl: 1h\n"
+	if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOFILE_PORT", "9100")
+	t.Setenv("GOFILE_LOG_LEVEL", "debug")
+	t.Setenv("GOFILE_UPLOADS_CLEANUP_INTERVAL", "2h")
+
+	cfg, err := Load(p)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.Port != 9100 {
+		t.Errorf("expected env port 9100, got %d", cfg.Port)
+	}
+	if cfg.BlobStore != "/data/blobs" || cfg.MetaStore != "./meta.db" {
+		t.Errorf("expected file blob_store and default meta_store, got %q %q", cfg.BlobStore, cfg.MetaStore)
+	}
+	if cfg.Limits.MaxEntries != 10 || cfg.Limits.MaxPathLength != 1024 {
+		t.Errorf("unexpected limits %+v", cfg.Limits)
+	}
+	if cfg.Uploads.TTL != time.Hour || cfg.Uploads.CleanupInterval != 2*time.Hour {
+		t.Errorf("unexpected uploads %+v", cfg.Uploads)
+	}
+	if cfg.Log.Level != "debug" {
+		t.Errorf("expected env log level debug, got %q", cfg.Log.Level)
+	}
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(p, []byte("prot: 80\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(p); err == nil || !strings.Contains(err.Error(), "prot") {
+		t.Fatalf("expected unknown field error, got %v", err)
+	}
+}
+
+func TestValidateReportsAllErrors(t *testing.T) {
+	cfg := Default()
+	cfg.Port = 0
+	cfg.TLS.Enabled = true
+	cfg.Auth.Tokens = []Token{{Name: "ci", SHA256: "nothex", Scope: "root"}}
+	cfg.CORS.AllowCredentials = true
+	cfg.Signing.Keys = []SigningKey{{Name: "release", PublicKey: "c2hvcnQ="}}
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	for _, want := range []string{"port", "tls.cert_file", "tls.key_file", "auth.tokens[0].sha256", "auth.tokens[0].scope", "cors.allowed_origins", "signing.keys[0].public_key"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to mention %s, got: %v", want, err)
+		}
+	}
+}
+
+func TestApplyEnvRejectsBadValues(t *testing.T) {
+	cfg := Default()
+	env := map[string]string{"GOFILE_PORT": "eighty"}
+	err := ApplyEnv(&cfg, func(k string) (string, bool) { v, ok := env[k]; return v, ok })
+	if err == nil || !strings.Contains(err.Error(), "GOFILE_PORT") {
+		t.Fatalf("expected GOFILE_PORT error, got %v", err)
+	}
+}
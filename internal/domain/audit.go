@@ -0,0 +1,105 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"sync"
+
+	"fgo/internal/auth"
+	"fgo/internal/observe"
+	"fgo/internal/storage/metastore"
+)
+
+// Audit actions.
+const (
+	ActionBoxCreate     = "box.create"
+	ActionBoxVisibility = "box.visibility"
+	ActionPushFinalize  = "push.finalize"
+	ActionRefMove       = "ref.move"
+	ActionRefForce      = "ref.force"
+	ActionRefDelete     = "ref.delete"
+	ActionTagCreate     = "tag.create"
+	ActionTagMove       = "tag.move"
+	ActionTagDelete     = "tag.delete"
+	ActionMerge         = "merge"
+	ActionRuleSet       = "branch_rule.set"
+	ActionRuleDelete    = "branch_rule.delete"
+)
+
+// AuditLog records who changed what in the metastore's append-only audit
+// table and, optionally, as JSON lines to a sink. A nil *AuditLog records
+// nothing.
+//
+// Recording is best-effort: an event is appended after the change it
+// describes has been stored, not in the same transaction, so a crash or a
+// failed append between the two leaves the change without an event. Pushes
+// and ref moves are also in the reflog, which is written atomically with
+// them.
+type AuditLog struct {
+	meta metastore.MetadataStore
+
+	mu   sync.Mutex
+	sink io.Writer
+}
+
+// NewAuditLog returns an audit log backed by meta. sink may be nil.
+func NewAuditLog(meta metastore.MetadataStore, sink io.Writer) *AuditLog {
+	return &AuditLog{meta: meta, sink: sink}
+}
+
+// Record appends an event for action on target, taking the principal and
+// request ID from ctx. before and after are stored as JSON; nil omits them.
+// Failures are logged at error level rather than returned: the audited
+// change has already been made and must not be reported as failed.
+func (a *AuditLog) Record(ctx context.Context, action, target, boxID string, before, after any) {
+	if a == nil {
+		return
+	}
+	e := metastore.AuditEvent{Action: action, Target: target, BoxID: boxID, RequestID: observe.RequestID(ctx)}
+	if p, ok := auth.FromContext(ctx); ok {
+		e.Principal = p.ID
+	}
+	if e.Principal == "" {
+		e.Principal = "anonymous"
+	}
+	e.Before, e.After = auditValue(before), auditValue(after)
+
+	e, err := a.meta.AppendAudit(ctx, e)
+	if err != nil {
+		slog.ErrorContext(ctx, "audit append failed", "action", action, "target", target, "error", err)
+		return
+	}
+	if a.sink == nil {
+		return
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.sink.Write(append(line, '\n')); err != nil {
+		slog.ErrorContext(ctx, "audit sink write failed", "action", action, "error", err)
+	}
+}
+
+// List returns audit events matching f, newest first.
+func (a *AuditLog) List(ctx context.Context, f metastore.AuditFilter) ([]metastore.AuditEvent, error) {
+	if f.Limit <= 0 || f.Limit > 1000 {
+		f.Limit = 100
+	}
+	return a.meta.ListAudit(ctx, f)
+}
+
+func auditValue(v any) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return b
+}
@@ -0,0 +1,160 @@
+package domain
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"fgo/internal/integrity"
+)
+
+// checkConcurrency bounds the blob store lookups one existence check runs at once.
+const checkConcurrency = 16
+
+// batchHeaderSize is the frame header of a batch upload: a raw 32-byte
+// SHA-256 followed by the content length as a big-endian uint64.
+const batchHeaderSize = sha256Size + 8
+
+const sha256Size = 32
+
+// CheckBlobs returns the digests in shas that have not been uploaded,
+// without duplicates and in the order they were first given.
+func (s *PushService) CheckBlobs(ctx context.Context, shas []string) ([]string, error) {
+	if max := s.limits.MaxBatchBlobs; max > 0 && len(shas) > max {
+		return nil, &LimitError{Limit: "batch blobs", Max: int64(max), Got: int64(len(shas))}
+	}
+	for _, sha := range shas {
+		if !validSHA256(sha) {
+			return nil, fmt.Errorf("%w: invalid sha256 %q", ErrInvalidRequest, sha)
+		}
+	}
+	return s.missing(ctx, shas)
+}
+
+// missing checks the unique digests in shas concurrently and returns those
+// not in the blob store. The first lookup error cancels the rest.
+func (s *PushService) missing(ctx context.Context, shas []string) ([]string, error) {
+	seen := make(map[string]struct{}, len(shas))
+	uniq := make([]string, 0, len(shas))
+	for _, sha := range shas {
+		if _, ok := seen[sha]; !ok {
+			seen[sha] = struct{}{}
+			uniq = append(uniq, sha)
+		}
+	}
+
+	checkCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	present := make([]bool, len(uniq))
+	sem := make(chan struct{}, checkConcurrency)
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for i, sha := range uniq {
+		select {
+		case sem <- struct{}{}:
+		case <-checkCtx.Done():
+		}
+		if checkCtx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			ok, err := s.blobs.Has(checkCtx, sha)
+			if err != nil {
+				errOnce.Do(func() { firstErr = err; cancel() })
+				return
+			}
+			present[i] = ok
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	missing := []string{}
+	for i, sha := range uniq {
+		if !present[i] {
+			missing = append(missing, sha)
+		}
+	}
+	return missing, nil
+}
+
+// BatchResult summarizes a batch upload.
+type BatchResult struct {
+	Stored   int   `json:"stored"`
+	Existing int   `json:"existing"`
+	Bytes    int64 `json:"bytes"`
+}
+
+// PutBlobs stores every blob framed in r until EOF. Each frame is a raw
+// 32-byte SHA-256, the content length as a big-endian uint64, then the
+// content. Content is verified against its digest as it streams; the first
+// mismatch stops the batch with a DigestMismatchError, keeping the blobs
+// stored before it. Blobs already present are skipped unverified.
+func (s *PushService) PutBlobs(ctx context.Context, r io.Reader) (BatchResult, error) {
+	var res BatchResult
+	var hdr [batchHeaderSize]byte
+	for i := 0; ; i++ {
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return res, nil
+			}
+			return res, truncated(err, i)
+		}
+		if max := s.limits.MaxBatchBlobs; max > 0 && i >= max {
+			return res, &LimitError{Limit: "batch blobs", Max: int64(max), Got: int64(i + 1)}
+		}
+		sha := hex.EncodeToString(hdr[:sha256Size])
+		size := int64(binary.BigEndian.Uint64(hdr[sha256Size:]))
+		if size < 0 {
+			return res, fmt.Errorf("%w: blob %d has invalid length", ErrInvalidRequest, i)
+		}
+		if max := s.limits.MaxBlobBytes; max > 0 && size > max {
+			return res, &LimitError{Limit: "blob size", Max: max, Got: size}
+		}
+
+		ok, err := s.blobs.Has(ctx, sha)
+		if err != nil {
+			return res, err
+		}
+		if ok {
+			if _, err := io.CopyN(io.Discard, r, size); err != nil {
+				return res, truncated(err, i)
+			}
+			res.Existing++
+			continue
+		}
+		if size == 0 && sha != integrity.EmptySHA256 {
+			return res, &DigestMismatchError{SHA256: sha, Index: i}
+		}
+		err = s.blobs.Put(ctx, sha, integrity.NewReader(r, size, sha), size)
+		switch {
+		case errors.Is(err, integrity.ErrDigestMismatch):
+			return res, &DigestMismatchError{SHA256: sha, Index: i}
+		case err != nil:
+			return res, truncated(err, i)
+		}
+		res.Stored++
+		res.Bytes += size
+	}
+}
+
+// truncated reports a batch stream that ended inside frame i as a bad request.
+func truncated(err error, i int) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: batch truncated in blob %d", ErrInvalidRequest, i)
+	}
+	return err
+}
@@ -0,0 +1,165 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"fgo/internal/storage/metastore"
+)
+
+// BoxService manages boxes and read access to their history.
+type BoxService struct {
+	meta  metastore.MetadataStore
+	audit *AuditLog
+}
+
+func NewBoxService(meta metastore.MetadataStore, audit *AuditLog) *BoxService {
+	return &BoxService{meta: meta, audit: audit}
+}
+
+// CreateBoxRequest describes a new box.
+type CreateBoxRequest struct {
+	Name          string `json:"name"`
+	Visibility    string `json:"visibility"`
+	DefaultBranch string `json:"default_branch"`
+}
+
+// Create validates req and stores a new box in the default namespace.
+func (s *BoxService) Create(ctx context.Context, req CreateBoxRequest) (metastore.Box, error) {
+	if req.Name == "" {
+		return metastore.Box{}, fmt.Errorf("%w: name is required", ErrInvalidRequest)
+	}
+	if req.Visibility != "" {
+		if err := validVisibility(req.Visibility); err != nil {
+			return metastore.Box{}, err
+		}
+	}
+	if req.DefaultBranch == "" {
+		req.DefaultBranch = "main"
+	}
+	b := metastore.Box{NamespaceID: DefaultNamespace, Name: req.Name, Visibility: req.Visibility, DefaultBranch: req.DefaultBranch}
+	b, err := s.meta.CreateBox(ctx, b)
+	if err != nil {
+		return metastore.Box{}, err
+	}
+	s.audit.Record(ctx, ActionBoxCreate, "box:"+b.Name, b.ID, nil,
+		map[string]string{"name": b.Name, "visibility": b.Visibility, "default_branch": b.DefaultBranch})
+	return b, nil
+}
+
+// SetVisibility changes the visibility of box.
+func (s *BoxService) SetVisibility(ctx context.Context, box metastore.Box, visibility string) (metastore.Box, error) {
+	if err := validVisibility(visibility); err != nil {
+		return metastore.Box{}, err
+	}
+	if visibility == box.Visibility {
+		return box, nil
+	}
+	if err := s.meta.SetBoxVisibility(ctx, box.ID, visibility); err != nil {
+		return metastore.Box{}, notFound(err, ErrBoxNotFound)
+	}
+	s.audit.Record(ctx, ActionBoxVisibility, "box:"+box.Name, box.ID, box.Visibility, visibility)
+	box.Visibility = visibility
+	return box, nil
+}
+
+func validVisibility(v string) error {
+	switch v {
+	case "public", "unlisted", "private":
+		return nil
+	}
+	return fmt.Errorf("%w: visibility must be public, unlisted or private", ErrInvalidRequest)
+}
+
+// Get looks up a box by name.
+func (s *BoxService) Get(ctx context.Context, name string) (metastore.Box, error) {
+	b, err := s.meta.GetBox(ctx, DefaultNamespace, name)
+	if err != nil {
+		return metastore.Box{}, notFound(err, ErrBoxNotFound)
+	}
+	return b, nil
+}
+
+// ListPublic returns all public boxes.
+func (s *BoxService) ListPublic(ctx context.Context) ([]metastore.Box, error) {
+	return s.meta.ListPublicBoxes(ctx)
+}
+
+// Latest returns the head commit of branch, or of the default branch if empty.
+func (s *BoxService) Latest(ctx context.Context, box metastore.Box, branch string) (metastore.Commit, error) {
+	if branch == "" {
+		branch = box.DefaultBranch
+	}
+	c, err := s.meta.LatestCommit(ctx, box.ID, branch)
+	if err != nil {
+		return metastore.Commit{}, notFound(err, ErrCommitNotFound)
+	}
+	return c, nil
+}
+
+// Commits returns up to limit recent commits on branch, or on the default branch if empty.
+func (s *BoxService) Commits(ctx context.Context, box metastore.Box, branch string, limit int) ([]metastore.Commit, error) {
+	if branch == "" {
+		branch = box.DefaultBranch
+	}
+	cs, err := s.meta.ListCommits(ctx, box.ID, branch, limit)
+	if err != nil {
+		return nil, notFound(err, ErrCommitNotFound)
+	}
+	return cs, nil
+}
+
+// Commit returns a commit by ID, provided it belongs to box.
+func (s *BoxService) Commit(ctx context.Context, box metastore.Box, id string) (metastore.Commit, error) {
+	c, err := s.meta.GetCommitByID(ctx, id)
+	if err != nil {
+		return metastore.Commit{}, notFound(err, ErrCommitNotFound)
+	}
+	if c.BoxID != box.ID {
+		return metastore.Commit{}, ErrCommitNotFound
+	}
+	return c, nil
+}
+
+// Resolve finds the commit rev names in box: a tag, else a branch head,
+// else a commit ID.
+func (s *BoxService) Resolve(ctx context.Context, box metastore.Box, rev string) (metastore.Commit, error) {
+	return resolve(ctx, s.meta, box, rev)
+}
+
+func resolve(ctx context.Context, meta metastore.MetadataStore, box metastore.Box, rev string) (metastore.Commit, error) {
+	id, err := resolveID(ctx, meta, box, rev)
+	if err != nil {
+		return metastore.Commit{}, err
+	}
+	c, err := meta.GetCommitByID(ctx, id)
+	if err != nil || c.BoxID != box.ID {
+		return metastore.Commit{}, notFound(orNotFound(err), ErrCommitNotFound)
+	}
+	return c, nil
+}
+
+// resolveID is resolve without loading the commit. A rev that names no tag
+// or branch is returned as is, for the caller to look up as a commit ID in
+// box.
+func resolveID(ctx context.Context, meta metastore.MetadataStore, box metastore.Box, rev string) (string, error) {
+	if rev == "" {
+		return "", ErrCommitNotFound
+	}
+	t, err := meta.GetTag(ctx, box.ID, rev)
+	if err == nil {
+		return t.CommitID, nil
+	}
+	if !errors.Is(err, metastore.ErrNotFound) {
+		return "", err
+	}
+	id, err := meta.GetRef(ctx, box.ID, rev)
+	if err == nil {
+		return id, nil
+	}
+	if !errors.Is(err, metastore.ErrNotFound) {
+		return "", err
+	}
+	return rev, nil
+}
@@ -0,0 +1,218 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"fgo/internal/auth"
+	"fgo/internal/storage/metastore"
+)
+
+// Reflog reasons recorded for ref updates made by the server itself.
+const (
+	ReasonPush      = "push"
+	ReasonForcePush = "force-push"
+	ReasonMerge     = "merge"
+	ReasonUpdate    = "update"
+	ReasonDelete    = "delete"
+	ReasonReset     = "reset"
+)
+
+// BranchService reads and moves branch heads directly, outside a push.
+type BranchService struct {
+	meta  metastore.MetadataStore
+	audit *AuditLog
+}
+
+func NewBranchService(meta metastore.MetadataStore, audit *AuditLog) *BranchService {
+	return &BranchService{meta: meta, audit: audit}
+}
+
+// BranchUpdate points a branch at CommitID. ExpectedOld is a lease: the
+// update only applies if the branch currently points there ("" meaning it
+// must not exist yet). A nil ExpectedOld forces the update.
+type BranchUpdate struct {
+	CommitID    string  `json:"commit_id"`
+	ExpectedOld *string `json:"expected_old"`
+	Reason      string  `json:"reason"`
+}
+
+// Force reports whether u skips the lease check.
+func (u BranchUpdate) Force() bool { return u.ExpectedOld == nil }
+
+// BranchResult describes a branch after an update. Previous is the head it
+// replaced, empty if the branch was created.
+type BranchResult struct {
+	Name     string `json:"name"`
+	CommitID string `json:"commit_id,omitempty"`
+	Previous string `json:"previous,omitempty"`
+	Forced   bool   `json:"forced"`
+}
+
+// List returns the branches of box by name.
+func (s *BranchService) List(ctx context.Context, box metastore.Box) ([]metastore.Ref, error) {
+	return s.meta.ListRefs(ctx, box.ID)
+}
+
+// Get returns one branch of box.
+func (s *BranchService) Get(ctx context.Context, box metastore.Box, name string) (metastore.Ref, error) {
+	id, err := s.meta.GetRef(ctx, box.ID, name)
+	if err != nil {
+		return metastore.Ref{}, notFound(err, ErrBranchNotFound)
+	}
+	return metastore.Ref{Name: name, CommitID: id}, nil
+}
+
+// Set creates or moves branch name to a commit of box. A lease that no
+// longer holds fails with ErrParentMismatch.
+func (s *BranchService) Set(ctx context.Context, box metastore.Box, name string, req BranchUpdate) (BranchResult, error) {
+	if err := validRefName(name); err != nil {
+		return BranchResult{}, err
+	}
+	c, err := s.meta.GetCommitByID(ctx, req.CommitID)
+	if err != nil || c.BoxID != box.ID {
+		return BranchResult{}, notFound(orNotFound(err), ErrCommitNotFound)
+	}
+	if req.Reason == "" {
+		req.Reason = ReasonUpdate
+	}
+	u := metastore.RefUpdate{BoxID: box.ID, Branch: name, New: c.ID, Force: req.Force(), Reason: req.Reason}
+	if !u.Force {
+		u.Old = *req.ExpectedOld
+	}
+	if err := authorizeRef(ctx, s.meta, &u, &c); err != nil {
+		return BranchResult{}, err
+	}
+	old, err := updateRef(ctx, s.meta, u)
+	if err != nil {
+		return BranchResult{}, err
+	}
+	recordRef(ctx, s.audit, box, u, old)
+	return BranchResult{Name: name, CommitID: c.ID, Previous: old, Forced: u.Force}, nil
+}
+
+// Delete removes branch name, subject to the same lease as Set. The default
+// branch cannot be deleted.
+func (s *BranchService) Delete(ctx context.Context, box metastore.Box, name string, expectedOld *string, reason string) (BranchResult, error) {
+	if name == box.DefaultBranch {
+		return BranchResult{}, fmt.Errorf("%w: cannot delete the default branch %q", ErrInvalidRequest, name)
+	}
+	if reason == "" {
+		reason = ReasonDelete
+	}
+	u := metastore.RefUpdate{BoxID: box.ID, Branch: name, Force: expectedOld == nil, Reason: reason}
+	if !u.Force {
+		u.Old = *expectedOld
+	}
+	if err := authorizeRef(ctx, s.meta, &u, nil); err != nil {
+		return BranchResult{}, err
+	}
+	old, err := updateRef(ctx, s.meta, u)
+	if err != nil {
+		return BranchResult{}, err
+	}
+	recordRef(ctx, s.audit, box, u, old)
+	return BranchResult{Name: name, Previous: old, Forced: u.Force}, nil
+}
+
+// Reflog returns up to limit reflog entries of branch, newest first, older
+// than entry beforeID if it is set.
+func (s *BranchService) Reflog(ctx context.Context, box metastore.Box, name string, beforeID int64, limit int) ([]metastore.RefLogEntry, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	return s.meta.ListRefLog(ctx, box.ID, name, beforeID, limit)
+}
+
+// ResetRequest points a branch back at the head recorded by reflog entry
+// EntryID. ExpectedOld is a lease as in BranchUpdate.
+type ResetRequest struct {
+	EntryID     int64   `json:"entry_id"`
+	ExpectedOld *string `json:"expected_old"`
+	Reason      string  `json:"reason"`
+}
+
+// Reset moves branch name to the commit it pointed at after reflog entry
+// req.EntryID, recreating it if it has since been deleted. The reset is
+// itself a reflog entry, so it can be undone the same way.
+func (s *BranchService) Reset(ctx context.Context, box metastore.Box, name string, req ResetRequest) (BranchResult, error) {
+	e, err := s.meta.GetRefLogEntry(ctx, req.EntryID)
+	if err != nil || e.BoxID != box.ID || e.Branch != name {
+		return BranchResult{}, notFound(orNotFound(err), ErrReflogNotFound)
+	}
+	if e.New == "" {
+		return BranchResult{}, fmt.Errorf("%w: reflog entry %d deleted the branch; reset to an earlier entry", ErrInvalidRequest, e.ID)
+	}
+	if req.Reason == "" {
+		req.Reason = fmt.Sprintf("%s to reflog entry %d", ReasonReset, e.ID)
+	}
+	return s.Set(ctx, box, name, BranchUpdate{CommitID: e.New, ExpectedOld: req.ExpectedOld, Reason: req.Reason})
+}
+
+// validRefName accepts branch and tag names that are clean relative paths,
+// like "release/1.2", and never contain "..." (the compare separator).
+func validRefName(name string) error {
+	if len(name) > 255 || strings.Contains(name, "...") || validPath(name) != nil {
+		return fmt.Errorf("%w: invalid name %q", ErrInvalidRequest, name)
+	}
+	return nil
+}
+
+// authorizeRef sets u.Principal to the principal in ctx and checks u
+// against the box's branch rules, next being the commit u moves the branch
+// to (nil for a deletion). Callers run it before writing
+// anything, so a refused update is a ProtectionError with nothing stored.
+func authorizeRef(ctx context.Context, meta metastore.MetadataStore, u *metastore.RefUpdate, next *metastore.Commit) error {
+	if p, ok := auth.FromContext(ctx); ok {
+		u.Principal = p.ID
+	}
+	if u.Principal == "" {
+		u.Principal = "anonymous"
+	}
+	return checkRules(ctx, meta, *u, next)
+}
+
+// updateRef applies u, which authorizeRef has checked, and returns the
+// previous head. A lease that did not hold is a ParentMismatchError.
+func updateRef(ctx context.Context, meta metastore.MetadataStore, u metastore.RefUpdate) (string, error) {
+	old, err := meta.UpdateRef(ctx, u)
+	if err != nil {
+		return "", refError(err, u)
+	}
+	return old, nil
+}
+
+// refError translates a metastore error from applying u.
+func refError(err error, u metastore.RefUpdate) error {
+	switch {
+	case errors.Is(err, metastore.ErrParentMismatch):
+		return &ParentMismatchError{Branch: u.Branch, ParentCommitID: u.Old}
+	case errors.Is(err, metastore.ErrNotFound):
+		return ErrBranchNotFound
+	}
+	return err
+}
+
+// recordRef audits a ref update made with updateRef. Forced updates and
+// deletions get their own actions so they are easy to find.
+func recordRef(ctx context.Context, audit *AuditLog, box metastore.Box, u metastore.RefUpdate, old string) {
+	action := ActionRefMove
+	switch {
+	case u.New == "":
+		action = ActionRefDelete
+	case u.Force:
+		action = ActionRefForce
+	}
+	audit.Record(ctx, action, "ref:"+box.Name+"/"+u.Branch, box.ID, nullable(old), nullable(u.New))
+}
+
+// orNotFound treats a nil error as metastore.ErrNotFound, for lookups that
+// succeeded but found an object outside the caller's box.
+func orNotFound(err error) error {
+	if err == nil {
+		return metastore.ErrNotFound
+	}
+	return err
+}
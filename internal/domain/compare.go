@@ -0,0 +1,229 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"unicode/utf8"
+
+	"fgo/internal/storage/blobstore"
+	"fgo/internal/storage/metastore"
+)
+
+// MaxPatchBytes is the largest blob a comparison renders a text patch for.
+const MaxPatchBytes = 64 << 10
+
+// Change statuses in a Comparison.
+const (
+	ChangeAdded    = "added"
+	ChangeRemoved  = "removed"
+	ChangeModified = "modified"
+	ChangeRenamed  = "renamed"
+)
+
+// FileChange is one path that differs between two commits. Renamed entries
+// keep their content (same SHA256) under a new path and may change mode.
+type FileChange struct {
+	Status      string `json:"status"`
+	Path        string `json:"path"`
+	OldPath     string `json:"old_path,omitempty"`
+	SHA256      string `json:"sha256,omitempty"`
+	OldSHA256   string `json:"old_sha256,omitempty"`
+	Mode        int    `json:"mode,omitempty"`
+	OldMode     int    `json:"old_mode,omitempty"`
+	ModeChanged bool   `json:"mode_changed,omitempty"`
+	Size        int64  `json:"size"`
+	OldSize     int64  `json:"old_size"`
+	SizeDelta   int64  `json:"size_delta"`
+	// Patch is a unified diff, present when requested and both sides are
+	// small text blobs.
+	Patch string `json:"patch,omitempty"`
+}
+
+// CompareSummary counts a Comparison's changes.
+type CompareSummary struct {
+	Added     int   `json:"added"`
+	Removed   int   `json:"removed"`
+	Modified  int   `json:"modified"`
+	Renamed   int   `json:"renamed"`
+	Unchanged int   `json:"unchanged"`
+	SizeDelta int64 `json:"size_delta"`
+}
+
+// Comparison lists what changed from a base commit to a head commit.
+type Comparison struct {
+	BaseCommitID string         `json:"base_commit_id"`
+	HeadCommitID string         `json:"head_commit_id"`
+	Summary      CompareSummary `json:"summary"`
+	Files        []FileChange   `json:"files"`
+}
+
+// CompareOptions controls optional parts of a comparison.
+type CompareOptions struct {
+	// Patch adds unified diffs for changed text blobs up to MaxPatchBytes.
+	Patch bool
+	// Context is the number of unchanged lines around each hunk (default 3).
+	Context int
+}
+
+// CompareService diffs commits.
+type CompareService struct {
+	blobs blobstore.BlobStore
+}
+
+func NewCompareService(blobs blobstore.BlobStore) *CompareService {
+	return &CompareService{blobs: blobs}
+}
+
+// Compare lists the files that differ between base and head, sorted by path.
+// A removed and an added path with the same SHA256 are reported as one rename.
+func (s *CompareService) Compare(ctx context.Context, base, head metastore.Commit, opts CompareOptions) (Comparison, error) {
+	if opts.Context <= 0 {
+		opts.Context = 3
+	}
+	cmp := Comparison{BaseCommitID: base.ID, HeadCommitID: head.ID, Files: []FileChange{}}
+	old := make(map[string]metastore.Entry, len(base.Entries))
+	for _, e := range base.Entries {
+		old[e.Path] = e
+	}
+	var added []metastore.Entry
+	for _, e := range head.Entries {
+		prev, ok := old[e.Path]
+		delete(old, e.Path)
+		switch {
+		case !ok:
+			added = append(added, e)
+		case prev.SHA256 != e.SHA256 || prev.Mode != e.Mode:
+			cmp.Files = append(cmp.Files, change(ChangeModified, prev, e))
+		default:
+			cmp.Summary.Unchanged++
+		}
+	}
+
+	// Pair removed and added entries by content, in path order, as renames.
+	removedBySHA := map[string][]metastore.Entry{}
+	var removed []metastore.Entry
+	for _, e := range old {
+		removed = append(removed, e)
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i].Path < removed[j].Path })
+	sort.Slice(added, func(i, j int) bool { return added[i].Path < added[j].Path })
+	for _, e := range removed {
+		removedBySHA[e.SHA256] = append(removedBySHA[e.SHA256], e)
+	}
+	renamedFrom := map[string]bool{}
+	for _, e := range added {
+		if cands := removedBySHA[e.SHA256]; len(cands) > 0 {
+			removedBySHA[e.SHA256] = cands[1:]
+			renamedFrom[cands[0].Path] = true
+			cmp.Files = append(cmp.Files, change(ChangeRenamed, cands[0], e))
+			continue
+		}
+		cmp.Files = append(cmp.Files, change(ChangeAdded, metastore.Entry{}, e))
+	}
+	for _, e := range removed {
+		if !renamedFrom[e.Path] {
+			cmp.Files = append(cmp.Files, change(ChangeRemoved, e, metastore.Entry{Path: e.Path}))
+		}
+	}
+	sort.Slice(cmp.Files, func(i, j int) bool { return cmp.Files[i].Path < cmp.Files[j].Path })
+
+	for i := range cmp.Files {
+		f := &cmp.Files[i]
+		switch f.Status {
+		case ChangeAdded:
+			cmp.Summary.Added++
+		case ChangeRemoved:
+			cmp.Summary.Removed++
+		case ChangeModified:
+			cmp.Summary.Modified++
+		case ChangeRenamed:
+			cmp.Summary.Renamed++
+		}
+		cmp.Summary.SizeDelta += f.SizeDelta
+		if opts.Patch && f.SHA256 != f.OldSHA256 {
+			patch, err := s.patch(ctx, *f, opts.Context)
+			if err != nil {
+				return Comparison{}, err
+			}
+			f.Patch = patch
+		}
+	}
+	return cmp, nil
+}
+
+func change(status string, old, cur metastore.Entry) FileChange {
+	c := FileChange{
+		Status:    status,
+		Path:      cur.Path,
+		SHA256:    cur.SHA256,
+		OldSHA256: old.SHA256,
+		Mode:      cur.Mode,
+		OldMode:   old.Mode,
+		Size:      cur.Size,
+		OldSize:   old.Size,
+		SizeDelta: cur.Size - old.Size,
+	}
+	if status == ChangeRenamed {
+		c.OldPath = old.Path
+	}
+	if status == ChangeModified || status == ChangeRenamed {
+		c.ModeChanged = old.Mode != cur.Mode
+	}
+	return c
+}
+
+// patch renders f as a unified diff, or "" if either side is missing, binary
+// or larger than MaxPatchBytes.
+func (s *CompareService) patch(ctx context.Context, f FileChange, ctxLines int) (string, error) {
+	oldText, ok, err := s.text(ctx, f.OldSHA256)
+	if err != nil || !ok {
+		return "", err
+	}
+	newText, ok, err := s.text(ctx, f.SHA256)
+	if err != nil || !ok {
+		return "", err
+	}
+	oldName, newName := "a/"+f.Path, "b/"+f.Path
+	if f.OldPath != "" {
+		oldName = "a/" + f.OldPath
+	}
+	if f.Status == ChangeAdded {
+		oldName = "/dev/null"
+	}
+	if f.Status == ChangeRemoved {
+		newName = "/dev/null"
+	}
+	patch, _ := unifiedDiff(oldName, newName, oldText, newText, ctxLines)
+	return patch, nil
+}
+
+// text loads a blob for diffing. An empty sha, the absent side of an added or
+// removed file, is the empty text; ok is false for missing, binary or
+// oversized blobs.
+func (s *CompareService) text(ctx context.Context, sha string) (string, bool, error) {
+	if sha == "" {
+		return "", true, nil
+	}
+	rc, size, err := s.blobs.Open(ctx, sha)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	defer rc.Close()
+	if size > MaxPatchBytes {
+		return "", false, nil
+	}
+	data, err := io.ReadAll(io.LimitReader(rc, MaxPatchBytes+1))
+	if err != nil {
+		return "", false, err
+	}
+	if len(data) > MaxPatchBytes || bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(data) {
+		return "", false, nil
+	}
+	return string(data), true, nil
+}
@@ -0,0 +1,53 @@
+package domain
+
+import (
+	"sort"
+
+	"fgo/internal/storage/metastore"
+)
+
+// ManifestDiff compares a manifest with the commit it would replace. Paths
+// are sorted; a path is modified when its content or mode changes. Byte
+// totals use the new size for added and modified paths and the old size for
+// removed ones.
+type ManifestDiff struct {
+	Added          []string `json:"added"`
+	Modified       []string `json:"modified"`
+	Removed        []string `json:"removed"`
+	Unchanged      int      `json:"unchanged"`
+	AddedBytes     int64    `json:"added_bytes"`
+	ModifiedBytes  int64    `json:"modified_bytes"`
+	RemovedBytes   int64    `json:"removed_bytes"`
+	UnchangedBytes int64    `json:"unchanged_bytes"`
+}
+
+func diffManifests(base, next []metastore.Entry) ManifestDiff {
+	d := ManifestDiff{Added: []string{}, Modified: []string{}, Removed: []string{}}
+	old := make(map[string]metastore.Entry, len(base))
+	for _, e := range base {
+		old[e.Path] = e
+	}
+	for _, e := range next {
+		prev, ok := old[e.Path]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, e.Path)
+			d.AddedBytes += e.Size
+		case prev.SHA256 != e.SHA256 || prev.Mode != e.Mode:
+			d.Modified = append(d.Modified, e.Path)
+			d.ModifiedBytes += e.Size
+		default:
+			d.Unchanged++
+			d.UnchangedBytes += e.Size
+		}
+		delete(old, e.Path)
+	}
+	for p, e := range old {
+		d.Removed = append(d.Removed, p)
+		d.RemovedBytes += e.Size
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Modified)
+	sort.Strings(d.Removed)
+	return d
+}
@@ -1,3 +1,163 @@
+// Package domain implements gofile's business logic (boxes, pushes, file
+// lookup) on top of the BlobStore and MetadataStore interfaces. HTTP handlers
+// are thin adapters around these services.
 package domain
 
-// TODO: Implement services for files, boxes, versioning, visibility
+import (
+	"errors"
+	"fmt"
+
+	"fgo/internal/signing"
+	"fgo/internal/storage/metastore"
+)
+
+// DefaultNamespace is the namespace used until spaces are implemented.
+const DefaultNamespace = "global"
+
+var (
+	ErrBoxNotFound     = errors.New("box not found")
+	ErrCommitNotFound  = errors.New("commit not found")
+	ErrBranchNotFound  = errors.New("branch not found")
+	ErrReflogNotFound  = errors.New("reflog entry not found")
+	ErrRuleNotFound    = errors.New("branch rule not found")
+	ErrTagNotFound     = errors.New("tag not found")
+	ErrTagExists       = errors.New("tag already exists")
+	ErrPathNotFound    = errors.New("path not found")
+	ErrBlobNotFound    = errors.New("blob not found")
+	ErrParentMismatch  = errors.New("parent mismatch")
+	ErrInvalidRequest  = errors.New("invalid request")
+	ErrInvalidManifest = errors.New("invalid manifest")
+	ErrMissingBlob     = errors.New("missing blob")
+	ErrTooLarge        = errors.New("too large")
+	ErrQuotaExceeded   = errors.New("quota exceeded")
+	ErrMergeConflict   = errors.New("merge conflict")
+	ErrBranchProtected = errors.New("branch protected")
+	ErrBadSignature    = signing.ErrBadSignature
+
+	ErrUploadsUnsupported = errors.New("resumable uploads not supported by this blob store")
+	ErrUploadNotFound     = errors.New("upload session not found")
+	ErrOffsetMismatch     = errors.New("upload offset mismatch")
+	ErrUploadIncomplete   = errors.New("upload incomplete")
+	ErrDigestMismatch     = errors.New("blob digest mismatch")
+)
+
+// Limits bounds what a single request may store. Zero disables a limit.
+type Limits struct {
+	MaxBlobBytes  int64
+	MaxEntries    int
+	MaxPathLength int
+	// MaxBatchBlobs caps the digests in one batch check or blobs in one batch upload.
+	MaxBatchBlobs int
+	// Quota returns the storage quota in bytes for a namespace; nil or 0 means unlimited.
+	Quota func(namespace string) int64
+}
+
+// LimitError reports a request exceeding one of the configured Limits.
+type LimitError struct {
+	Limit string
+	Max   int64
+	Got   int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s exceeds limit: %d > %d", e.Limit, e.Got, e.Max)
+}
+
+func (e *LimitError) Is(target error) bool { return target == ErrTooLarge }
+
+// QuotaError reports a push that would take a namespace over its storage quota.
+type QuotaError struct {
+	Namespace string
+	Quota     int64
+	Used      int64
+	Needed    int64
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("namespace %s quota exceeded: %d used + %d new > %d bytes", e.Namespace, e.Used, e.Needed, e.Quota)
+}
+
+func (e *QuotaError) Is(target error) bool { return target == ErrQuotaExceeded }
+
+// MissingBlobError reports a manifest entry whose blob has not been uploaded.
+type MissingBlobError struct {
+	Path   string
+	SHA256 string
+}
+
+func (e *MissingBlobError) Error() string {
+	return fmt.Sprintf("missing blob %s for %s", e.SHA256, e.Path)
+}
+
+func (e *MissingBlobError) Is(target error) bool { return target == ErrMissingBlob }
+
+// ParentMismatchError reports a finalize whose parent is not the branch head.
+type ParentMismatchError struct {
+	Branch         string
+	ParentCommitID string
+}
+
+func (e *ParentMismatchError) Error() string {
+	return fmt.Sprintf("parent mismatch on branch %s (parent %q)", e.Branch, e.ParentCommitID)
+}
+
+func (e *ParentMismatchError) Is(target error) bool { return target == ErrParentMismatch }
+
+// MergeConflictError reports paths a three-way merge could not reconcile.
+type MergeConflictError struct {
+	BaseCommitID string
+	Conflicts    []MergeConflict
+}
+
+func (e *MergeConflictError) Error() string {
+	return fmt.Sprintf("merge conflict in %d path(s), first %s", len(e.Conflicts), e.Conflicts[0].Path)
+}
+
+func (e *MergeConflictError) Is(target error) bool { return target == ErrMergeConflict }
+
+// ProtectionError reports a ref update refused by the branch rule Pattern.
+type ProtectionError struct {
+	Branch  string
+	Pattern string
+	Reason  string
+}
+
+func (e *ProtectionError) Error() string {
+	return fmt.Sprintf("branch %s is protected by rule %q: %s", e.Branch, e.Pattern, e.Reason)
+}
+
+func (e *ProtectionError) Is(target error) bool { return target == ErrBranchProtected }
+
+// OffsetMismatchError reports a chunk that does not start where the upload
+// session currently ends. Offset is where the client should resume.
+type OffsetMismatchError struct {
+	Offset int64
+	Got    int64
+}
+
+func (e *OffsetMismatchError) Error() string {
+	return fmt.Sprintf("upload offset is %d, chunk starts at %d", e.Offset, e.Got)
+}
+
+func (e *OffsetMismatchError) Is(target error) bool { return target == ErrOffsetMismatch }
+
+// DigestMismatchError reports a blob in a batch upload whose content does not
+// hash to its declared digest. Index is the blob's position in the batch.
+type DigestMismatchError struct {
+	SHA256 string
+	Index  int
+}
+
+func (e *DigestMismatchError) Error() string {
+	return fmt.Sprintf("blob %d does not match sha256 %s", e.Index, e.SHA256)
+}
+
+func (e *DigestMismatchError) Is(target error) bool { return target == ErrDigestMismatch }
+
+// notFound translates metastore.ErrNotFound into the domain error for the looked up object.
+func notFound(err, as error) error {
+	if errors.Is(err, metastore.ErrNotFound) {
+		return as
+	}
+	return err
+}
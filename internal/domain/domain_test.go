@@ -0,0 +1,587 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"fgo/internal/storage/blobstore"
+	"fgo/internal/storage/metastore"
+)
+
+func newTestServices(t *testing.T) (*BoxService, *PushService, *FileService) {
+	t.Helper()
+	blobs := blobstore.NewBlobStoreFS(t.TempDir())
+	meta, err := metastore.NewSQLiteMetaStore(":memory:")
+	if err != nil {
+		t.Fatalf("meta open: %v", err)
+	}
+	audit := NewAuditLog(meta, nil)
+	return NewBoxService(meta, audit), NewPushService(blobs, meta, audit), NewFileService(blobs, meta)
+}
+
+// digest returns the hex SHA-256 of body, the name its blob is stored under.
+func digest(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestPushLifecycle(t *testing.T) {
+	ctx := context.Background()
+	boxes, push, files := newTestServices(t)
+
+	box, err := boxes.Create(ctx, CreateBoxRequest{Name: "demo"})
+	if err != nil {
+		t.Fatalf("create box: %v", err)
+	}
+	abc := digest("abc")
+	entries := []metastore.Entry{{Path: "a.txt", SHA256: abc, Size: 3, Mode: 420}, {Path: "b.txt", SHA256: abc, Size: 3, Mode: 420}}
+
+	plan, err := push.Plan(ctx, box, "", entries)
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if len(plan.Missing) != 1 || plan.Total != 2 {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+
+	_, err = push.Finalize(ctx, box, FinalizeRequest{Message: "init", Entries: entries})
+	if !errors.Is(err, ErrMissingBlob) {
+		t.Fatalf("expected ErrMissingBlob, got %v", err)
+	}
+
+	if err := push.PutBlob(ctx, abc, strings.NewReader("abc"), 3); err != nil {
+		t.Fatalf("put blob: %v", err)
+	}
+	res, err := push.Finalize(ctx, box, FinalizeRequest{Message: "init", Entries: entries})
+	if err != nil {
+		t.Fatalf("finalize: %v", err)
+	}
+
+	_, err = push.Finalize(ctx, box, FinalizeRequest{ParentCommitID: "stale", Entries: entries})
+	if !errors.Is(err, ErrParentMismatch) {
+		t.Fatalf("expected ErrParentMismatch, got %v", err)
+	}
+
+	latest, err := boxes.Latest(ctx, box, "")
+	if err != nil || latest.ID != res.CommitID {
+		t.Fatalf("expected latest %s, got %s (%v)", res.CommitID, latest.ID, err)
+	}
+
+	f, err := files.Open(ctx, res.CommitID, "b.txt")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	f.Body.Close()
+	if f.Size != 3 {
+		t.Fatalf("expected size 3, got %d", f.Size)
+	}
+	if _, err := files.Open(ctx, res.CommitID, "nope"); !errors.Is(err, ErrPathNotFound) {
+		t.Fatalf("expected ErrPathNotFound, got %v", err)
+	}
+	if _, err := boxes.Get(ctx, "nope"); !errors.Is(err, ErrBoxNotFound) {
+		t.Fatalf("expected ErrBoxNotFound, got %v", err)
+	}
+}
+
+func TestLimitsAndQuota(t *testing.T) {
+	ctx := context.Background()
+	boxes, push, _ := newTestServices(t)
+	push.SetLimits(Limits{MaxBlobBytes: 4, MaxEntries: 2, MaxPathLength: 8, Quota: func(string) int64 { return 5 }})
+	box, err := boxes.Create(ctx, CreateBoxRequest{Name: "demo"})
+	if err != nil {
+		t.Fatalf("create box: %v", err)
+	}
+
+	if err := push.PutBlob(ctx, digest("12345"), strings.NewReader("12345"), 5); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected ErrTooLarge for blob, got %v", err)
+	}
+	x := digest("x")
+	three := []metastore.Entry{{Path: "a", SHA256: x}, {Path: "b", SHA256: x}, {Path: "c", SHA256: x}}
+	if _, err := push.Plan(ctx, box, "", three); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected ErrTooLarge for entries, got %v", err)
+	}
+	long := []metastore.Entry{{Path: "a/very/long/path", SHA256: x}}
+	if _, err := push.Plan(ctx, box, "", long); !errors.Is(err, ErrInvalidManifest) {
+		t.Fatalf("expected ErrInvalidManifest for path, got %v", err)
+	}
+
+	one, two := digest("abc"), digest("defg")
+	for _, body := range []string{"abc", "defg"} {
+		if err := push.PutBlob(ctx, digest(body), strings.NewReader(body), int64(len(body))); err != nil {
+			t.Fatalf("put: %v", err)
+		}
+	}
+	// Declared sizes are ignored; the 3 stored bytes count.
+	first := []metastore.Entry{{Path: "a", SHA256: one, Size: 0}}
+	res, err := push.Finalize(ctx, box, FinalizeRequest{Entries: first})
+	if err != nil {
+		t.Fatalf("finalize within quota: %v", err)
+	}
+	second := []metastore.Entry{{Path: "a", SHA256: one}, {Path: "b", SHA256: two}}
+	_, err = push.Finalize(ctx, box, FinalizeRequest{ParentCommitID: res.CommitID, Entries: second})
+	var qe *QuotaError
+	if !errors.As(err, &qe) || qe.Used != 3 || qe.Needed != 4 || qe.Quota != 5 {
+		t.Fatalf("expected quota error 3+4>5, got %v", err)
+	}
+}
+
+func TestBatchCheckAndUpload(t *testing.T) {
+	ctx := context.Background()
+	_, push, _ := newTestServices(t)
+	push.SetLimits(Limits{MaxBatchBlobs: 3})
+
+	frame := func(content, claimed string) []byte {
+		sum := sha256.Sum256([]byte(claimed))
+		var b bytes.Buffer
+		b.Write(sum[:])
+		binary.Write(&b, binary.BigEndian, uint64(len(content)))
+		b.WriteString(content)
+		return b.Bytes()
+	}
+
+	var batch bytes.Buffer
+	batch.Write(frame("alpha", "alpha"))
+	batch.Write(frame("beta", "beta"))
+	batch.Write(frame("", ""))
+	res, err := push.PutBlobs(ctx, &batch)
+	if err != nil || res != (BatchResult{Stored: 3, Bytes: 9}) {
+		t.Fatalf("put batch: %+v %v", res, err)
+	}
+
+	missing, err := push.CheckBlobs(ctx, []string{digest("alpha"), digest("gamma"), digest("gamma")})
+	if err != nil || len(missing) != 1 || missing[0] != digest("gamma") {
+		t.Fatalf("check: %v %v", missing, err)
+	}
+	if _, err := push.CheckBlobs(ctx, []string{"nothex"}); !errors.Is(err, ErrInvalidRequest) {
+		t.Fatalf("expected ErrInvalidRequest, got %v", err)
+	}
+	if _, err := push.CheckBlobs(ctx, make([]string, 4)); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+
+	// Existing blobs are skipped; a corrupt blob stops the batch before it is stored.
+	batch.Reset()
+	batch.Write(frame("alpha", "alpha"))
+	batch.Write(frame("gamma", "delta"))
+	res, err = push.PutBlobs(ctx, &batch)
+	var mismatch *DigestMismatchError
+	if !errors.As(err, &mismatch) || mismatch.Index != 1 || res.Existing != 1 {
+		t.Fatalf("expected mismatch at 1, got %+v %v", res, err)
+	}
+	if ok, _ := push.HasBlob(ctx, digest("delta")); ok {
+		t.Fatal("corrupt blob was stored")
+	}
+
+	if _, err := push.PutBlobs(ctx, bytes.NewReader(frame("gamma", "gamma")[:50])); !errors.Is(err, ErrInvalidRequest) {
+		t.Fatalf("expected truncated batch error, got %v", err)
+	}
+}
+
+func TestPlanDiffAndFinalizeCounts(t *testing.T) {
+	ctx := context.Background()
+	boxes, push, _ := newTestServices(t)
+	box, err := boxes.Create(ctx, CreateBoxRequest{Name: "demo"})
+	if err != nil {
+		t.Fatalf("create box: %v", err)
+	}
+	s1, s2, s3, s4 := digest("one"), digest("two!"), digest("three"), digest("four!!")
+	for _, body := range []string{"one", "two!", "three"} {
+		if err := push.PutBlob(ctx, digest(body), strings.NewReader(body), int64(len(body))); err != nil {
+			t.Fatalf("put: %v", err)
+		}
+	}
+	first := []metastore.Entry{{Path: "keep", SHA256: s1, Size: 3}, {Path: "edit", SHA256: s1, Size: 3}, {Path: "drop", SHA256: s2, Size: 4}}
+	res, err := push.Finalize(ctx, box, FinalizeRequest{Entries: first})
+	if err != nil {
+		t.Fatalf("finalize: %v", err)
+	}
+	if res.Uploaded != 2 || res.Reused != 0 || res.UploadedBytes != 7 || len(res.Diff.Added) != 3 {
+		t.Fatalf("first push result %+v", res)
+	}
+
+	second := []metastore.Entry{{Path: "keep", SHA256: s1, Size: 3}, {Path: "edit", SHA256: s3, Size: 5}, {Path: "new", SHA256: s4, Size: 6}}
+	plan, err := push.Plan(ctx, box, "", second)
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	want := ManifestDiff{Added: []string{"new"}, Modified: []string{"edit"}, Removed: []string{"drop"}, Unchanged: 1,
+		AddedBytes: 6, ModifiedBytes: 5, RemovedBytes: 4, UnchangedBytes: 3}
+	if !reflect.DeepEqual(plan.Diff, want) || plan.WillReplace != 1 || plan.HeadCommitID != res.CommitID ||
+		len(plan.Missing) != 1 || plan.MissingBytes != 6 {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+
+	second = second[:2]
+	res2, err := push.Finalize(ctx, box, FinalizeRequest{ParentCommitID: res.CommitID, Entries: second})
+	if err != nil {
+		t.Fatalf("finalize: %v", err)
+	}
+	if res2.Uploaded != 1 || res2.Reused != 1 || len(res2.Diff.Modified) != 1 || len(res2.Diff.Removed) != 1 {
+		t.Fatalf("second push result %+v", res2)
+	}
+}
+
+func TestCompareRenamesModesAndPatch(t *testing.T) {
+	ctx := context.Background()
+	_, push, _ := newTestServices(t)
+	v1, v2, same, bin := digest("a\nb\nc\nd\n"), digest("a\nB\nc\nd\ne"), digest("x\n"), digest("\x00\x01")
+	for _, body := range []string{"a\nb\nc\nd\n", "a\nB\nc\nd\ne", "x\n", "\x00\x01"} {
+		if err := push.PutBlob(ctx, digest(body), strings.NewReader(body), int64(len(body))); err != nil {
+			t.Fatalf("put: %v", err)
+		}
+	}
+	base := metastore.Commit{ID: "base", Entries: []metastore.Entry{
+		{Path: "doc.txt", SHA256: v1, Size: 8, Mode: 0o644},
+		{Path: "old/name", SHA256: same, Size: 2, Mode: 0o644},
+		{Path: "run.sh", SHA256: same, Size: 2, Mode: 0o644},
+		{Path: "gone.bin", SHA256: bin, Size: 2},
+	}}
+	head := metastore.Commit{ID: "head", Entries: []metastore.Entry{
+		{Path: "doc.txt", SHA256: v2, Size: 9, Mode: 0o644},
+		{Path: "new/name", SHA256: same, Size: 2, Mode: 0o755},
+		{Path: "run.sh", SHA256: same, Size: 2, Mode: 0o755},
+	}}
+	cmp, err := NewCompareService(push.blobs).Compare(ctx, base, head, CompareOptions{Patch: true, Context: 1})
+	if err != nil {
+		t.Fatalf("compare: %v", err)
+	}
+	if cmp.Summary != (CompareSummary{Removed: 1, Modified: 2, Renamed: 1, SizeDelta: -1}) {
+		t.Fatalf("summary %+v", cmp.Summary)
+	}
+	byPath := map[string]FileChange{}
+	for _, f := range cmp.Files {
+		byPath[f.Path] = f
+	}
+	if f := byPath["new/name"]; f.Status != ChangeRenamed || f.OldPath != "old/name" || !f.ModeChanged || f.Patch != "" {
+		t.Fatalf("rename %+v", f)
+	}
+	if f := byPath["run.sh"]; f.Status != ChangeModified || !f.ModeChanged || f.SizeDelta != 0 {
+		t.Fatalf("mode change %+v", f)
+	}
+	if f := byPath["gone.bin"]; f.Status != ChangeRemoved || f.Patch != "" {
+		t.Fatalf("binary removal %+v", f)
+	}
+	want := "--- a/doc.txt\n+++ b/doc.txt\n@@ -1,4 +1,5 @@\n a\n-b\n+B\n c\n d\n+e\n\\ No newline at end of file\n"
+	if got := byPath["doc.txt"].Patch; got != want {
+		t.Fatalf("patch:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestDeltaFinalize(t *testing.T) {
+	ctx := context.Background()
+	boxes, push, files := newTestServices(t)
+	box, err := boxes.Create(ctx, CreateBoxRequest{Name: "demo"})
+	if err != nil {
+		t.Fatalf("create box: %v", err)
+	}
+	s1, s2 := digest("one"), digest("two!")
+	for _, body := range []string{"one", "two!"} {
+		if err := push.PutBlob(ctx, digest(body), strings.NewReader(body), int64(len(body))); err != nil {
+			t.Fatalf("put: %v", err)
+		}
+	}
+	base, err := push.Finalize(ctx, box, FinalizeRequest{Entries: []metastore.Entry{
+		{Path: "README", SHA256: s1, Size: 3}, {Path: "src/a.go", SHA256: s1, Size: 3}, {Path: "src/b.go", SHA256: s1, Size: 3},
+	}})
+	if err != nil {
+		t.Fatalf("finalize: %v", err)
+	}
+
+	res, err := push.Finalize(ctx, box, FinalizeRequest{ParentCommitID: base.CommitID,
+		Upserts: []metastore.Entry{{Path: "README", SHA256: s2, Size: 4}, {Path: "docs/x.md", SHA256: s1, Size: 3}},
+		Deletes: []string{"src"}})
+	if err != nil {
+		t.Fatalf("delta finalize: %v", err)
+	}
+	if len(res.Diff.Added) != 1 || len(res.Diff.Modified) != 1 || len(res.Diff.Removed) != 2 || res.Uploaded != 1 || res.Reused != 1 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	f, err := files.Open(ctx, res.CommitID, "README")
+	if err != nil || f.Entry.SHA256 != s2 {
+		t.Fatalf("open README: %+v %v", f.Entry, err)
+	}
+	f.Body.Close()
+	if _, err := files.Open(ctx, res.CommitID, "src/a.go"); !errors.Is(err, ErrPathNotFound) {
+		t.Fatalf("deleted path still present: %v", err)
+	}
+
+	for name, req := range map[string]FinalizeRequest{
+		"mixed":      {ParentCommitID: res.CommitID, Entries: []metastore.Entry{{Path: "a", SHA256: s1}}, Deletes: []string{"README"}},
+		"no match":   {ParentCommitID: res.CommitID, Deletes: []string{"nope"}},
+		"dot path":   {ParentCommitID: res.CommitID, Upserts: []metastore.Entry{{Path: "docs/../x", SHA256: s1}}},
+		"file dir":   {ParentCommitID: res.CommitID, Upserts: []metastore.Entry{{Path: "README/inner", SHA256: s1}}},
+		"duplicate":  {Entries: []metastore.Entry{{Path: "a", SHA256: s1}, {Path: "a", SHA256: s2}}},
+		"traversal":  {ParentCommitID: res.CommitID, Upserts: []metastore.Entry{{Path: "x", SHA256: "../secret"}}},
+		"bad digest": {ParentCommitID: res.CommitID, Upserts: []metastore.Entry{{Path: "x", SHA256: "S1"}}},
+	} {
+		if _, err := push.Finalize(ctx, box, req); !errors.Is(err, ErrInvalidManifest) {
+			t.Errorf("%s: expected ErrInvalidManifest, got %v", name, err)
+		}
+	}
+}
+
+func TestListTree(t *testing.T) {
+	ctx := context.Background()
+	boxes, push, _ := newTestServices(t)
+	box, err := boxes.Create(ctx, CreateBoxRequest{Name: "demo"})
+	if err != nil {
+		t.Fatalf("create box: %v", err)
+	}
+	s1 := digest("one")
+	if err := push.PutBlob(ctx, s1, strings.NewReader("one"), 3); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	res, err := push.Finalize(ctx, box, FinalizeRequest{Entries: []metastore.Entry{
+		{Path: "README", SHA256: s1, Size: 3},
+		{Path: "src/a.go", SHA256: s1, Size: 10},
+		{Path: "src/lib/b.go", SHA256: s1, Size: 20},
+		{Path: "src/lib/c.go", SHA256: s1, Size: 30},
+		{Path: "zz.txt", SHA256: s1, Size: 1},
+	}})
+	if err != nil {
+		t.Fatalf("finalize: %v", err)
+	}
+
+	root, err := boxes.ListTree(ctx, box, res.CommitID, ListOptions{})
+	if err != nil {
+		t.Fatalf("list root: %v", err)
+	}
+	if root.Total != 3 || root.Files != 5 || root.Size != 64 || root.Entries[0].Path != "src" ||
+		root.Entries[0].Type != metastore.TreeEntryDir || root.Entries[0].Files != 3 || root.Entries[0].Size != 60 {
+		t.Fatalf("unexpected root listing %+v", root)
+	}
+
+	src, err := boxes.ListTree(ctx, box, res.CommitID, ListOptions{Path: "src/", Sort: SortSize, Desc: true, Limit: 1})
+	if err != nil {
+		t.Fatalf("list src: %v", err)
+	}
+	if src.Total != 2 || len(src.Entries) != 1 || src.Entries[0].Path != "src/lib" || src.NextOffset != 1 {
+		t.Fatalf("unexpected src page %+v", src)
+	}
+	next, err := boxes.ListTree(ctx, box, res.CommitID, ListOptions{Path: "src", Sort: SortSize, Desc: true, Limit: 1, Offset: 1})
+	if err != nil || len(next.Entries) != 1 || next.Entries[0].Path != "src/a.go" || next.NextOffset != 0 {
+		t.Fatalf("unexpected second page %+v %v", next, err)
+	}
+
+	all, err := boxes.ListTree(ctx, box, res.CommitID, ListOptions{Path: "src", Recursive: true})
+	if err != nil {
+		t.Fatalf("list recursive: %v", err)
+	}
+	if all.Total != 4 || all.Files != 3 || all.Size != 60 || all.Entries[3].Path != "src/lib/c.go" {
+		t.Fatalf("unexpected recursive listing %+v", all)
+	}
+
+	for _, p := range []string{"nope", "README", "src/a.go"} {
+		if _, err := boxes.ListTree(ctx, box, res.CommitID, ListOptions{Path: p}); !errors.Is(err, ErrPathNotFound) {
+			t.Errorf("%s: expected ErrPathNotFound, got %v", p, err)
+		}
+	}
+	if _, err := boxes.ListTree(ctx, box, "missing", ListOptions{}); !errors.Is(err, ErrCommitNotFound) {
+		t.Errorf("expected ErrCommitNotFound, got %v", err)
+	}
+	if _, err := boxes.ListTree(ctx, box, res.CommitID, ListOptions{Path: "../etc"}); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("expected ErrInvalidRequest, got %v", err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	ctx := context.Background()
+	boxes, push, _ := newTestServices(t)
+	box, err := boxes.Create(ctx, CreateBoxRequest{Name: "demo"})
+	if err != nil {
+		t.Fatalf("create box: %v", err)
+	}
+	s1, s2, s3 := digest("one"), digest("two!"), digest("three")
+	for _, body := range []string{"one", "two!", "three"} {
+		if err := push.PutBlob(ctx, digest(body), strings.NewReader(body), int64(len(body))); err != nil {
+			t.Fatalf("put: %v", err)
+		}
+	}
+	commit := func(branch, parent string, entries ...metastore.Entry) string {
+		t.Helper()
+		res, err := push.Finalize(ctx, box, FinalizeRequest{Branch: branch, ParentCommitID: parent, Entries: entries})
+		if err != nil {
+			t.Fatalf("finalize %s: %v", branch, err)
+		}
+		return res.CommitID
+	}
+	readme := metastore.Entry{Path: "README", SHA256: s1, Size: 3}
+	root := commit("main", "", readme, metastore.Entry{Path: "a.txt", SHA256: s1, Size: 3})
+	if _, err := push.Finalize(ctx, box, FinalizeRequest{Branch: "dev", Entries: []metastore.Entry{readme}}); err != nil {
+		t.Fatalf("unrelated dev: %v", err)
+	}
+	if _, err := push.Merge(ctx, box, MergeRequest{Source: "dev"}); !errors.Is(err, ErrInvalidRequest) {
+		t.Fatalf("unrelated histories: expected ErrInvalidRequest, got %v", err)
+	}
+
+	// feature branches off main and moves ahead: fast-forward.
+	feature := commit("feature", root, readme, metastore.Entry{Path: "a.txt", SHA256: s2, Size: 4})
+	res, err := push.Merge(ctx, box, MergeRequest{Source: "feature"})
+	if err != nil || res.Status != MergeFastForward || res.CommitID != feature || len(res.Diff.Modified) != 1 {
+		t.Fatalf("fast-forward: %+v %v", res, err)
+	}
+	if res, err := push.Merge(ctx, box, MergeRequest{Source: root}); err != nil || res.Status != MergeUpToDate || res.CommitID != feature {
+		t.Fatalf("up to date: %+v %v", res, err)
+	}
+
+	// Both sides change different files: three-way merge with two parents.
+	mainHead := commit("main", feature, readme, metastore.Entry{Path: "a.txt", SHA256: s2, Size: 4}, metastore.Entry{Path: "b.txt", SHA256: s3, Size: 5})
+	featHead := commit("feature", feature, metastore.Entry{Path: "README", SHA256: s3, Size: 5}, metastore.Entry{Path: "a.txt", SHA256: s2, Size: 4})
+	res, err = push.Merge(ctx, box, MergeRequest{Source: "feature", Target: "main"})
+	if err != nil || res.Status != MergeCommitted || res.BaseCommitID != feature {
+		t.Fatalf("merge: %+v %v", res, err)
+	}
+	merged, err := boxes.Latest(ctx, box, "main")
+	if err != nil {
+		t.Fatalf("latest: %v", err)
+	}
+	if merged.ID != res.CommitID || len(merged.Parents) != 2 || merged.Parents[0] != mainHead || merged.Parents[1] != featHead || *merged.ParentID != mainHead {
+		t.Fatalf("unexpected merge commit %+v", merged)
+	}
+	want := map[string]string{"README": s3, "a.txt": s2, "b.txt": s3}
+	if len(merged.Entries) != len(want) {
+		t.Fatalf("unexpected merged entries %+v", merged.Entries)
+	}
+	for _, e := range merged.Entries {
+		if want[e.Path] != e.SHA256 {
+			t.Fatalf("unexpected merged entries %+v", merged.Entries)
+		}
+	}
+
+	// The next merge of feature finds the merge commit's second parent as base.
+	if res, err := push.Merge(ctx, box, MergeRequest{Source: "feature"}); err != nil || res.Status != MergeUpToDate || res.BaseCommitID != featHead {
+		t.Fatalf("after merge: %+v %v", res, err)
+	}
+
+	// Conflicting edits to the same file and a file/directory clash.
+	commit("main", merged.ID, metastore.Entry{Path: "README", SHA256: s1, Size: 3}, metastore.Entry{Path: "a.txt", SHA256: s2, Size: 4},
+		metastore.Entry{Path: "b.txt", SHA256: s3, Size: 5}, metastore.Entry{Path: "lib", SHA256: s1, Size: 3})
+	commit("feature", featHead, metastore.Entry{Path: "README", SHA256: s2, Size: 4}, metastore.Entry{Path: "a.txt", SHA256: s2, Size: 4},
+		metastore.Entry{Path: "lib/x.go", SHA256: s1, Size: 3})
+	_, err = push.Merge(ctx, box, MergeRequest{Source: "feature", NoFastForward: true})
+	var conflict *MergeConflictError
+	if !errors.As(err, &conflict) || !errors.Is(err, ErrMergeConflict) {
+		t.Fatalf("expected merge conflict, got %v", err)
+	}
+	if len(conflict.Conflicts) != 2 || conflict.Conflicts[0].Path != "README" || conflict.Conflicts[0].Kind != ConflictContent ||
+		conflict.Conflicts[1].Path != "lib" || conflict.Conflicts[1].Kind != ConflictFileDirectory {
+		t.Fatalf("unexpected conflicts %+v", conflict.Conflicts)
+	}
+}
+
+func TestReflogAndReset(t *testing.T) {
+	ctx := context.Background()
+	boxes, push, _ := newTestServices(t)
+	branches := NewBranchService(boxes.meta, boxes.audit)
+	box, err := boxes.Create(ctx, CreateBoxRequest{Name: "demo"})
+	if err != nil {
+		t.Fatalf("create box: %v", err)
+	}
+	s1 := digest("one")
+	if err := push.PutBlob(ctx, s1, strings.NewReader("one"), 3); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	c1, err := push.Finalize(ctx, box, FinalizeRequest{Branch: "dev", Entries: []metastore.Entry{{Path: "a", SHA256: s1, Size: 3}}})
+	if err != nil {
+		t.Fatalf("finalize: %v", err)
+	}
+	c2, err := push.Finalize(ctx, box, FinalizeRequest{Branch: "dev", Force: true, Entries: []metastore.Entry{{Path: "b", SHA256: s1, Size: 3}}})
+	if err != nil {
+		t.Fatalf("force finalize: %v", err)
+	}
+	if _, err := branches.Delete(ctx, box, "dev", nil, ""); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	log, err := branches.Reflog(ctx, box, "dev", 0, 0)
+	if err != nil {
+		t.Fatalf("reflog: %v", err)
+	}
+	if len(log) != 3 || log[0].Reason != ReasonDelete || log[0].Old != c2.CommitID || log[0].New != "" ||
+		log[1].Reason != ReasonForcePush || !log[1].Forced || log[1].Old != c1.CommitID ||
+		log[2].Reason != ReasonPush || log[2].Old != "" || log[2].Principal != "anonymous" {
+		t.Fatalf("unexpected reflog %+v", log)
+	}
+	if page, err := branches.Reflog(ctx, box, "dev", log[1].ID, 10); err != nil || len(page) != 1 || page[0].ID != log[2].ID {
+		t.Fatalf("unexpected reflog page %+v %v", page, err)
+	}
+
+	// Undo the force-push and the delete: back to where the first push left dev.
+	if _, err := branches.Reset(ctx, box, "dev", ResetRequest{EntryID: log[0].ID}); !errors.Is(err, ErrInvalidRequest) {
+		t.Fatalf("reset to a deletion: expected ErrInvalidRequest, got %v", err)
+	}
+	if _, err := branches.Reset(ctx, box, "main", ResetRequest{EntryID: log[2].ID}); !errors.Is(err, ErrReflogNotFound) {
+		t.Fatalf("reset across branches: expected ErrReflogNotFound, got %v", err)
+	}
+	lease := c2.CommitID
+	if _, err := branches.Reset(ctx, box, "dev", ResetRequest{EntryID: log[2].ID, ExpectedOld: &lease}); !errors.Is(err, ErrParentMismatch) {
+		t.Fatalf("reset with stale lease: expected ErrParentMismatch, got %v", err)
+	}
+	lease = ""
+	res, err := branches.Reset(ctx, box, "dev", ResetRequest{EntryID: log[2].ID, ExpectedOld: &lease})
+	if err != nil || res.CommitID != c1.CommitID || res.Forced {
+		t.Fatalf("reset: %+v %v", res, err)
+	}
+	if head, err := boxes.Latest(ctx, box, "dev"); err != nil || head.ID != c1.CommitID {
+		t.Fatalf("dev not restored: %s %v", head.ID, err)
+	}
+	last, err := branches.Reflog(ctx, box, "dev", 0, 1)
+	if err != nil || len(last) != 1 || last[0].Reason != fmt.Sprintf("reset to reflog entry %d", log[2].ID) || last[0].Old != "" {
+		t.Fatalf("reset not logged: %+v %v", last, err)
+	}
+}
+
+func TestConcurrentFinalizeOnFileDB(t *testing.T) {
+	ctx := context.Background()
+	meta, err := metastore.NewSQLiteMetaStore(filepath.Join(t.TempDir(), "meta.db"))
+	if err != nil {
+		t.Fatalf("meta open: %v", err)
+	}
+	defer meta.Close()
+	audit := NewAuditLog(meta, nil)
+	boxes, push := NewBoxService(meta, audit), NewPushService(blobstore.NewBlobStoreFS(t.TempDir()), meta, audit)
+	box, err := boxes.Create(ctx, CreateBoxRequest{Name: "demo"})
+	if err != nil {
+		t.Fatalf("create box: %v", err)
+	}
+	s1 := digest("one")
+	if err := push.PutBlob(ctx, s1, strings.NewReader("one"), 3); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	const n = 8
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := push.Finalize(ctx, box, FinalizeRequest{Entries: []metastore.Entry{{Path: fmt.Sprintf("f%d", i), SHA256: s1}}})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	won := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			won++
+		case !errors.Is(err, ErrParentMismatch):
+			t.Errorf("expected success or ErrParentMismatch, got %v", err)
+		}
+	}
+	if won != 1 {
+		t.Fatalf("%d finalizes from the same parent succeeded, want 1", won)
+	}
+}
@@ -0,0 +1,197 @@
+package domain
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+
+	"fgo/internal/storage/blobstore"
+	"fgo/internal/storage/metastore"
+)
+
+// FileService resolves paths inside commits to blob content.
+type FileService struct {
+	blobs blobstore.BlobStore
+	meta  metastore.MetadataStore
+}
+
+func NewFileService(blobs blobstore.BlobStore, meta metastore.MetadataStore) *FileService {
+	return &FileService{blobs: blobs, meta: meta}
+}
+
+// File is an open file from a commit. Callers must close Body.
+type File struct {
+	Entry metastore.Entry
+	Body  io.ReadCloser
+	Size  int64
+}
+
+// Open finds path in the commit and opens its blob.
+func (s *FileService) Open(ctx context.Context, commitID, path string) (File, error) {
+	commit, err := s.meta.GetCommitByID(ctx, commitID)
+	if err != nil {
+		return File{}, notFound(err, ErrCommitNotFound)
+	}
+	return s.open(ctx, commit, path)
+}
+
+// OpenIn is Open for a tag, branch or commit ID of box (see BoxService.Resolve).
+func (s *FileService) OpenIn(ctx context.Context, box metastore.Box, rev, path string) (File, error) {
+	commit, err := resolve(ctx, s.meta, box, rev)
+	if err != nil {
+		return File{}, err
+	}
+	return s.open(ctx, commit, path)
+}
+
+func (s *FileService) open(ctx context.Context, commit metastore.Commit, path string) (File, error) {
+	entry, ok := findEntry(commit.Entries, path)
+	if !ok {
+		return File{}, ErrPathNotFound
+	}
+	rc, size, err := s.blobs.Open(ctx, entry.SHA256)
+	if err != nil {
+		if errors.Is(err, blobstore.ErrNotFound) {
+			return File{}, ErrBlobNotFound
+		}
+		return File{}, err
+	}
+	return File{Entry: entry, Body: rc, Size: size}, nil
+}
+
+func findEntry(entries []metastore.Entry, path string) (metastore.Entry, bool) {
+	for _, e := range entries {
+		if e.Path == path {
+			return e, true
+		}
+	}
+	return metastore.Entry{}, false
+}
+
+// Archive formats.
+const (
+	ArchiveZip   = "zip"
+	ArchiveTarGz = "tar.gz"
+)
+
+// Archive is a commit, or one directory of it, ready to be written as a
+// zip or gzipped tarball. Files are stored under Name + "/".
+type Archive struct {
+	CommitID string
+	// Name is "<box>-<rev>" with slashes replaced, used for the top-level
+	// directory and the download file name.
+	Name   string
+	Format string
+	// Dir is the directory archived, without surrounding slashes; "" for all.
+	Dir     string
+	entries []metastore.Entry
+	modTime time.Time
+	blobs   blobstore.BlobStore
+}
+
+// Filename is the archive's download name.
+func (a *Archive) Filename() string { return a.Name + "." + a.Format }
+
+// ETag identifies the archive's content: the same commit, name, directory
+// and format always produce the same archive.
+func (a *Archive) ETag() string {
+	sum := sha256.Sum256([]byte(a.Name + "\x00" + a.Dir + "\x00" + a.Format))
+	return "W/\"archive:" + a.CommitID + ":" + hex.EncodeToString(sum[:8]) + "\""
+}
+
+// Archive prepares the files of rev in box below dir ("" for all) for
+// download in format. It fails with ErrPathNotFound if dir holds no files.
+func (s *FileService) Archive(ctx context.Context, box metastore.Box, rev, dir, format string) (*Archive, error) {
+	if format != ArchiveZip && format != ArchiveTarGz {
+		return nil, fmt.Errorf("%w: format must be %q or %q", ErrInvalidRequest, ArchiveZip, ArchiveTarGz)
+	}
+	commit, err := resolve(ctx, s.meta, box, rev)
+	if err != nil {
+		return nil, err
+	}
+	a := &Archive{
+		CommitID: commit.ID,
+		Name:     box.Name + "-" + strings.ReplaceAll(rev, "/", "-"),
+		Format:   format,
+		blobs:    s.blobs,
+	}
+	a.modTime, _ = time.Parse(time.RFC3339Nano, commit.Timestamp)
+	a.Dir = strings.Trim(dir, "/")
+	for _, e := range commit.Entries {
+		if a.Dir == "" || strings.HasPrefix(e.Path, a.Dir+"/") {
+			a.entries = append(a.entries, e)
+		}
+	}
+	if len(a.entries) == 0 {
+		return nil, ErrPathNotFound
+	}
+	return a, nil
+}
+
+// Write streams the archive to w.
+func (a *Archive) Write(ctx context.Context, w io.Writer) error {
+	if a.Format == ArchiveZip {
+		zw := zip.NewWriter(w)
+		err := a.each(ctx, func(name string, mode fs.FileMode, size int64, r io.Reader) error {
+			h := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: a.modTime}
+			h.SetMode(mode)
+			fw, err := zw.CreateHeader(h)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(fw, r)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return zw.Close()
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	err := a.each(ctx, func(name string, mode fs.FileMode, size int64, r io.Reader) error {
+		h := &tar.Header{Name: name, Mode: int64(mode), Size: size, ModTime: a.modTime, Typeflag: tar.TypeReg, Format: tar.FormatPAX}
+		if err := tw.WriteHeader(h); err != nil {
+			return err
+		}
+		_, err := io.Copy(tw, r)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// each opens every file's blob in turn and passes it to fn.
+func (a *Archive) each(ctx context.Context, fn func(name string, mode fs.FileMode, size int64, r io.Reader) error) error {
+	for _, e := range a.entries {
+		rc, size, err := a.blobs.Open(ctx, e.SHA256)
+		if err != nil {
+			return fmt.Errorf("open %s: %w", e.Path, err)
+		}
+		mode := fs.FileMode(e.Mode) & fs.ModePerm
+		if mode == 0 {
+			mode = 0o644
+		}
+		err = fn(a.Name+"/"+e.Path, mode, size, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+
+	"fgo/internal/storage/metastore"
+)
+
+// Merge outcomes.
+const (
+	MergeUpToDate    = "up_to_date"
+	MergeFastForward = "fast_forward"
+	MergeCommitted   = "merged"
+)
+
+// Conflict kinds in a MergeConflict.
+const (
+	ConflictContent       = "content"        // both sides changed the file differently
+	ConflictAddAdd        = "add_add"        // both sides added the path with different content
+	ConflictModifyDelete  = "modify_delete"  // one side changed the file, the other removed it
+	ConflictFileDirectory = "file_directory" // one side has a file where the other has a directory
+)
+
+// MergeConflict is a path both sides changed incompatibly. The SHA256 fields
+// are empty where that side has no file.
+type MergeConflict struct {
+	Path         string `json:"path"`
+	Kind         string `json:"kind"`
+	BaseSHA256   string `json:"base_sha256,omitempty"`
+	TargetSHA256 string `json:"target_sha256,omitempty"`
+	SourceSHA256 string `json:"source_sha256,omitempty"`
+}
+
+// MergeRequest merges Source (a branch or commit ID) into branch Target,
+// the box's default branch if empty. NoFastForward records a merge commit
+// even when Target could simply move to Source.
+type MergeRequest struct {
+	Source        string `json:"source"`
+	Target        string `json:"target"`
+	Message       string `json:"message"`
+	NoFastForward bool   `json:"no_ff"`
+	Author        string `json:"-"`
+}
+
+// MergeResult describes a merge. CommitID is the new head of Target; Diff
+// compares it with the previous head.
+type MergeResult struct {
+	Status         string       `json:"status"`
+	Target         string       `json:"target"`
+	CommitID       string       `json:"commit_id"`
+	BaseCommitID   string       `json:"base_commit_id"`
+	SourceCommitID string       `json:"source_commit_id"`
+	Diff           ManifestDiff `json:"diff"`
+}
+
+// Merge brings Source into Target. If Target's head is an ancestor of Source
+// the branch fast-forwards; otherwise the manifests are merged against their
+// common ancestor and a commit with both heads as parents is recorded. Paths
+// both sides changed differently fail the merge with a MergeConflictError.
+// A concurrent push to Target fails it with ErrParentMismatch.
+func (s *PushService) Merge(ctx context.Context, box metastore.Box, req MergeRequest) (MergeResult, error) {
+	if req.Source == "" {
+		return MergeResult{}, fmt.Errorf("%w: source required", ErrInvalidRequest)
+	}
+	if req.Target == "" {
+		req.Target = box.DefaultBranch
+	}
+	source, err := resolve(ctx, s.meta, box, req.Source)
+	if err != nil {
+		return MergeResult{}, err
+	}
+	head, err := s.meta.LatestCommit(ctx, box.ID, req.Target)
+	if err != nil {
+		return MergeResult{}, notFound(err, ErrCommitNotFound)
+	}
+	baseID, err := s.meta.MergeBase(ctx, head.ID, source.ID)
+	if errors.Is(err, metastore.ErrNotFound) {
+		return MergeResult{}, fmt.Errorf("%w: %s and %s share no history", ErrInvalidRequest, req.Target, req.Source)
+	}
+	if err != nil {
+		return MergeResult{}, err
+	}
+	res := MergeResult{Target: req.Target, CommitID: head.ID, BaseCommitID: baseID, SourceCommitID: source.ID}
+
+	switch {
+	case baseID == source.ID:
+		res.Status = MergeUpToDate
+		return res, nil
+	case baseID == head.ID && !req.NoFastForward:
+		if err := s.moveRef(ctx, box, req.Target, head.ID, source); err != nil {
+			return MergeResult{}, err
+		}
+		res.Status, res.CommitID = MergeFastForward, source.ID
+		res.Diff = diffManifests(head.Entries, source.Entries)
+		s.audit.Record(ctx, ActionMerge, "ref:"+box.Name+"/"+req.Target, box.ID, head.ID,
+			map[string]any{"status": res.Status, "source": req.Source, "commit_id": source.ID})
+		return res, nil
+	}
+
+	base := head
+	if baseID != head.ID {
+		if base, err = s.meta.GetCommitByID(ctx, baseID); err != nil {
+			return MergeResult{}, err
+		}
+	}
+	entries, conflicts := mergeManifests(base.Entries, head.Entries, source.Entries)
+	if len(conflicts) > 0 {
+		return MergeResult{}, &MergeConflictError{BaseCommitID: baseID, Conflicts: conflicts}
+	}
+	if err := s.checkManifest(entries); err != nil {
+		return MergeResult{}, err
+	}
+	if req.Message == "" {
+		req.Message = fmt.Sprintf("Merge %s into %s", req.Source, req.Target)
+	}
+	commit := metastore.Commit{
+		BoxID:   box.ID,
+		Branch:  req.Target,
+		Parents: []string{head.ID, source.ID},
+		Message: req.Message,
+		Author:  req.Author,
+		Entries: entries,
+	}
+	u := metastore.RefUpdate{BoxID: box.ID, Branch: req.Target, Old: head.ID, Reason: ReasonMerge}
+	if err := authorizeRef(ctx, s.meta, &u, &commit); err != nil {
+		return MergeResult{}, err
+	}
+	saved, err := s.meta.SavePush(ctx, metastore.Push{Commit: commit, Ref: u})
+	if err != nil {
+		return MergeResult{}, refError(err, u)
+	}
+	commit, u.New = saved.Commit, saved.Commit.ID
+	recordRef(ctx, s.audit, box, u, head.ID)
+	res.Status, res.CommitID = MergeCommitted, commit.ID
+	res.Diff = diffManifests(head.Entries, entries)
+	s.audit.Record(ctx, ActionMerge, "commit:"+commit.ID, box.ID, nil,
+		map[string]any{"status": res.Status, "source": req.Source, "commit_id": commit.ID, "parents": commit.Parents,
+			"added": len(res.Diff.Added), "modified": len(res.Diff.Modified), "removed": len(res.Diff.Removed)})
+	return res, nil
+}
+
+// moveRef fast-forwards branch from commit from to commit to for a merge.
+func (s *PushService) moveRef(ctx context.Context, box metastore.Box, branch, from string, to metastore.Commit) error {
+	u := metastore.RefUpdate{BoxID: box.ID, Branch: branch, Old: from, New: to.ID, Reason: ReasonMerge}
+	if err := authorizeRef(ctx, s.meta, &u, &to); err != nil {
+		return err
+	}
+	if _, err := updateRef(ctx, s.meta, u); err != nil {
+		return err
+	}
+	recordRef(ctx, s.audit, box, u, from)
+	return nil
+}
+
+// mergeManifests three-way merges target and source against base. A path
+// keeps whichever side changed it; when both changed it differently it is a
+// conflict. The result is sorted by path.
+func mergeManifests(base, target, source []metastore.Entry) ([]metastore.Entry, []MergeConflict) {
+	b, t, src := byPath(base), byPath(target), byPath(source)
+	paths := make(map[string]struct{}, len(t)+len(src))
+	for p := range t {
+		paths[p] = struct{}{}
+	}
+	for p := range src {
+		paths[p] = struct{}{}
+	}
+	for p := range b {
+		paths[p] = struct{}{}
+	}
+
+	var out []metastore.Entry
+	var conflicts []MergeConflict
+	for p := range paths {
+		be, inBase := b[p]
+		te, inTarget := t[p]
+		se, inSource := src[p]
+		var keep metastore.Entry
+		var ok bool
+		switch {
+		case sameEntry(te, inTarget, se, inSource):
+			keep, ok = te, inTarget
+		case sameEntry(te, inTarget, be, inBase):
+			keep, ok = se, inSource
+		case sameEntry(se, inSource, be, inBase):
+			keep, ok = te, inTarget
+		default:
+			kind := ConflictContent
+			switch {
+			case !inBase:
+				kind = ConflictAddAdd
+			case !inTarget || !inSource:
+				kind = ConflictModifyDelete
+			}
+			conflicts = append(conflicts, MergeConflict{Path: p, Kind: kind,
+				BaseSHA256: be.SHA256, TargetSHA256: te.SHA256, SourceSHA256: se.SHA256})
+			continue
+		}
+		if ok {
+			out = append(out, keep)
+		}
+	}
+
+	// Each side is a valid tree, but one may have added a file where the
+	// other added a directory.
+	files := byPath(out)
+	for _, e := range out {
+		for dir := path.Dir(e.Path); dir != "."; dir = path.Dir(dir) {
+			if f, ok := files[dir]; ok {
+				conflicts = append(conflicts, MergeConflict{Path: dir, Kind: ConflictFileDirectory,
+					TargetSHA256: t[dir].SHA256, SourceSHA256: src[dir].SHA256})
+				delete(files, f.Path)
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Path < conflicts[j].Path })
+	return out, conflicts
+}
+
+func byPath(entries []metastore.Entry) map[string]metastore.Entry {
+	m := make(map[string]metastore.Entry, len(entries))
+	for _, e := range entries {
+		m[e.Path] = e
+	}
+	return m
+}
+
+// sameEntry reports whether two sides agree on a path: both absent, or the
+// same content and mode.
+func sameEntry(a metastore.Entry, okA bool, b metastore.Entry, okB bool) bool {
+	if okA != okB {
+		return false
+	}
+	return !okA || (a.SHA256 == b.SHA256 && a.Mode == b.Mode)
+}
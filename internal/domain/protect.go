@@ -0,0 +1,158 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"fgo/internal/storage/metastore"
+)
+
+// SigningPayload is what a pusher signs for a commit: the parent, the
+// message and the full manifest the commit will have, one line each:
+//
+//	parent <parent commit ID, empty for a root commit>
+//	message <Go-quoted message>
+//	<mode, octal> <sha256> <size> <Go-quoted path>   (sorted by path)
+//
+// A delta finalize signs the manifest after the delta is applied.
+func SigningPayload(parent, message string, entries []metastore.Entry) []byte {
+	sorted := slices.Clone(entries)
+	slices.SortFunc(sorted, func(a, b metastore.Entry) int { return strings.Compare(a.Path, b.Path) })
+	var b strings.Builder
+	fmt.Fprintf(&b, "parent %s\nmessage %q\n", parent, message)
+	for _, e := range sorted {
+		fmt.Fprintf(&b, "%06o %s %d %q\n", e.Mode, e.SHA256, e.Size, e.Path)
+	}
+	return []byte(b.String())
+}
+
+// Rules returns the branch protection rules of box by pattern.
+func (s *BranchService) Rules(ctx context.Context, box metastore.Box) ([]metastore.BranchRule, error) {
+	return s.meta.ListBranchRules(ctx, box.ID)
+}
+
+// PutRule creates or replaces the protection rule for r.Pattern. Callers
+// restrict this to admins.
+func (s *BranchService) PutRule(ctx context.Context, box metastore.Box, r metastore.BranchRule) (metastore.BranchRule, error) {
+	if _, err := path.Match(r.Pattern, ""); err != nil || r.Pattern == "" || len(r.Pattern) > 255 {
+		return metastore.BranchRule{}, fmt.Errorf("%w: invalid branch pattern %q", ErrInvalidRequest, r.Pattern)
+	}
+	for _, p := range r.Principals {
+		if p == "" {
+			return metastore.BranchRule{}, fmt.Errorf("%w: empty principal", ErrInvalidRequest)
+		}
+	}
+	prev, err := s.rule(ctx, box, r.Pattern)
+	if err != nil {
+		return metastore.BranchRule{}, err
+	}
+	if r, err = s.meta.PutBranchRule(ctx, box.ID, r); err != nil {
+		return metastore.BranchRule{}, err
+	}
+	var before any
+	if prev != nil {
+		before = prev
+	}
+	s.audit.Record(ctx, ActionRuleSet, "branch_rule:"+box.Name+"/"+r.Pattern, box.ID, before, r)
+	return r, nil
+}
+
+// DeleteRule removes the protection rule for pattern. Callers restrict this
+// to admins.
+func (s *BranchService) DeleteRule(ctx context.Context, box metastore.Box, pattern string) error {
+	prev, err := s.rule(ctx, box, pattern)
+	if err != nil {
+		return err
+	}
+	if err := s.meta.DeleteBranchRule(ctx, box.ID, pattern); err != nil {
+		return notFound(err, ErrRuleNotFound)
+	}
+	s.audit.Record(ctx, ActionRuleDelete, "branch_rule:"+box.Name+"/"+pattern, box.ID, prev, nil)
+	return nil
+}
+
+// rule returns the rule for pattern, or nil if there is none.
+func (s *BranchService) rule(ctx context.Context, box metastore.Box, pattern string) (*metastore.BranchRule, error) {
+	rules, err := s.meta.ListBranchRules(ctx, box.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rules {
+		if r.Pattern == pattern {
+			return &r, nil
+		}
+	}
+	return nil, nil
+}
+
+// checkRules refuses u if a rule matching its branch forbids moving it to
+// next, which need not be stored yet; a nil next deletes the branch. Every
+// rule that matches applies.
+// Without AllowForce a branch may only move forward: u.Old must be an
+// ancestor of next, which holds when the update lands because UpdateRef
+// re-checks u.Old atomically.
+func checkRules(ctx context.Context, meta metastore.MetadataStore, u metastore.RefUpdate, next *metastore.Commit) error {
+	rules, err := meta.ListBranchRules(ctx, u.BoxID)
+	if err != nil {
+		return err
+	}
+	for _, r := range rules {
+		if ok, _ := path.Match(r.Pattern, u.Branch); !ok {
+			continue
+		}
+		deny := func(reason string) error {
+			return &ProtectionError{Branch: u.Branch, Pattern: r.Pattern, Reason: reason}
+		}
+		if len(r.Principals) > 0 && !slices.Contains(r.Principals, u.Principal) {
+			return deny(fmt.Sprintf("%s may not update it", u.Principal))
+		}
+		if next == nil {
+			if !r.AllowDelete {
+				return deny("deletion is not allowed")
+			}
+			continue
+		}
+		if !r.AllowForce {
+			if u.Force {
+				return deny("force updates are not allowed")
+			}
+			if u.Old != "" {
+				ok, err := descends(ctx, meta, *next, u.Old)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return deny("not a fast-forward")
+				}
+			}
+		}
+		if r.RequireSigned && next.Signer == "" {
+			if next.ID == "" {
+				return deny("new commits must be signed")
+			}
+			return deny(fmt.Sprintf("commit %s is not signed", next.ID))
+		}
+	}
+	return nil
+}
+
+// descends reports whether commit old is c or one of its ancestors.
+func descends(ctx context.Context, meta metastore.MetadataStore, c metastore.Commit, old string) (bool, error) {
+	if c.ID == old {
+		return true, nil
+	}
+	for _, p := range c.Parents {
+		base, err := meta.MergeBase(ctx, old, p)
+		if err != nil && !errors.Is(err, metastore.ErrNotFound) {
+			return false, err
+		}
+		if base == old {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,435 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"fgo/internal/integrity"
+	"fgo/internal/observe"
+	"fgo/internal/signing"
+	"fgo/internal/storage/blobstore"
+	"fgo/internal/storage/metastore"
+)
+
+// PushService implements the plan → upload → finalize push protocol.
+type PushService struct {
+	blobs    blobstore.BlobStore
+	meta     metastore.MetadataStore
+	audit    *AuditLog
+	limits   Limits
+	verifier signing.Verifier
+
+	finalizeTotal   *observe.CounterVec
+	finalizeSeconds *observe.HistogramVec
+}
+
+func NewPushService(blobs blobstore.BlobStore, meta metastore.MetadataStore, audit *AuditLog) *PushService {
+	return &PushService{blobs: blobs, meta: meta, audit: audit}
+}
+
+// SetLimits bounds blob sizes, manifests and namespace storage for later calls.
+func (s *PushService) SetLimits(l Limits) {
+	s.limits = l
+}
+
+// SetVerifier sets the keys commit signatures are checked against. Without
+// one, signed pushes are refused.
+func (s *PushService) SetVerifier(v signing.Verifier) {
+	s.verifier = v
+}
+
+// Instrument records push_finalize_total{status} and
+// push_finalize_duration_seconds{status} in reg.
+func (s *PushService) Instrument(reg *observe.Registry) {
+	s.finalizeTotal = reg.Counter("push_finalize_total", "Push finalize attempts by status.", "status")
+	s.finalizeSeconds = reg.Histogram("push_finalize_duration_seconds", "Push finalize latency in seconds.", observe.DefBuckets, "status")
+}
+
+// Plan is the result of planning a push: the blobs still to upload and how
+// the manifest differs from the branch head.
+type Plan struct {
+	Branch       string       `json:"branch"`
+	HeadCommitID string       `json:"head_commit_id,omitempty"`
+	Missing      []string     `json:"missing"`
+	MissingBytes int64        `json:"missing_bytes"`
+	Total        int          `json:"total"`
+	WillReplace  int          `json:"will_replace"`
+	Diff         ManifestDiff `json:"diff"`
+}
+
+// Plan reports which blobs referenced by entries still need to be uploaded
+// and diffs entries against the head of branch (the box's default branch if
+// empty). WillReplace counts existing paths whose content or mode changes.
+func (s *PushService) Plan(ctx context.Context, box metastore.Box, branch string, entries []metastore.Entry) (Plan, error) {
+	if err := s.checkManifest(entries); err != nil {
+		return Plan{}, err
+	}
+	if branch == "" {
+		branch = box.DefaultBranch
+	}
+	head, err := s.meta.LatestCommit(ctx, box.ID, branch)
+	if err != nil && !errors.Is(err, metastore.ErrNotFound) {
+		return Plan{}, err
+	}
+	shas := make([]string, len(entries))
+	sizes := make(map[string]int64, len(entries))
+	for i, e := range entries {
+		shas[i] = e.SHA256
+		sizes[e.SHA256] = e.Size
+	}
+	missing, err := s.missing(ctx, shas)
+	if err != nil {
+		return Plan{}, err
+	}
+	plan := Plan{
+		Branch:       branch,
+		HeadCommitID: head.ID,
+		Missing:      missing,
+		Total:        len(entries),
+		Diff:         diffManifests(head.Entries, entries),
+	}
+	for _, sha := range missing {
+		plan.MissingBytes += sizes[sha]
+	}
+	plan.WillReplace = len(plan.Diff.Modified)
+	return plan, nil
+}
+
+// HasBlob reports whether a blob has been uploaded.
+func (s *PushService) HasBlob(ctx context.Context, sha string) (bool, error) {
+	if !validSHA256(sha) {
+		return false, fmt.Errorf("%w: sha256 must be 64 lowercase hex characters", ErrInvalidRequest)
+	}
+	return s.blobs.Has(ctx, sha)
+}
+
+// PutBlob stores size bytes from r as the blob sha, refusing content that
+// does not hash to sha.
+func (s *PushService) PutBlob(ctx context.Context, sha string, r io.Reader, size int64) error {
+	if !validSHA256(sha) {
+		return fmt.Errorf("%w: sha256 must be 64 lowercase hex characters", ErrInvalidRequest)
+	}
+	if max := s.limits.MaxBlobBytes; max > 0 && size > max {
+		return &LimitError{Limit: "blob size", Max: max, Got: size}
+	}
+	if size == 0 && sha != integrity.EmptySHA256 {
+		return ErrDigestMismatch
+	}
+	err := s.blobs.Put(ctx, sha, integrity.NewReader(r, size, sha), size)
+	if errors.Is(err, integrity.ErrDigestMismatch) {
+		return ErrDigestMismatch
+	}
+	return err
+}
+
+// FinalizeRequest describes the commit a push creates, either as the full
+// manifest in Entries or as a delta against ParentCommitID: Deletes removes
+// files (or whole directories) and Upserts adds or replaces files. Force
+// moves the branch even if its head is not ParentCommitID. Signature, if
+// set, is a detached signature of SigningPayload for the resulting commit.
+type FinalizeRequest struct {
+	Branch         string            `json:"branch"`
+	ParentCommitID string            `json:"parent_commit_id"`
+	Message        string            `json:"message"`
+	Entries        []metastore.Entry `json:"entries"`
+	Upserts        []metastore.Entry `json:"upserts"`
+	Deletes        []string          `json:"deletes"`
+	Force          bool              `json:"force"`
+	Signature      string            `json:"signature"`
+	Author         string            `json:"-"`
+}
+
+// delta reports whether r describes changes rather than a full manifest.
+func (r FinalizeRequest) delta() bool {
+	return len(r.Upserts) > 0 || len(r.Deletes) > 0
+}
+
+// FinalizeResult is returned after a successful finalize. Uploaded counts
+// the distinct blobs this push added to the namespace's storage and Reused
+// those it already held; Diff compares the commit with its parent.
+type FinalizeResult struct {
+	CommitID      string       `json:"commit_id"`
+	Uploaded      int          `json:"uploaded"`
+	UploadedBytes int64        `json:"uploaded_bytes"`
+	Reused        int          `json:"reused"`
+	Diff          ManifestDiff `json:"diff"`
+}
+
+// Finalize verifies every blob is present, writes the commit and moves the
+// branch head, failing with ErrParentMismatch if the branch moved unless
+// req.Force is set. A branch that does not exist yet starts at the parent.
+func (s *PushService) Finalize(ctx context.Context, box metastore.Box, req FinalizeRequest) (res FinalizeResult, err error) {
+	start := time.Now()
+	defer func() {
+		status := finalizeStatus(err)
+		s.finalizeTotal.Inc(status)
+		s.finalizeSeconds.Observe(time.Since(start).Seconds(), status)
+	}()
+	if req.Branch == "" {
+		req.Branch = box.DefaultBranch
+	}
+	if req.delta() && len(req.Entries) > 0 {
+		return FinalizeResult{}, fmt.Errorf("%w: entries cannot be combined with upserts or deletes", ErrInvalidManifest)
+	}
+	var base metastore.Commit
+	if req.ParentCommitID != "" {
+		base, err = s.meta.GetCommitByID(ctx, req.ParentCommitID)
+		if errors.Is(err, metastore.ErrNotFound) || (err == nil && base.BoxID != box.ID) {
+			return FinalizeResult{}, &ParentMismatchError{Branch: req.Branch, ParentCommitID: req.ParentCommitID}
+		}
+		if err != nil {
+			return FinalizeResult{}, err
+		}
+	}
+	// Only blobs the request names need checking and charging; a delta
+	// inherits the rest from its parent.
+	entries, added := req.Entries, req.Entries
+	if req.delta() {
+		if entries, err = applyDelta(base.Entries, req.Upserts, req.Deletes); err != nil {
+			return FinalizeResult{}, err
+		}
+		added = req.Upserts
+	}
+	if err := s.checkManifest(entries); err != nil {
+		return FinalizeResult{}, err
+	}
+	var signer string
+	if req.Signature != "" {
+		if s.verifier == nil {
+			return FinalizeResult{}, fmt.Errorf("%w: no signing keys are configured", ErrBadSignature)
+		}
+		if signer, err = s.verifier.Verify(SigningPayload(req.ParentCommitID, req.Message, entries), req.Signature); err != nil {
+			return FinalizeResult{}, err
+		}
+	}
+	commit := metastore.Commit{BoxID: box.ID, Branch: req.Branch, Message: req.Message, Author: req.Author,
+		Signature: req.Signature, Signer: signer, Entries: entries}
+	if req.ParentCommitID != "" {
+		commit.Parents = []string{req.ParentCommitID}
+	}
+	u := metastore.RefUpdate{BoxID: box.ID, Branch: req.Branch, Old: req.ParentCommitID, Force: req.Force, Reason: ReasonPush}
+	if req.Force {
+		u.Reason = ReasonForcePush
+	} else {
+		// Check the lease up front so a stale push is refused before
+		// anything is stored; SavePush re-checks it atomically.
+		cur, err := s.meta.GetRef(ctx, box.ID, req.Branch)
+		switch {
+		case errors.Is(err, metastore.ErrNotFound):
+			u.Old = ""
+		case err != nil:
+			return FinalizeResult{}, err
+		case cur != req.ParentCommitID:
+			return FinalizeResult{}, &ParentMismatchError{Branch: req.Branch, ParentCommitID: req.ParentCommitID}
+		}
+	}
+	// Branch rules are checked before anything is charged or stored.
+	if err := authorizeRef(ctx, s.meta, &u, &commit); err != nil {
+		return FinalizeResult{}, err
+	}
+	if err := s.checkPresent(ctx, added); err != nil {
+		return FinalizeResult{}, err
+	}
+	charged, err := s.uncharged(ctx, box.NamespaceID, added)
+	if err != nil {
+		return FinalizeResult{}, err
+	}
+	var quota int64
+	if s.limits.Quota != nil {
+		quota = s.limits.Quota(box.NamespaceID)
+	}
+	saved, err := s.meta.SavePush(ctx, metastore.Push{Commit: commit, Ref: u, Namespace: box.NamespaceID, Charge: charged.fresh, Quota: quota})
+	if errors.Is(err, metastore.ErrQuotaExceeded) {
+		return FinalizeResult{}, &QuotaError{Namespace: box.NamespaceID, Quota: quota, Used: saved.Used, Needed: saved.Added}
+	}
+	if err != nil {
+		return FinalizeResult{}, refError(err, u)
+	}
+	commit, old := saved.Commit, saved.Old
+	u.New = commit.ID
+	res = FinalizeResult{
+		CommitID:      commit.ID,
+		Uploaded:      len(charged.fresh),
+		UploadedBytes: saved.Added,
+		Reused:        charged.unique - len(charged.fresh),
+		Diff:          diffManifests(base.Entries, entries),
+	}
+	s.audit.Record(ctx, ActionPushFinalize, "commit:"+commit.ID, box.ID, nil,
+		map[string]any{"commit_id": commit.ID, "branch": req.Branch, "entries": len(entries), "message": req.Message,
+			"added": len(res.Diff.Added), "modified": len(res.Diff.Modified), "removed": len(res.Diff.Removed)})
+	recordRef(ctx, s.audit, box, u, old)
+	return res, nil
+}
+
+// finalizeStatus is the push_finalize_total status label for err.
+func finalizeStatus(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, ErrParentMismatch):
+		return "parent_mismatch"
+	case errors.Is(err, ErrMissingBlob):
+		return "missing_blob"
+	case errors.Is(err, ErrBranchProtected):
+		return "branch_protected"
+	}
+	return "error"
+}
+
+// checkManifest enforces the entry count and path length limits and that
+// digests are well formed and paths are clean, unique and never both a file
+// and a directory.
+func (s *PushService) checkManifest(entries []metastore.Entry) error {
+	if max := s.limits.MaxEntries; max > 0 && len(entries) > max {
+		return &LimitError{Limit: "manifest entries", Max: int64(max), Got: int64(len(entries))}
+	}
+	files := make(map[string]struct{}, len(entries))
+	for _, e := range entries {
+		if max := s.limits.MaxPathLength; max > 0 && len(e.Path) > max {
+			return fmt.Errorf("%w: path %.64q... is %d bytes, limit is %d", ErrInvalidManifest, e.Path, len(e.Path), max)
+		}
+		if err := validPath(e.Path); err != nil {
+			return err
+		}
+		if !validSHA256(e.SHA256) {
+			return fmt.Errorf("%w: %q has invalid sha256 %q", ErrInvalidManifest, e.Path, e.SHA256)
+		}
+		if _, dup := files[e.Path]; dup {
+			return fmt.Errorf("%w: duplicate path %q", ErrInvalidManifest, e.Path)
+		}
+		files[e.Path] = struct{}{}
+	}
+	for _, e := range entries {
+		for dir := path.Dir(e.Path); dir != "."; dir = path.Dir(dir) {
+			if _, ok := files[dir]; ok {
+				return fmt.Errorf("%w: %q is a file but %q is inside it", ErrInvalidManifest, dir, e.Path)
+			}
+		}
+	}
+	return nil
+}
+
+// validPath accepts clean relative slash-separated paths.
+func validPath(p string) error {
+	switch {
+	case p == "":
+		return fmt.Errorf("%w: entry with empty path", ErrInvalidManifest)
+	case strings.ContainsRune(p, 0):
+		return fmt.Errorf("%w: path %q contains NUL", ErrInvalidManifest, p)
+	case strings.HasPrefix(p, "/"), path.Clean(p) != p, p == "..", strings.HasPrefix(p, "../"):
+		return fmt.Errorf("%w: path %q must be relative and clean (no empty, . or .. segments)", ErrInvalidManifest, p)
+	}
+	return nil
+}
+
+// applyDelta returns base with deletes removed and upserts added or replaced,
+// sorted by path. A delete names a file or a directory; one that matches
+// nothing is an error.
+func applyDelta(base, upserts []metastore.Entry, deletes []string) ([]metastore.Entry, error) {
+	byPath := make(map[string]metastore.Entry, len(base)+len(upserts))
+	for _, e := range base {
+		byPath[e.Path] = e
+	}
+	for _, d := range deletes {
+		d = strings.TrimSuffix(d, "/")
+		if err := validPath(d); err != nil {
+			return nil, err
+		}
+		matched := false
+		if _, ok := byPath[d]; ok {
+			delete(byPath, d)
+			matched = true
+		}
+		for p := range byPath {
+			if strings.HasPrefix(p, d+"/") {
+				delete(byPath, p)
+				matched = true
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("%w: delete %q matches no file in the parent commit", ErrInvalidManifest, d)
+		}
+	}
+	seen := make(map[string]struct{}, len(upserts))
+	for _, e := range upserts {
+		if _, dup := seen[e.Path]; dup {
+			return nil, fmt.Errorf("%w: duplicate upsert %q", ErrInvalidManifest, e.Path)
+		}
+		seen[e.Path] = struct{}{}
+		byPath[e.Path] = e
+	}
+	out := make([]metastore.Entry, 0, len(byPath))
+	for _, e := range byPath {
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out, nil
+}
+
+// checkPresent fails with a MissingBlobError for the first entry whose blob
+// has not been uploaded.
+func (s *PushService) checkPresent(ctx context.Context, entries []metastore.Entry) error {
+	shas := make([]string, len(entries))
+	for i, e := range entries {
+		shas[i] = e.SHA256
+	}
+	missing, err := s.missing(ctx, shas)
+	if err != nil || len(missing) == 0 {
+		return err
+	}
+	for _, e := range entries {
+		if e.SHA256 == missing[0] {
+			return &MissingBlobError{Path: e.Path, SHA256: e.SHA256}
+		}
+	}
+	return nil
+}
+
+// chargeResult describes the blobs a push referenced.
+type chargeResult struct {
+	unique int              // distinct blobs in the manifest
+	fresh  map[string]int64 // stored sizes of blobs new to the namespace
+}
+
+// uncharged finds the blobs of entries that ns does not hold yet and their
+// stored sizes, rather than the sizes the client declared. Finalize charges
+// them together with the commit.
+func (s *PushService) uncharged(ctx context.Context, ns string, entries []metastore.Entry) (chargeResult, error) {
+	seen := map[string]struct{}{}
+	shas := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if _, ok := seen[e.SHA256]; !ok {
+			seen[e.SHA256] = struct{}{}
+			shas = append(shas, e.SHA256)
+		}
+	}
+	res := chargeResult{unique: len(shas)}
+	fresh, err := s.meta.UnchargedBlobs(ctx, ns, shas)
+	if err != nil || len(fresh) == 0 {
+		return res, err
+	}
+	res.fresh = make(map[string]int64, len(fresh))
+	for _, sha := range fresh {
+		rc, size, err := s.blobs.Open(ctx, sha)
+		if err != nil {
+			return res, err
+		}
+		rc.Close()
+		res.fresh[sha] = size
+	}
+	return res, nil
+}
+
+// nullable returns nil for an empty string so audit values read as JSON null.
+func nullable(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
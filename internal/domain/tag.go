@@ -0,0 +1,104 @@
+package domain
+
+import (
+	"context"
+	"errors"
+
+	"fgo/internal/auth"
+	"fgo/internal/storage/metastore"
+)
+
+// TagService manages tags: names for commits that, unlike branches, do not
+// move with pushes.
+type TagService struct {
+	meta  metastore.MetadataStore
+	audit *AuditLog
+}
+
+func NewTagService(meta metastore.MetadataStore, audit *AuditLog) *TagService {
+	return &TagService{meta: meta, audit: audit}
+}
+
+// TagRequest creates or moves a tag to Target, a branch, tag or commit ID.
+// A Message makes the tag annotated.
+type TagRequest struct {
+	Name    string `json:"name"`
+	Target  string `json:"target"`
+	Message string `json:"message"`
+}
+
+// List returns the tags of box by name.
+func (s *TagService) List(ctx context.Context, box metastore.Box) ([]metastore.Tag, error) {
+	return s.meta.ListTags(ctx, box.ID)
+}
+
+// Get returns one tag of box.
+func (s *TagService) Get(ctx context.Context, box metastore.Box, name string) (metastore.Tag, error) {
+	t, err := s.meta.GetTag(ctx, box.ID, name)
+	if err != nil {
+		return metastore.Tag{}, notFound(err, ErrTagNotFound)
+	}
+	return t, nil
+}
+
+// Create tags the commit req.Target resolves to, failing with ErrTagExists
+// if the name is taken.
+func (s *TagService) Create(ctx context.Context, box metastore.Box, req TagRequest) (metastore.Tag, error) {
+	t, err := s.tag(ctx, box, req)
+	if err != nil {
+		return metastore.Tag{}, err
+	}
+	t, err = s.meta.CreateTag(ctx, box.ID, t)
+	if errors.Is(err, metastore.ErrExists) {
+		return metastore.Tag{}, ErrTagExists
+	}
+	if err != nil {
+		return metastore.Tag{}, err
+	}
+	s.audit.Record(ctx, ActionTagCreate, "tag:"+box.Name+"/"+t.Name, box.ID, nil, t)
+	return t, nil
+}
+
+// Move points an existing tag at a new target. Callers restrict this to
+// admins; tags are otherwise immutable.
+func (s *TagService) Move(ctx context.Context, box metastore.Box, req TagRequest) (metastore.Tag, error) {
+	t, err := s.tag(ctx, box, req)
+	if err != nil {
+		return metastore.Tag{}, err
+	}
+	prev, err := s.meta.MoveTag(ctx, box.ID, t)
+	if err != nil {
+		return metastore.Tag{}, notFound(err, ErrTagNotFound)
+	}
+	if t, err = s.meta.GetTag(ctx, box.ID, t.Name); err != nil {
+		return metastore.Tag{}, err
+	}
+	s.audit.Record(ctx, ActionTagMove, "tag:"+box.Name+"/"+t.Name, box.ID, prev, t)
+	return t, nil
+}
+
+// Delete removes a tag. Callers restrict this to admins.
+func (s *TagService) Delete(ctx context.Context, box metastore.Box, name string) error {
+	prev, err := s.meta.DeleteTag(ctx, box.ID, name)
+	if err != nil {
+		return notFound(err, ErrTagNotFound)
+	}
+	s.audit.Record(ctx, ActionTagDelete, "tag:"+box.Name+"/"+name, box.ID, prev, nil)
+	return nil
+}
+
+// tag validates req and builds the tag it describes.
+func (s *TagService) tag(ctx context.Context, box metastore.Box, req TagRequest) (metastore.Tag, error) {
+	if err := validRefName(req.Name); err != nil {
+		return metastore.Tag{}, err
+	}
+	c, err := resolve(ctx, s.meta, box, req.Target)
+	if err != nil {
+		return metastore.Tag{}, err
+	}
+	t := metastore.Tag{Name: req.Name, CommitID: c.ID, Message: req.Message}
+	if p, ok := auth.FromContext(ctx); ok && t.Message != "" {
+		t.Tagger = p.ID
+	}
+	return t, nil
+}
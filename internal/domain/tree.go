@@ -0,0 +1,125 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"fgo/internal/storage/metastore"
+)
+
+// Tree listing page sizes.
+const (
+	DefaultListLimit = 100
+	MaxListLimit     = 1000
+)
+
+// Tree listing sort keys.
+const (
+	SortName = "name"
+	SortSize = "size"
+)
+
+// ListOptions selects and orders a directory listing.
+type ListOptions struct {
+	// Path is the directory to list, "" or "/" for the root. A trailing
+	// slash is allowed.
+	Path string
+	// Recursive lists everything below Path rather than one level.
+	Recursive bool
+	// Sort is SortName (the default) or SortSize. Name order puts
+	// directories first; recursive name order is by full path.
+	Sort   string
+	Desc   bool
+	Limit  int
+	Offset int
+}
+
+// Listing is one page of a directory listing. Total counts every entry the
+// listing has; Files and Size total the directory itself.
+type Listing struct {
+	CommitID   string                `json:"commit_id"`
+	Path       string                `json:"path"`
+	Entries    []metastore.TreeEntry `json:"entries"`
+	Total      int                   `json:"total"`
+	Files      int                   `json:"files"`
+	Size       int64                 `json:"size"`
+	NextOffset int                   `json:"next_offset,omitempty"`
+}
+
+// ListTree lists a directory of the commit rev names in box (see Resolve).
+func (s *BoxService) ListTree(ctx context.Context, box metastore.Box, rev string, opts ListOptions) (Listing, error) {
+	dir := strings.Trim(opts.Path, "/")
+	if dir != "" {
+		if err := validPath(dir); err != nil {
+			return Listing{}, fmt.Errorf("%w: invalid path %q", ErrInvalidRequest, opts.Path)
+		}
+	}
+	switch opts.Sort {
+	case "":
+		opts.Sort = SortName
+	case SortName, SortSize:
+	default:
+		return Listing{}, fmt.Errorf("%w: sort must be %q or %q", ErrInvalidRequest, SortName, SortSize)
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultListLimit
+	}
+	if opts.Limit > MaxListLimit || opts.Offset < 0 {
+		return Listing{}, fmt.Errorf("%w: limit must be at most %d and offset non-negative", ErrInvalidRequest, MaxListLimit)
+	}
+
+	commitID, err := resolveID(ctx, s.meta, box, rev)
+	if err != nil {
+		return Listing{}, err
+	}
+	entries, err := s.meta.ListTree(ctx, box.ID, commitID, dir, opts.Recursive)
+	switch {
+	case errors.Is(err, metastore.ErrNotDirectory):
+		return Listing{}, ErrPathNotFound
+	case err != nil:
+		return Listing{}, notFound(err, ErrCommitNotFound)
+	}
+
+	l := Listing{CommitID: commitID, Path: dir, Total: len(entries)}
+	for _, e := range entries {
+		if !opts.Recursive || e.Type == metastore.TreeEntryFile {
+			l.Files += e.Files
+			l.Size += e.Size
+		}
+	}
+	sortEntries(entries, opts)
+	if opts.Offset < len(entries) {
+		entries = entries[opts.Offset:]
+	} else {
+		entries = nil
+	}
+	if len(entries) > opts.Limit {
+		entries = entries[:opts.Limit]
+		l.NextOffset = opts.Offset + opts.Limit
+	}
+	l.Entries = append([]metastore.TreeEntry{}, entries...)
+	return l, nil
+}
+
+// sortEntries orders a listing as opts asks. Ties fall back to path order so
+// pages are stable.
+func sortEntries(es []metastore.TreeEntry, opts ListOptions) {
+	less := func(a, b metastore.TreeEntry) bool {
+		if opts.Sort == SortSize && a.Size != b.Size {
+			return a.Size < b.Size
+		}
+		if opts.Sort == SortName && !opts.Recursive && a.Type != b.Type {
+			return a.Type == metastore.TreeEntryDir
+		}
+		return a.Path < b.Path
+	}
+	sort.SliceStable(es, func(i, j int) bool {
+		if opts.Desc {
+			return less(es[j], es[i])
+		}
+		return less(es[i], es[j])
+	})
+}
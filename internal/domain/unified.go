@@ -0,0 +1,162 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxDiffCells bounds the LCS table of one unified diff (lines × lines after
+// trimming the common prefix and suffix); larger changes get no patch.
+const maxDiffCells = 4 << 20
+
+// diffOp is one line of an edit script: ' ' keep, '-' delete, '+' insert.
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// unifiedDiff renders a unified diff of two texts with ctxLines of context.
+// It returns "" when the texts are equal and ok=false when the change is
+// too large to diff.
+func unifiedDiff(oldName, newName, a, b string, ctxLines int) (patch string, ok bool) {
+	if a == b {
+		return "", true
+	}
+	ops, ok := diffLines(splitLines(a), splitLines(b))
+	if !ok {
+		return "", false
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", oldName, newName)
+	for start := 0; start < len(ops); {
+		// Find the next change and extend the hunk while changes are
+		// closer than two contexts apart.
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		last := first
+		for i := first; i < len(ops); i++ {
+			if ops[i].kind != ' ' {
+				last = i
+			} else if i-last > 2*ctxLines {
+				break
+			}
+		}
+		lo := max(first-ctxLines, start)
+		hi := min(last+ctxLines+1, len(ops))
+		writeHunk(&sb, ops, lo, hi)
+		start = hi
+	}
+	return sb.String(), true
+}
+
+func writeHunk(sb *strings.Builder, ops []diffOp, lo, hi int) {
+	// Line numbers are 1-based counts of old/new lines before lo.
+	oldLine, newLine := 1, 1
+	for _, op := range ops[:lo] {
+		if op.kind != '+' {
+			oldLine++
+		}
+		if op.kind != '-' {
+			newLine++
+		}
+	}
+	oldLen, newLen := 0, 0
+	for _, op := range ops[lo:hi] {
+		if op.kind != '+' {
+			oldLen++
+		}
+		if op.kind != '-' {
+			newLen++
+		}
+	}
+	// An empty range is numbered by the line before it.
+	if oldLen == 0 {
+		oldLine--
+	}
+	if newLen == 0 {
+		newLine--
+	}
+	fmt.Fprintf(sb, "@@ -%s +%s @@\n", hunkRange(oldLine, oldLen), hunkRange(newLine, newLen))
+	for _, op := range ops[lo:hi] {
+		sb.WriteByte(op.kind)
+		sb.WriteString(op.line)
+		if !strings.HasSuffix(op.line, "\n") {
+			sb.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+func hunkRange(start, n int) string {
+	if n == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, n)
+}
+
+// splitLines splits s after each newline, keeping the terminators.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines computes a minimal edit script from a to b via the longest
+// common subsequence of the lines between their common prefix and suffix.
+func diffLines(a, b []string) ([]diffOp, bool) {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	ma, mb := a[pre:len(a)-suf], b[pre:len(b)-suf]
+	if len(ma)*len(mb) > maxDiffCells {
+		return nil, false
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, l := range a[:pre] {
+		ops = append(ops, diffOp{' ', l})
+	}
+	// lcs[i][j] is the LCS length of ma[i:] and mb[j:].
+	n, m := len(ma), len(mb)
+	lcs := make([]int32, (n+1)*(m+1))
+	at := func(i, j int) int32 { return lcs[i*(m+1)+j] }
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if ma[i] == mb[j] {
+				lcs[i*(m+1)+j] = at(i+1, j+1) + 1
+			} else {
+				lcs[i*(m+1)+j] = max(at(i+1, j), at(i, j+1))
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && ma[i] == mb[j]:
+			ops = append(ops, diffOp{' ', ma[i]})
+			i++
+			j++
+		case i < n && (j == m || at(i+1, j) >= at(i, j+1)):
+			ops = append(ops, diffOp{'-', ma[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', mb[j]})
+			j++
+		}
+	}
+	for _, l := range a[len(a)-suf:] {
+		ops = append(ops, diffOp{' ', l})
+	}
+	return ops, true
+}
@@ -0,0 +1,165 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"fgo/internal/storage/blobstore"
+)
+
+// DefaultUploadTTL is how long an upload session may take to complete.
+const DefaultUploadTTL = 24 * time.Hour
+
+// UploadService runs resumable upload sessions for blobs too large to send
+// in one PUT. It needs a blob store implementing blobstore.Uploader; with
+// any other store every call fails with ErrUploadsUnsupported.
+type UploadService struct {
+	up     blobstore.Uploader
+	ttl    time.Duration
+	limits Limits
+}
+
+// NewUploadService serves sessions from blobs that expire ttl after they
+// were created; ttl <= 0 uses DefaultUploadTTL.
+func NewUploadService(blobs blobstore.BlobStore, ttl time.Duration) *UploadService {
+	if ttl <= 0 {
+		ttl = DefaultUploadTTL
+	}
+	up, _ := blobstore.AsUploader(blobs)
+	return &UploadService{up: up, ttl: ttl}
+}
+
+// SetLimits applies l.MaxBlobBytes to new sessions.
+func (s *UploadService) SetLimits(l Limits) {
+	s.limits = l
+}
+
+// Supported reports whether the blob store accepts resumable uploads.
+func (s *UploadService) Supported() bool {
+	return s.up != nil
+}
+
+// Create starts a session for a blob of size bytes with digest sha.
+func (s *UploadService) Create(ctx context.Context, sha string, size int64) (blobstore.Upload, error) {
+	if s.up == nil {
+		return blobstore.Upload{}, ErrUploadsUnsupported
+	}
+	if !validSHA256(sha) {
+		return blobstore.Upload{}, fmt.Errorf("%w: sha256 must be 64 lowercase hex characters", ErrInvalidRequest)
+	}
+	if size < 0 {
+		return blobstore.Upload{}, fmt.Errorf("%w: size must not be negative", ErrInvalidRequest)
+	}
+	if max := s.limits.MaxBlobBytes; max > 0 && size > max {
+		return blobstore.Upload{}, &LimitError{Limit: "blob size", Max: max, Got: size}
+	}
+	up, err := s.up.CreateUpload(ctx, sha, size, time.Now().Add(s.ttl))
+	if err != nil {
+		return blobstore.Upload{}, err
+	}
+	slog.InfoContext(ctx, "upload session created", "upload_id", up.ID, "sha256", sha, "size", size)
+	return up, nil
+}
+
+// Get returns a session's state, including the offset to resume from.
+func (s *UploadService) Get(ctx context.Context, id string) (blobstore.Upload, error) {
+	if s.up == nil {
+		return blobstore.Upload{}, ErrUploadsUnsupported
+	}
+	up, err := s.up.GetUpload(ctx, id)
+	return up, uploadErr(err)
+}
+
+// Write appends n bytes from r at offset. The chunk must start at the
+// session's current offset and must not run past its declared size.
+func (s *UploadService) Write(ctx context.Context, id string, offset int64, r io.Reader, n int64) (blobstore.Upload, error) {
+	up, err := s.Get(ctx, id)
+	if err != nil {
+		return blobstore.Upload{}, err
+	}
+	if offset != up.Offset {
+		return up, &OffsetMismatchError{Offset: up.Offset, Got: offset}
+	}
+	if n < 0 || offset+n > up.Size {
+		return up, fmt.Errorf("%w: chunk of %d bytes at offset %d exceeds declared size %d", ErrInvalidRequest, n, offset, up.Size)
+	}
+	up, err = s.up.WriteUpload(ctx, id, offset, io.LimitReader(r, n))
+	if errors.Is(err, blobstore.ErrOffsetMismatch) {
+		// Another request for the same session won the race.
+		return up, &OffsetMismatchError{Offset: up.Offset, Got: offset}
+	}
+	return up, uploadErr(err)
+}
+
+// Complete verifies the session's bytes against its digest and stores the blob.
+func (s *UploadService) Complete(ctx context.Context, id string) (blobstore.Upload, error) {
+	up, err := s.Get(ctx, id)
+	if err != nil {
+		return blobstore.Upload{}, err
+	}
+	if err := s.up.CompleteUpload(ctx, id); err != nil {
+		return up, uploadErr(err)
+	}
+	slog.InfoContext(ctx, "upload session completed", "upload_id", id, "sha256", up.SHA256, "size", up.Size)
+	return up, nil
+}
+
+// Abort discards a session.
+func (s *UploadService) Abort(ctx context.Context, id string) error {
+	if s.up == nil {
+		return ErrUploadsUnsupported
+	}
+	return uploadErr(s.up.AbortUpload(ctx, id))
+}
+
+// RunExpiry removes expired sessions every interval until ctx is done.
+func (s *UploadService) RunExpiry(ctx context.Context, interval time.Duration) {
+	if s.up == nil || interval <= 0 {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			n, err := s.up.ExpireUploads(ctx, now)
+			if err != nil && ctx.Err() == nil {
+				slog.ErrorContext(ctx, "expiring upload sessions", "error", err)
+			}
+			if n > 0 {
+				slog.InfoContext(ctx, "expired upload sessions", "count", n)
+			}
+		}
+	}
+}
+
+// uploadErr translates blobstore upload errors into domain errors.
+func uploadErr(err error) error {
+	switch {
+	case errors.Is(err, blobstore.ErrUploadNotFound):
+		return ErrUploadNotFound
+	case errors.Is(err, blobstore.ErrUploadIncomplete):
+		return ErrUploadIncomplete
+	case errors.Is(err, blobstore.ErrDigestMismatch):
+		return ErrDigestMismatch
+	}
+	return err
+}
+
+func validSHA256(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+package httpx
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"fgo/internal/auth"
+	"fgo/internal/observe"
+)
+
+// Authenticate resolves the request principal with a and stores it in the
+// context. Requests without credentials get the anonymous principal; rejected
+// credentials get a 401.
+func Authenticate(a auth.Authenticator, anonymous auth.Principal) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p := anonymous
+			if a != nil {
+				got, err := a.Authenticate(r)
+				switch {
+				case err == nil:
+					p = got
+				case errors.Is(err, auth.ErrNoCredentials):
+				default:
+					w.Header().Set("WWW-Authenticate", `Bearer realm="gofile"`)
+					Error(w, r, http.StatusUnauthorized, CodeUnauthenticated, "invalid credentials", nil)
+					return
+				}
+			}
+			observe.AddLogAttrs(r.Context(), slog.String("principal", p.ID))
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), p)))
+		})
+	}
+}
+
+// RequireScope rejects requests whose principal lacks scope: 401 for
+// anonymous clients, 403 for authenticated ones.
+func RequireScope(scope string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if CheckScope(w, r, scope) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// CheckScope is RequireScope for handlers whose required scope depends on
+// the request: it reports whether the principal has scope and, if not,
+// writes the 401 or 403.
+func CheckScope(w http.ResponseWriter, r *http.Request, scope string) bool {
+	p, _ := auth.FromContext(r.Context())
+	if p.Allows(scope) {
+		return true
+	}
+	if p.Anonymous() {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gofile"`)
+		Error(w, r, http.StatusUnauthorized, CodeUnauthenticated, "authentication required", nil)
+		return false
+	}
+	ErrorDetails(w, r, http.StatusForbidden, CodeForbidden, "insufficient scope",
+		map[string]string{"required_scope": scope, "scope": p.Scope}, nil)
+	return false
+}
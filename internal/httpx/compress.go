@@ -0,0 +1,259 @@
+package httpx
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Encoder creates a compressing writer for one Content-Encoding.
+type Encoder func(w io.Writer, level int) (io.WriteCloser, error)
+
+// Encoders are the content codings Compress can negotiate. Only codings from
+// the standard library are built in; others (e.g. zstd or br) can be added
+// here and named in CompressOptions.Encodings.
+var Encoders = map[string]Encoder{
+	"gzip": func(w io.Writer, level int) (io.WriteCloser, error) { return gzip.NewWriterLevel(w, level) },
+	// HTTP "deflate" is the zlib format (RFC 9110 section 8.4.1.2).
+	"deflate": func(w io.Writer, level int) (io.WriteCloser, error) { return zlib.NewWriterLevel(w, level) },
+}
+
+// CompressOptions configure Compress. Zero values pick defaults.
+type CompressOptions struct {
+	// MinSize is the smallest body, in bytes, worth compressing (default 1024).
+	MinSize int
+	// Level is the compress/flate level; 0 means the library default.
+	Level int
+	// Encodings lists the codings to offer in server preference order
+	// (default gzip, deflate). Unknown names are ignored.
+	Encodings []string
+}
+
+// Compress negotiates a Content-Encoding from Accept-Encoding and compresses
+// textual responses of at least MinSize bytes. HEAD, range, 204/206/304 and
+// already-encoded or non-textual (binary, precompressed) responses pass
+// through untouched. Compressed responses lose Content-Length, get a weak
+// ETag, and every compressible response carries Vary: Accept-Encoding.
+func Compress(opts CompressOptions) Middleware {
+	if opts.MinSize <= 0 {
+		opts.MinSize = 1024
+	}
+	if opts.Level == 0 {
+		opts.Level = gzip.DefaultCompression
+	}
+	if len(opts.Encodings) == 0 {
+		opts.Encodings = []string{"gzip", "deflate"}
+	}
+	var offered []string
+	for _, e := range opts.Encodings {
+		if _, ok := Encoders[e]; ok {
+			offered = append(offered, e)
+		}
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{ResponseWriter: w, opts: opts, enc: negotiate(r.Header.Get("Accept-Encoding"), offered)}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// negotiate picks the offered coding with the highest client q-value,
+// preferring earlier offers on ties. It returns "" for identity.
+func negotiate(accept string, offered []string) string {
+	if accept == "" {
+		return ""
+	}
+	q := map[string]float64{}
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		weight := 1.0
+		if k, v, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(k) == "q" {
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				weight = f
+			}
+		}
+		q[name] = weight
+	}
+	best, bestQ := "", 0.0
+	for _, e := range offered {
+		w, ok := q[e]
+		if !ok {
+			if w, ok = q["*"]; !ok {
+				continue
+			}
+		}
+		if w > bestQ {
+			best, bestQ = e, w
+		}
+	}
+	return best
+}
+
+// compressible reports whether a media type is textual enough to benefit.
+func compressible(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mt, "text/"),
+		strings.HasSuffix(mt, "+json"), strings.HasSuffix(mt, "+xml"):
+		return true
+	}
+	switch mt {
+	case "application/json", "application/x-ndjson", "application/xml", "application/yaml",
+		"application/x-yaml", "application/javascript", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// compressWriter decides at the first body write whether to compress,
+// buffering up to MinSize bytes when the length is not known up front.
+type compressWriter struct {
+	http.ResponseWriter
+	opts CompressOptions
+	enc  string
+
+	status    int
+	decided   bool
+	buffering bool
+	buf       []byte
+	zw        io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = code
+	// Informational, empty, partial and not-modified responses are never compressed.
+	if code < 200 || code == http.StatusNoContent || code == http.StatusPartialContent || code == http.StatusNotModified {
+		w.decide(false)
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.decided {
+		if w.zw != nil {
+			return w.zw.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	if !w.buffering {
+		h := w.Header()
+		if h.Get("Content-Type") == "" {
+			// Sniff now, as net/http would, so the type can drive the decision.
+			h.Set("Content-Type", http.DetectContentType(b))
+		}
+		if !w.eligible() {
+			w.decide(false)
+			return w.ResponseWriter.Write(b)
+		}
+		if cl := h.Get("Content-Length"); cl != "" {
+			n, _ := strconv.Atoi(cl)
+			w.decide(n >= w.opts.MinSize)
+			return w.Write(b)
+		}
+		w.buffering = true
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= w.opts.MinSize {
+		w.decide(true)
+	}
+	return len(b), nil
+}
+
+// eligible reports whether the response, as far as its headers tell, may be compressed.
+func (w *compressWriter) eligible() bool {
+	h := w.Header()
+	ct := h.Get("Content-Type")
+	if !compressible(ct) {
+		return false
+	}
+	h.Add("Vary", "Accept-Encoding")
+	return w.enc != "" && h.Get("Content-Encoding") == "" && h.Get("Content-Range") == ""
+}
+
+// decide writes the header, switching to the negotiated encoder if compress
+// is set, and releases any buffered bytes.
+func (w *compressWriter) decide(compress bool) {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if compress {
+		zw, err := Encoders[w.enc](w.ResponseWriter, w.opts.Level)
+		if err == nil {
+			h := w.Header()
+			h.Set("Content-Encoding", w.enc)
+			h.Del("Content-Length")
+			if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+				h.Set("ETag", "W/"+etag)
+			}
+			w.zw = zw
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) > 0 {
+		buf := w.buf
+		w.buf = nil
+		if w.zw != nil {
+			_, _ = w.zw.Write(buf)
+		} else {
+			_, _ = w.ResponseWriter.Write(buf)
+		}
+	}
+}
+
+// close settles a still-open decision (short bodies stay uncompressed) and
+// finishes the encoded stream.
+func (w *compressWriter) close() {
+	if !w.decided {
+		if w.status == 0 && len(w.buf) == 0 {
+			// The handler wrote nothing; let net/http produce its default response.
+			return
+		}
+		w.decide(false)
+	}
+	if w.zw != nil {
+		_ = w.zw.Close()
+	}
+}
+
+// Flush sends what has been written so far, deciding on compression first if needed.
+func (w *compressWriter) Flush() {
+	if !w.decided && w.status != 0 {
+		w.decide(w.enc != "" && len(w.buf) >= w.opts.MinSize)
+	}
+	if f, ok := w.zw.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets protocol upgrades bypass compression.
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
@@ -0,0 +1,165 @@
+package httpx
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions is a deployment's cross-origin policy.
+type CORSOptions struct {
+	// AllowedOrigins are exact origins ("https://dash.example.com"),
+	// subdomain wildcards ("https://*.example.com") or "*" for any origin.
+	// Empty disables CORS.
+	AllowedOrigins []string
+	// AllowedMethods may be used in cross-origin requests (default GET, HEAD,
+	// POST, PUT, PATCH, DELETE).
+	AllowedMethods []string
+	// AllowedHeaders may be sent by cross-origin requests, case-insensitive
+	// (default DefaultCORSHeaders).
+	AllowedHeaders []string
+	// ExposedHeaders are readable by cross-origin scripts (default
+	// DefaultCORSExposedHeaders).
+	ExposedHeaders []string
+	// AllowCredentials permits cookies and TLS client certificates. The
+	// matching origin is then echoed instead of "*".
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight result.
+	MaxAge time.Duration
+}
+
+// DefaultCORSMethods, DefaultCORSHeaders and DefaultCORSExposedHeaders cover the gofile API.
+var (
+	DefaultCORSMethods        = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+	DefaultCORSHeaders        = []string{"Authorization", "Content-Type", "Digest", "If-None-Match", "Range", "Upload-Offset", "X-Request-Id", "traceparent"}
+	DefaultCORSExposedHeaders = []string{"ETag", "Content-Range", "Content-Length", "Accept-Ranges", "Location", "X-Request-Id", "Retry-After",
+		"Upload-Offset", "Upload-Length", "Upload-Expires",
+		"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset"}
+)
+
+// CORS applies opts: it answers preflight requests (OPTIONS with
+// Access-Control-Request-Method) from allowed origins with 204, rejects other
+// preflights with 403, and adds Access-Control-Allow-Origin and exposed
+// headers to actual requests from allowed origins. Requests without an Origin
+// header, and disallowed actual requests, pass through unchanged; the browser
+// then withholds the response from the page.
+func CORS(opts CORSOptions) Middleware {
+	if len(opts.AllowedMethods) == 0 {
+		opts.AllowedMethods = DefaultCORSMethods
+	}
+	if opts.AllowedHeaders == nil {
+		opts.AllowedHeaders = DefaultCORSHeaders
+	}
+	if opts.ExposedHeaders == nil {
+		opts.ExposedHeaders = DefaultCORSExposedHeaders
+	}
+	allowHeaders := make([]string, len(opts.AllowedHeaders))
+	for i, h := range opts.AllowedHeaders {
+		allowHeaders[i] = strings.ToLower(h)
+	}
+	anyOrigin := slices.Contains(opts.AllowedOrigins, "*")
+	methods := strings.Join(opts.AllowedMethods, ", ")
+	exposed := strings.Join(opts.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(opts.MaxAge.Seconds()))
+
+	return func(next http.Handler) http.Handler {
+		if len(opts.AllowedOrigins) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			if !anyOrigin || opts.AllowCredentials {
+				h.Add("Vary", "Origin")
+			}
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			if preflight {
+				h.Add("Vary", "Access-Control-Request-Method")
+				h.Add("Vary", "Access-Control-Request-Headers")
+			}
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			allowed := anyOrigin || originAllowed(origin, opts.AllowedOrigins)
+
+			if preflight {
+				reqMethod := r.Header.Get("Access-Control-Request-Method")
+				reqHeaders := splitHeaderList(r.Header.Get("Access-Control-Request-Headers"))
+				if !allowed || !slices.Contains(opts.AllowedMethods, reqMethod) || !headersAllowed(reqHeaders, allowHeaders) {
+					Error(w, r, http.StatusForbidden, CodeForbidden, "CORS preflight rejected", nil)
+					return
+				}
+				setAllowOrigin(h, origin, anyOrigin, opts.AllowCredentials)
+				h.Set("Access-Control-Allow-Methods", methods)
+				if len(reqHeaders) > 0 {
+					h.Set("Access-Control-Allow-Headers", strings.Join(reqHeaders, ", "))
+				}
+				if opts.MaxAge > 0 {
+					h.Set("Access-Control-Max-Age", maxAge)
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			if allowed {
+				setAllowOrigin(h, origin, anyOrigin, opts.AllowCredentials)
+				if exposed != "" {
+					h.Set("Access-Control-Expose-Headers", exposed)
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func setAllowOrigin(h http.Header, origin string, anyOrigin, credentials bool) {
+	if anyOrigin && !credentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	if credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// originAllowed matches origin against exact entries and "scheme://*.domain" wildcards.
+func originAllowed(origin string, allowed []string) bool {
+	origin = strings.ToLower(origin)
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		if a == origin {
+			return true
+		}
+		if scheme, rest, ok := strings.Cut(a, "://*."); ok {
+			prefix := scheme + "://"
+			if strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, "."+rest) &&
+				len(origin) > len(prefix)+len(rest)+1 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func headersAllowed(requested, allowed []string) bool {
+	for _, h := range requested {
+		if !slices.Contains(allowed, strings.ToLower(h)) {
+			return false
+		}
+	}
+	return true
+}
+
+func splitHeaderList(v string) []string {
+	var out []string
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,93 @@
+package httpx
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// Error codes returned in the "code" field of error responses. They mirror the
+// ROADMAP error table and are stable: clients may switch on them.
+const (
+	CodeBadRequest          = "bad_request"
+	CodeInvalidManifest     = "invalid_manifest"
+	CodeUnauthenticated     = "unauthenticated"
+	CodeForbidden           = "forbidden"
+	CodeNotFound            = "not_found"
+	CodeBoxNotFound         = "box_not_found"
+	CodeCommitNotFound      = "commit_not_found"
+	CodeBranchNotFound      = "branch_not_found"
+	CodeTagNotFound         = "tag_not_found"
+	CodeTagExists           = "tag_exists"
+	CodePathNotFound        = "path_not_found"
+	CodeBlobNotFound        = "blob_not_found"
+	CodeMethodNotAllowed    = "method_not_allowed"
+	CodeConflict            = "conflict"
+	CodeParentMismatch      = "parent_mismatch"
+	CodeMergeConflict       = "merge_conflict"
+	CodeBranchProtected     = "branch_protected"
+	CodeBadSignature        = "bad_signature"
+	CodeLengthRequired      = "length_required"
+	CodeRangeNotSatisfiable = "range_not_satisfiable"
+	CodePayloadTooLarge     = "payload_too_large"
+	CodeQuotaExceeded       = "quota_exceeded"
+	CodeMissingBlob         = "missing_blob"
+	CodeDigestMismatch      = "digest_mismatch"
+	CodeUploadNotFound      = "upload_not_found"
+	CodeOffsetMismatch      = "offset_mismatch"
+	CodeUploadIncomplete    = "upload_incomplete"
+	CodeNotImplemented      = "not_implemented"
+	CodeRateLimited         = "rate_limited"
+	CodeInternal            = "internal"
+)
+
+// ErrorBody is the payload of every API error response.
+type ErrorBody struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+	Details   any    `json:"details,omitempty"`
+}
+
+type errorEnvelope struct {
+	Error ErrorBody `json:"error"`
+}
+
+// Error writes a JSON error envelope with the given status, code and message.
+// If err is non-nil it is logged server-side (at error level for 5xx, warn
+// otherwise) and never sent to the client.
+func Error(w http.ResponseWriter, r *http.Request, status int, code, msg string, err error) {
+	ErrorDetails(w, r, status, code, msg, nil, err)
+}
+
+// ErrorDetails is like Error but attaches machine-readable details.
+func ErrorDetails(w http.ResponseWriter, r *http.Request, status int, code, msg string, details any, err error) {
+	rid := RequestIDFrom(r.Context())
+	if rid == "" {
+		rid = w.Header().Get("X-Request-Id")
+	}
+	if err != nil {
+		level := slog.LevelWarn
+		if status >= 500 {
+			level = slog.LevelError
+		}
+		slog.LogAttrs(r.Context(), level, "request failed",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.String("code", code),
+			slog.String("error", err.Error()),
+		)
+	}
+	body := errorEnvelope{Error: ErrorBody{Code: code, Message: msg, RequestID: rid, Details: details}}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// Internal writes a 500 error and logs the underlying cause.
+func Internal(w http.ResponseWriter, r *http.Request, err error) {
+	Error(w, r, http.StatusInternalServerError, CodeInternal, "internal error", err)
+}
@@ -1,14 +1,15 @@
 package httpx
 
 import (
-	"compress/gzip"
+	"context"
+	"encoding/json"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
 	"math/rand"
 	"net/http"
-	"strings"
 	"time"
+
+	"fgo/internal/observe"
 )
 
 // Middleware is a function that wraps an http.Handler
@@ -22,14 +23,17 @@ func Chain(h http.Handler, mws ...Middleware) http.Handler {
 	return h
 }
 
-// Recover catches panics and returns 500.
+// Recover catches panics and returns 500. http.ErrAbortHandler is passed on
+// so a handler can still abort a response it has started.
 func Recover() Middleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			defer func() {
 				if rec := recover(); rec != nil {
-					log.Printf("panic: %v", rec)
-					http.Error(w, "internal error", http.StatusInternalServerError)
+					if rec == http.ErrAbortHandler {
+						panic(rec)
+					}
+					Internal(w, r, fmt.Errorf("panic: %v", rec))
 				}
 			}()
 			next.ServeHTTP(w, r)
@@ -37,62 +41,46 @@ func Recover() Middleware {
 	}
 }
 
-// RequestID adds a simple request id header.
+// RequestID assigns each request an ID (reusing a client-supplied
+// X-Request-Id), echoes it in the response header and stores it in the
+// context, where it is attached to every log line as request_id.
 func RequestID() Middleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			rid := r.Header.Get("X-Request-Id")
-			if rid == "" {
+			if rid == "" || len(rid) > 128 {
 				rid = genID()
 			}
 			w.Header().Set("X-Request-Id", rid)
-			next.ServeHTTP(w, r)
+			ctx := observe.WithRequestID(r.Context(), rid)
+			ctx = observe.WithLogAttrs(ctx, slog.String("request_id", rid))
+			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
-// Logger logs method, path, status and duration.
+// RequestIDFrom returns the request ID stored by RequestID, or "".
+func RequestIDFrom(ctx context.Context) string {
+	return observe.RequestID(ctx)
+}
+
+// Logger writes one structured access log line per request with the method,
+// path, matched route, status and duration. Attributes added to the request
+// context (request_id, principal, box_id, ...) are included.
 func Logger() Middleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			start := time.Now()
+			r, rh := trackRoute(r)
 			rw := &statusWriter{ResponseWriter: w, status: 200}
 			next.ServeHTTP(rw, r)
-			dur := time.Since(start)
-			log.Printf("%s %s -> %d %s", r.Method, r.URL.Path, rw.status, dur)
-		})
-	}
-}
-
-// CORS allows simple cross-origin requests.
-func CORS() Middleware {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,HEAD,OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Digest")
-			if r.Method == http.MethodOptions {
-				w.WriteHeader(http.StatusNoContent)
-				return
-			}
-			next.ServeHTTP(w, r)
-		})
-	}
-}
-
-// Gzip compresses responses if client supports it.
-func Gzip() Middleware {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if !acceptsGzip(r) {
-				next.ServeHTTP(w, r)
-				return
-			}
-			w.Header().Set("Content-Encoding", "gzip")
-			gz := gzip.NewWriter(w)
-			defer gz.Close()
-			gzw := &gzipResponseWriter{ResponseWriter: w, Writer: gz}
-			next.ServeHTTP(gzw, r)
+			slog.LogAttrs(r.Context(), slog.LevelInfo, "request",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.String("route", routeLabel(rh)),
+				slog.Int("status", rw.status),
+				slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			)
 		})
 	}
 }
@@ -107,20 +95,13 @@ func (w *statusWriter) WriteHeader(code int) {
 	w.ResponseWriter.WriteHeader(code)
 }
 
-type gzipResponseWriter struct {
-	http.ResponseWriter
-	io.Writer
-}
-
-func (w *gzipResponseWriter) Write(b []byte) (int, error) {
-	return w.Writer.Write(b)
-}
-
-func acceptsGzip(r *http.Request) bool {
-	ae := r.Header.Get("Accept-Encoding")
-	return ae != "" && strings.Contains(strings.ToLower(ae), "gzip")
-}
-
 func genID() string {
 	return fmt.Sprintf("%08x%08x", rand.Uint32(), rand.Uint32())
 }
+
+// JSON writes v as a JSON response with the given status.
+func JSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
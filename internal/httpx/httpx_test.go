@@ -0,0 +1,400 @@
+package httpx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"fgo/internal/auth"
+	"fgo/internal/observe"
+)
+
+func TestErrorEnvelope(t *testing.T) {
+	h := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ErrorDetails(w, r, http.StatusConflict, CodeParentMismatch, "branch moved", map[string]string{"branch": "main"}, errors.New("cause"))
+	}), RequestID())
+	req := httptest.NewRequest(http.MethodPost, "/x", nil)
+	req.Header.Set("X-Request-Id", "rid-1")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected application/json, got %q", ct)
+	}
+	var env struct {
+		Error struct {
+			Code      string            `json:"code"`
+			Message   string            `json:"message"`
+			RequestID string            `json:"request_id"`
+			Details   map[string]string `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&env); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if env.Error.Code != CodeParentMismatch || env.Error.Message != "branch moved" {
+		t.Fatalf("unexpected envelope: %+v", env.Error)
+	}
+	if env.Error.RequestID != "rid-1" {
+		t.Fatalf("expected request id rid-1, got %q", env.Error.RequestID)
+	}
+	if env.Error.Details["branch"] != "main" {
+		t.Fatalf("expected details, got %v", env.Error.Details)
+	}
+}
+
+func TestRecoverWritesInternalError(t *testing.T) {
+	h := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}), Recover())
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", w.Code)
+	}
+	var env struct {
+		Error ErrorBody `json:"error"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&env); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if env.Error.Code != CodeInternal {
+		t.Fatalf("expected code %q, got %q", CodeInternal, env.Error.Code)
+	}
+}
+
+func TestRouterParamsAndMethods(t *testing.T) {
+	var order []string
+	tag := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	rt := NewRouter(tag("router"))
+	rt.HandleFunc("GET /v0/boxes/{box}/commits", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("list:" + r.PathValue("box")))
+	})
+	rt.HandleFunc("GET /v0/boxes/{box}/commits/latest", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("latest:" + r.PathValue("box")))
+	}, tag("route"))
+	rt.HandleFunc("POST /v0/boxes/{box}/push/plan", func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	rt.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v0/boxes/demo/commits/latest", nil))
+	if w.Body.String() != "latest:demo" {
+		t.Fatalf("expected latest route, got %q", w.Body.String())
+	}
+	if len(order) != 2 || order[0] != "router" || order[1] != "route" {
+		t.Fatalf("unexpected middleware order %v", order)
+	}
+
+	w = httptest.NewRecorder()
+	rt.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v0/boxes/demo/commits", nil))
+	if w.Body.String() != "list:demo" {
+		t.Fatalf("expected list route, got %q", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	rt.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v0/boxes/demo/push/plan", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", w.Code)
+	}
+	if allow := w.Header().Get("Allow"); allow != "POST" {
+		t.Fatalf("expected Allow: POST, got %q", allow)
+	}
+	var env struct {
+		Error ErrorBody `json:"error"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&env); err != nil || env.Error.Code != CodeMethodNotAllowed {
+		t.Fatalf("expected method_not_allowed envelope, got %+v (%v)", env.Error, err)
+	}
+
+	w = httptest.NewRecorder()
+	rt.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v0/nope", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+	if err := json.NewDecoder(w.Body).Decode(&env); err != nil || env.Error.Code != CodeNotFound {
+		t.Fatalf("expected not_found envelope, got %+v (%v)", env.Error, err)
+	}
+}
+
+func TestAuthenticateAndRequireScope(t *testing.T) {
+	tokens := auth.NewStaticTokens()
+	sum := sha256.Sum256([]byte("s3cret"))
+	tokens.Add("ci", hex.EncodeToString(sum[:]), auth.ScopeWrite)
+
+	rt := NewRouter()
+	rt.HandleFunc("GET /read", func(w http.ResponseWriter, r *http.Request) {
+		p, _ := auth.FromContext(r.Context())
+		_, _ = w.Write([]byte(p.ID))
+	}, RequireScope(auth.ScopeRead))
+	rt.HandleFunc("POST /admin", func(w http.ResponseWriter, r *http.Request) {}, RequireScope(auth.ScopeAdmin))
+	h := Chain(rt, Authenticate(tokens, auth.Principal{ID: "anonymous", Scope: auth.ScopeNone}))
+
+	cases := []struct {
+		method, path, token string
+		want                int
+	}{
+		{http.MethodGet, "/read", "", http.StatusUnauthorized},
+		{http.MethodGet, "/read", "wrong", http.StatusUnauthorized},
+		{http.MethodGet, "/read", "s3cret", http.StatusOK},
+		{http.MethodPost, "/admin", "s3cret", http.StatusForbidden},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.path, nil)
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != c.want {
+			t.Errorf("%s %s token=%q: expected %d, got %d", c.method, c.path, c.token, c.want, w.Code)
+		}
+		if c.want == http.StatusOK && w.Body.String() != "token:ci" {
+			t.Errorf("expected principal token:ci, got %q", w.Body.String())
+		}
+	}
+}
+
+func TestLoggerIncludesRequestContext(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := observe.NewLogger(&buf, "info", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	rt := NewRouter()
+	rt.HandleFunc("GET /boxes/{box}", func(w http.ResponseWriter, r *http.Request) {
+		if RequestIDFrom(r.Context()) != "rid-7" {
+			t.Errorf("request id not in context")
+		}
+		observe.AddLogAttrs(r.Context(), slog.String("box_id", r.PathValue("box")))
+	})
+	tokens := auth.NewStaticTokens()
+	sum := sha256.Sum256([]byte("secret"))
+	tokens.Add("ci", hex.EncodeToString(sum[:]), auth.ScopeRead)
+	h := Chain(rt, RequestID(), Logger(), Authenticate(tokens, auth.Principal{ID: "anonymous"}))
+
+	req := httptest.NewRequest(http.MethodGet, "/boxes/demo", nil)
+	req.Header.Set("X-Request-Id", "rid-7")
+	req.Header.Set("Authorization", "Bearer secret")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("decode %q: %v", buf.String(), err)
+	}
+	want := map[string]any{"request_id": "rid-7", "principal": "token:ci", "box_id": "demo", "route": "GET /boxes/{box}", "status": float64(200)}
+	for k, v := range want {
+		if line[k] != v {
+			t.Errorf("%s = %v, want %v (line %v)", k, line[k], v, line)
+		}
+	}
+}
+
+func TestRateLimitRejectsWithRetryAfter(t *testing.T) {
+	l := NewRateLimiter(1, 2)
+	now := time.Unix(1000, 0)
+	l.now = func() time.Time { return now }
+	h := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		RateLimit(l, func(*http.Request) string { return "k" }))
+	call := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/v0/blobs/x", nil))
+		return w
+	}
+
+	for i, wantRemaining := range []string{"1", "0"} {
+		w := call()
+		if w.Code != http.StatusOK || w.Header().Get("RateLimit-Remaining") != wantRemaining || w.Header().Get("RateLimit-Limit") != "2" {
+			t.Fatalf("request %d: status %d headers %v", i, w.Code, w.Header())
+		}
+	}
+	w := call()
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("expected 429 with Retry-After 1, got %d %v", w.Code, w.Header())
+	}
+	now = now.Add(time.Second)
+	if w := call(); w.Code != http.StatusOK {
+		t.Fatalf("expected refill after 1s, got %d", w.Code)
+	}
+}
+
+func TestClientIPTrustedProxies(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	for _, tc := range []struct {
+		remote, xff, want string
+	}{
+		{"203.0.113.9:1234", "1.2.3.4", "203.0.113.9"},             // untrusted peer: header ignored
+		{"10.0.0.1:1234", "1.2.3.4, 10.0.0.7", "1.2.3.4"},          // skip trusted hops from the right
+		{"10.0.0.1:1234", "6.6.6.6, 1.2.3.4, 10.0.0.7", "1.2.3.4"}, // spoofed leftmost entry ignored
+		{"10.0.0.1:1234", "", "10.0.0.1"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tc.remote
+		if tc.xff != "" {
+			r.Header.Set("X-Forwarded-For", tc.xff)
+		}
+		if got := ClientIP(r, trusted); got != tc.want {
+			t.Errorf("ClientIP(%s, %q) = %s, want %s", tc.remote, tc.xff, got, tc.want)
+		}
+	}
+}
+
+func TestCompressNegotiationAndSkips(t *testing.T) {
+	body := strings.Repeat(`{"k":"v"}`, 200)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{}`))
+		case "/binary":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			_, _ = w.Write([]byte(body))
+		case "/partial":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Range", "bytes 0-9/100")
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write([]byte(body[:10]))
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.Header().Set("ETag", `"abc"`)
+			_, _ = w.Write([]byte(body))
+		}
+	}
+	h := Chain(http.HandlerFunc(handler), Compress(CompressOptions{MinSize: 64}))
+	do := func(method, path, accept string, hdr ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Accept-Encoding", accept)
+		for i := 0; i+1 < len(hdr); i += 2 {
+			req.Header.Set(hdr[i], hdr[i+1])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodGet, "/json", "br;q=1, gzip;q=0.8, deflate;q=0.5")
+	if w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("Content-Length") != "" ||
+		w.Header().Get("Vary") != "Accept-Encoding" || w.Header().Get("ETag") != `W/"abc"` {
+		t.Fatalf("expected gzip without Content-Length, got %v", w.Header())
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	if got, _ := io.ReadAll(zr); string(got) != body {
+		t.Fatalf("round trip mismatch")
+	}
+	if w := do(http.MethodGet, "/json", "gzip;q=0, deflate"); w.Header().Get("Content-Encoding") != "deflate" {
+		t.Fatalf("expected deflate when gzip is refused, got %q", w.Header().Get("Content-Encoding"))
+	}
+	for _, tc := range []struct {
+		name, method, path string
+		hdr                []string
+	}{
+		{"below min size", http.MethodGet, "/small", nil},
+		{"binary", http.MethodGet, "/binary", nil},
+		{"partial content", http.MethodGet, "/partial", nil},
+		{"range request", http.MethodGet, "/json", []string{"Range", "bytes=0-9"}},
+		{"head", http.MethodHead, "/json", nil},
+	} {
+		w := do(tc.method, tc.path, "gzip", tc.hdr...)
+		if ce := w.Header().Get("Content-Encoding"); ce != "" {
+			t.Errorf("%s: expected no compression, got %q", tc.name, ce)
+		}
+	}
+}
+
+func TestCORSPolicy(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"x"`)
+		w.WriteHeader(http.StatusOK)
+	})
+	h := CORS(CORSOptions{
+		AllowedOrigins:   []string{"https://dash.example.com", "https://*.corp.example"},
+		AllowCredentials: true,
+		MaxAge:           time.Minute,
+	})(next)
+
+	do := func(method, origin string, hdr map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/v0/boxes", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		for k, v := range hdr {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodOptions, "https://dash.example.com", map[string]string{
+		"Access-Control-Request-Method":  "PATCH",
+		"Access-Control-Request-Headers": "authorization, content-type",
+	})
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("preflight status = %d", rec.Code)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://dash.example.com" {
+		t.Fatalf("allow origin = %q", got)
+	}
+	if rec.Header().Get("Access-Control-Allow-Credentials") != "true" || rec.Header().Get("Access-Control-Max-Age") != "60" {
+		t.Fatalf("preflight headers = %v", rec.Header())
+	}
+	if !strings.Contains(rec.Header().Get("Access-Control-Allow-Methods"), "PATCH") {
+		t.Fatalf("allow methods = %q", rec.Header().Get("Access-Control-Allow-Methods"))
+	}
+
+	for name, tc := range map[string]struct {
+		origin string
+		hdr    map[string]string
+	}{
+		"origin":   {"https://evil.example", map[string]string{"Access-Control-Request-Method": "GET"}},
+		"method":   {"https://dash.example.com", map[string]string{"Access-Control-Request-Method": "TRACE"}},
+		"header":   {"https://dash.example.com", map[string]string{"Access-Control-Request-Method": "GET", "Access-Control-Request-Headers": "X-Secret"}},
+		"wildcard": {"https://corp.example", map[string]string{"Access-Control-Request-Method": "GET"}},
+	} {
+		if rec := do(http.MethodOptions, tc.origin, tc.hdr); rec.Code != http.StatusForbidden || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Fatalf("%s: preflight status = %d, headers = %v", name, rec.Code, rec.Header())
+		}
+	}
+
+	rec = do(http.MethodGet, "https://app.corp.example", nil)
+	if rec.Header().Get("Access-Control-Allow-Origin") != "https://app.corp.example" ||
+		!strings.Contains(rec.Header().Get("Access-Control-Expose-Headers"), "ETag") ||
+		!strings.Contains(rec.Header().Get("Vary"), "Origin") {
+		t.Fatalf("actual request headers = %v", rec.Header())
+	}
+	if rec := do(http.MethodGet, "https://evil.example", nil); rec.Code != http.StatusOK || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("disallowed origin got %d %v", rec.Code, rec.Header())
+	}
+	// Plain OPTIONS without a preflight header reaches the handler.
+	if rec := do(http.MethodOptions, "", nil); rec.Code != http.StatusOK {
+		t.Fatalf("plain OPTIONS status = %d", rec.Code)
+	}
+}
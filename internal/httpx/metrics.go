@@ -0,0 +1,30 @@
+package httpx
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"fgo/internal/observe"
+)
+
+// Metrics records http_requests_total{method,route,status} and
+// http_request_duration_seconds{method,route} in reg. The route label is the
+// matched Router pattern (e.g. "GET /v0/boxes/{box}"), or "unmatched".
+func Metrics(reg *observe.Registry) Middleware {
+	requests := reg.Counter("http_requests_total", "HTTP requests by method, route and status.", "method", "route", "status")
+	duration := reg.Histogram("http_request_duration_seconds", "HTTP request latency in seconds.", observe.DefBuckets, "method", "route")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			r, rh := trackRoute(r)
+			rw := &statusWriter{ResponseWriter: w, status: 200}
+			defer func() {
+				route := routeLabel(rh)
+				requests.Inc(r.Method, route, strconv.Itoa(rw.status))
+				duration.Observe(time.Since(start).Seconds(), r.Method, route)
+			}()
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package httpx
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"fgo/internal/auth"
+)
+
+// RateLimiter is a set of token buckets, one per key, each refilled at rate
+// tokens per second up to burst.
+type RateLimiter struct {
+	rate  float64
+	burst int
+	now   func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{rate: rate, burst: burst, now: time.Now, buckets: map[string]*bucket{}}
+}
+
+// Allow takes a token from key's bucket. It returns whether the request is
+// allowed, the tokens left, and how long until the next token (retry) and
+// until the bucket is full again (reset).
+func (l *RateLimiter) Allow(key string) (ok bool, remaining int, retry, reset time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+	b, found := l.buckets[key]
+	if !found {
+		b = &bucket{tokens: float64(l.burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.burst), b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		ok = true
+	} else {
+		retry = l.wait(1 - b.tokens)
+	}
+	return ok, int(b.tokens), retry, l.wait(float64(l.burst) - b.tokens)
+}
+
+// wait is how long it takes to refill n tokens.
+func (l *RateLimiter) wait(n float64) time.Duration {
+	if l.rate <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(n / l.rate * float64(time.Second))
+}
+
+// sweep drops buckets that have refilled completely, at most once a minute,
+// so idle clients do not accumulate.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for k, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= float64(l.burst) {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// RateLimit throttles requests per key(r) using l and reports the quota in
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers. Rejected
+// requests get 429 with Retry-After. A nil limiter disables throttling.
+func RateLimit(l *RateLimiter, key func(*http.Request) string) Middleware {
+	return func(next http.Handler) http.Handler {
+		if l == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ok, remaining, retry, reset := l.Allow(key(r))
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(l.burst))
+			h.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+			h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(reset)))
+			if !ok {
+				secs := ceilSeconds(retry)
+				h.Set("Retry-After", strconv.Itoa(secs))
+				ErrorDetails(w, r, http.StatusTooManyRequests, CodeRateLimited, "rate limit exceeded",
+					map[string]int{"retry_after_seconds": secs}, nil)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	if d >= time.Duration(math.MaxInt64) {
+		return math.MaxInt32
+	}
+	return int(math.Ceil(d.Seconds()))
+}
+
+// ClientKey returns a rate limit key function: the principal ID for
+// authenticated requests, otherwise the client IP as seen through trusted.
+func ClientKey(trusted []netip.Prefix) func(*http.Request) string {
+	return func(r *http.Request) string {
+		if p, ok := auth.FromContext(r.Context()); ok && !p.Anonymous() {
+			return "principal:" + p.ID
+		}
+		return "ip:" + ClientIP(r, trusted)
+	}
+}
+
+// ClientIP returns the address of the client. X-Forwarded-For and X-Real-IP
+// are only honored when the direct peer is a trusted proxy; the client is the
+// rightmost forwarded address that is not itself a trusted proxy.
+func ClientIP(r *http.Request, trusted []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	if err != nil || !isTrusted(peer, trusted) {
+		return host
+	}
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		if !isTrusted(addr, trusted) || i == 0 {
+			return addr.Unmap().String()
+		}
+	}
+	if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return addr.Unmap().String()
+	}
+	return host
+}
+
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, p := range trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
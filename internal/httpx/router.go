@@ -0,0 +1,111 @@
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// Router dispatches requests using Go 1.22 ServeMux patterns
+// ("GET /v0/boxes/{box}") and renders unmatched routes as JSON errors:
+// 404 when no pattern matches the path, 405 with an Allow header when the
+// path matches but the method does not.
+type Router struct {
+	mux *http.ServeMux
+	mws []Middleware
+}
+
+// NewRouter returns a router whose routes are all wrapped in mws.
+func NewRouter(mws ...Middleware) *Router {
+	return &Router{mux: http.NewServeMux(), mws: mws}
+}
+
+// Handle registers h for pattern. Route middlewares run inside the router-wide ones.
+func (rt *Router) Handle(pattern string, h http.Handler, mws ...Middleware) {
+	h = Chain(h, mws...)
+	h = Chain(h, rt.mws...)
+	rt.mux.Handle(pattern, h)
+}
+
+// HandleFunc registers fn for pattern.
+func (rt *Router) HandleFunc(pattern string, fn http.HandlerFunc, mws ...Middleware) {
+	rt.Handle(pattern, fn, mws...)
+}
+
+// Group returns a router sharing the same routes whose registrations are
+// additionally wrapped in mws.
+func (rt *Router) Group(mws ...Middleware) *Router {
+	all := make([]Middleware, 0, len(rt.mws)+len(mws))
+	all = append(all, rt.mws...)
+	all = append(all, mws...)
+	return &Router{mux: rt.mux, mws: all}
+}
+
+// ServeHTTP implements http.Handler.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h, pattern := rt.mux.Handler(r)
+	if rh, ok := r.Context().Value(routeKey{}).(*routeHolder); ok {
+		rh.pattern = pattern
+	}
+	if pattern == "" {
+		// ServeMux reports 404 and 405 via an unnamed handler; run it against a
+		// header-only writer to learn which one it is and the allowed methods.
+		probe := &probeWriter{header: http.Header{}}
+		h.ServeHTTP(probe, r)
+		if probe.status == http.StatusMethodNotAllowed {
+			allow := probe.header.Get("Allow")
+			w.Header().Set("Allow", allow)
+			ErrorDetails(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed",
+				map[string][]string{"allow": strings.Split(allow, ", ")}, nil)
+			return
+		}
+		Error(w, r, http.StatusNotFound, CodeNotFound, "route not found", nil)
+		return
+	}
+	rt.mux.ServeHTTP(w, r)
+}
+
+// probeWriter records the status and headers of a response and discards the body.
+type probeWriter struct {
+	header http.Header
+	status int
+}
+
+func (p *probeWriter) Header() http.Header { return p.header }
+
+func (p *probeWriter) Write(b []byte) (int, error) {
+	if p.status == 0 {
+		p.status = http.StatusOK
+	}
+	return len(b), nil
+}
+
+func (p *probeWriter) WriteHeader(code int) {
+	if p.status == 0 {
+		p.status = code
+	}
+}
+
+type routeKey struct{}
+
+// routeHolder carries the matched pattern back out of the router. Middleware
+// that wraps the router cannot read r.Pattern because inner middleware may
+// replace the request with WithContext.
+type routeHolder struct{ pattern string }
+
+// trackRoute returns r with a holder the Router fills in with the matched pattern.
+func trackRoute(r *http.Request) (*http.Request, *routeHolder) {
+	if rh, ok := r.Context().Value(routeKey{}).(*routeHolder); ok {
+		return r, rh
+	}
+	rh := &routeHolder{}
+	return r.WithContext(context.WithValue(r.Context(), routeKey{}, rh)), rh
+}
+
+// routeLabel returns the pattern for use as a low-cardinality label.
+func routeLabel(rh *routeHolder) string {
+	if rh.pattern == "" {
+		return "unmatched"
+	}
+	return rh.pattern
+}
@@ -0,0 +1,45 @@
+package httpx
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"fgo/internal/observe"
+)
+
+// Trace starts a server span per request, continuing the trace from an
+// incoming W3C traceparent header. The span is named after the matched route
+// and its trace_id is attached to the request's log lines.
+func Trace(t *observe.Tracer) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			if sc, ok := observe.ParseTraceparent(r.Header.Get("traceparent")); ok {
+				ctx = observe.WithRemoteParent(ctx, sc)
+			}
+			ctx, span := t.Start(ctx, r.Method, observe.SpanKindServer,
+				slog.String("http.request.method", r.Method),
+				slog.String("url.path", r.URL.Path),
+			)
+			if span == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			defer span.Finish()
+			observe.AddLogAttrs(ctx, slog.String("trace_id", span.TraceID.String()))
+			r, rh := trackRoute(r.WithContext(ctx))
+			rw := &statusWriter{ResponseWriter: w, status: 200}
+			next.ServeHTTP(rw, r)
+
+			if rh.pattern != "" {
+				span.SetName(rh.pattern)
+				span.SetAttrs(slog.String("http.route", rh.pattern))
+			}
+			span.SetAttrs(slog.Int("http.response.status_code", rw.status))
+			if rw.status >= 500 {
+				span.RecordError(errors.New(http.StatusText(rw.status)))
+			}
+		})
+	}
+}
@@ -1,3 +1,47 @@
+// Package integrity verifies blob content against its declared digest while
+// it streams, so a store never has to buffer a blob to check it.
 package integrity
 
-// TODO: Implement checksums, MIME, antivirus hooks (optional)
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+)
+
+// TODO: MIME sniffing and antivirus hooks.
+
+// EmptySHA256 is the digest of zero bytes.
+const EmptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// ErrDigestMismatch is returned by a Reader whose bytes do not hash to the expected digest.
+var ErrDigestMismatch = errors.New("digest mismatch")
+
+// Reader yields exactly size bytes of an underlying reader. If the content
+// does not hash to the expected SHA-256, the read that would deliver the last
+// byte returns no data and ErrDigestMismatch, so callers such as
+// BlobStore.Put that copy exactly size bytes (io.CopyN ignores errors once it
+// has all of them) see a short copy and never commit the blob.
+// A zero-size Reader never fails; compare the digest with EmptySHA256.
+type Reader struct {
+	r    io.Reader
+	h    hash.Hash
+	want string
+	left int64
+}
+
+// NewReader verifies the next size bytes of r against sha (lowercase hex).
+func NewReader(r io.Reader, size int64, sha string) *Reader {
+	return &Reader{r: io.LimitReader(r, size), h: sha256.New(), want: sha, left: size}
+}
+
+func (v *Reader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.h.Write(p[:n])
+	v.left -= int64(n)
+	if v.left == 0 && n > 0 && hex.EncodeToString(v.h.Sum(nil)) != v.want {
+		return 0, ErrDigestMismatch
+	}
+	return n, err
+}
@@ -0,0 +1,114 @@
+package observe
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+)
+
+// NewLogger returns a slog logger writing level-filtered records to w in the
+// given format ("json" or "text"). Every record logged with a context carries
+// the attributes added to that context with AddLogAttrs.
+func NewLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("log level: %w", err)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	var h slog.Handler
+	switch format {
+	case "json", "":
+		h = slog.NewJSONHandler(w, opts)
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("log format %q: must be json or text", format)
+	}
+	return slog.New(contextHandler{h}), nil
+}
+
+type (
+	logAttrsKey  struct{}
+	requestIDKey struct{}
+)
+
+// WithRequestID returns ctx carrying the request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID stored in ctx, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// logAttrs is a mutable attribute bag shared by everything handling one
+// request, so IDs learned deep in a handler also appear on the access log line.
+type logAttrs struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+// WithLogAttrs returns ctx carrying a fresh attribute bag seeded with attrs.
+// If ctx already has a bag, attrs are added to it and ctx is returned as is.
+func WithLogAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	if la, ok := ctx.Value(logAttrsKey{}).(*logAttrs); ok {
+		la.add(attrs)
+		return ctx
+	}
+	la := &logAttrs{}
+	la.add(attrs)
+	return context.WithValue(ctx, logAttrsKey{}, la)
+}
+
+// AddLogAttrs adds attrs to the bag in ctx, replacing attributes with the same
+// key. It does nothing if ctx has no bag.
+func AddLogAttrs(ctx context.Context, attrs ...slog.Attr) {
+	if la, ok := ctx.Value(logAttrsKey{}).(*logAttrs); ok {
+		la.add(attrs)
+	}
+}
+
+func (la *logAttrs) add(attrs []slog.Attr) {
+	la.mu.Lock()
+	defer la.mu.Unlock()
+next:
+	for _, a := range attrs {
+		for i := range la.attrs {
+			if la.attrs[i].Key == a.Key {
+				la.attrs[i] = a
+				continue next
+			}
+		}
+		la.attrs = append(la.attrs, a)
+	}
+}
+
+func (la *logAttrs) snapshot() []slog.Attr {
+	la.mu.Lock()
+	defer la.mu.Unlock()
+	return append([]slog.Attr(nil), la.attrs...)
+}
+
+// contextHandler adds the context attribute bag to each record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if la, ok := ctx.Value(logAttrsKey{}).(*logAttrs); ok {
+		r.AddAttrs(la.snapshot()...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
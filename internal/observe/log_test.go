@@ -0,0 +1,39 @@
+package observe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestLoggerAddsContextAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := NewLogger(&buf, "info", "json")
+	if err != nil {
+		t.Fatalf("new logger: %v", err)
+	}
+	ctx := WithLogAttrs(context.Background(), slog.String("request_id", "r1"))
+	AddLogAttrs(ctx, slog.String("box_id", "b1"))
+	AddLogAttrs(ctx, slog.String("box_id", "b2"))
+	logger.DebugContext(ctx, "hidden")
+	logger.InfoContext(ctx, "hello")
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected a single JSON line, got %q: %v", buf.String(), err)
+	}
+	if line["msg"] != "hello" || line["request_id"] != "r1" || line["box_id"] != "b2" {
+		t.Fatalf("unexpected log line: %v", line)
+	}
+}
+
+func TestNewLoggerRejectsBadConfig(t *testing.T) {
+	if _, err := NewLogger(nil, "loud", "json"); err == nil {
+		t.Fatal("expected error for bad level")
+	}
+	if _, err := NewLogger(nil, "info", "xml"); err == nil {
+		t.Fatal("expected error for bad format")
+	}
+}
@@ -0,0 +1,48 @@
+package observe
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistryWriteText(t *testing.T) {
+	reg := NewRegistry()
+	c := reg.Counter("requests_total", "Requests.", "route")
+	c.Inc(`GET /a "b"`)
+	c.Add(2, `GET /a "b"`)
+	if reg.Counter("requests_total", "Requests.", "route") != c {
+		t.Fatal("expected re-registration to return the existing counter")
+	}
+	h := reg.Histogram("latency_seconds", "Latency.", []float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+
+	var b strings.Builder
+	if err := reg.WriteText(&b); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	want := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 2
+latency_seconds_sum 0.55
+latency_seconds_count 2
+# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{route="GET /a \"b\""} 3
+`
+	if b.String() != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestNilMetricsAreNoops(t *testing.T) {
+	var c *CounterVec
+	var h *HistogramVec
+	c.Inc("x")
+	h.Observe(1, "x")
+	if c.Value("x") != 0 {
+		t.Fatal("expected nil counter to read 0")
+	}
+}
@@ -1,3 +1,6 @@
+// Package observe provides gofile's observability primitives: Prometheus
+// metrics in the text exposition format, structured slog logging with
+// per-request attributes carried in the context, and tracing spans exported
+// over OTLP/HTTP with W3C traceparent propagation. It has no external
+// dependencies.
 package observe
-
-// TODO: Implement logs, metrics, tracing
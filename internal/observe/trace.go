@@ -0,0 +1,327 @@
+package observe
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SpanKind mirrors the OTLP span kinds used by gofile.
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+func (id TraceID) IsValid() bool  { return id != TraceID{} }
+func (id SpanID) IsValid() bool   { return id != SpanID{} }
+
+// SpanContext identifies a span across process boundaries.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool { return sc.TraceID.IsValid() && sc.SpanID.IsValid() }
+
+// Traceparent formats sc as a W3C traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent parses a W3C traceparent header value.
+func ParseTraceparent(h string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+	// Version 00 has exactly four fields; later versions may append more.
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, false
+	}
+	var sc SpanContext
+	var flags [1]byte
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+// Span is one timed operation. A nil *Span ignores all calls, so callers
+// never need to check whether tracing is enabled.
+type Span struct {
+	tracer *Tracer
+
+	SpanContext
+	Parent SpanID
+	Name   string
+	Kind   SpanKind
+	Start  time.Time
+	End    time.Time
+
+	mu    sync.Mutex
+	Attrs []slog.Attr
+	Err   string
+	ended bool
+}
+
+// SetName renames the span, e.g. once the HTTP route is known.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.Name = name
+	s.mu.Unlock()
+}
+
+// SetAttrs adds attributes to the span.
+func (s *Span) SetAttrs(attrs ...slog.Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.Attrs = append(s.Attrs, attrs...)
+	s.mu.Unlock()
+}
+
+// RecordError marks the span as failed if err is non-nil.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.Err = err.Error()
+	s.mu.Unlock()
+}
+
+// Finish ends the span and queues it for export. Later calls do nothing.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mu.Unlock()
+	if s.Sampled {
+		s.tracer.enqueue(s)
+	}
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// SpanFromContext returns the active span in ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// WithRemoteParent records a parent span received from another process
+// (e.g. a traceparent header) for the next span started from ctx.
+func WithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// Exporter sends finished spans to a tracing backend.
+type Exporter interface {
+	Export(ctx context.Context, spans []*Span) error
+}
+
+// TracerOptions tune batching and sampling. Zero values pick defaults.
+type TracerOptions struct {
+	// SampleRatio is the fraction of new traces recorded (0..1). Spans with
+	// a remote parent follow the parent's sampled flag.
+	SampleRatio   float64
+	BatchSize     int
+	FlushInterval time.Duration
+	QueueSize     int
+}
+
+// Tracer creates spans and exports them in batches from a background
+// goroutine. A nil *Tracer creates no spans.
+type Tracer struct {
+	exp  Exporter
+	opts TracerOptions
+
+	queue chan *Span
+	flush chan chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+	once  sync.Once
+}
+
+func NewTracer(exp Exporter, opts TracerOptions) *Tracer {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 512
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 5 * time.Second
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 4 * opts.BatchSize
+	}
+	t := &Tracer{
+		exp:   exp,
+		opts:  opts,
+		queue: make(chan *Span, opts.QueueSize),
+		flush: make(chan chan struct{}),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Start begins a span named name as a child of the span (or remote parent)
+// in ctx and returns a context carrying it. Call Finish on the span.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind, attrs ...slog.Attr) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	s := &Span{tracer: t, Name: name, Kind: kind, Start: time.Now(), Attrs: attrs}
+	if parent := SpanFromContext(ctx); parent != nil {
+		s.TraceID, s.Parent, s.Sampled = parent.TraceID, parent.SpanID, parent.Sampled
+	} else if remote, ok := ctx.Value(remoteKey{}).(SpanContext); ok && remote.IsValid() {
+		s.TraceID, s.Parent, s.Sampled = remote.TraceID, remote.SpanID, remote.Sampled
+	} else {
+		_, _ = rand.Read(s.TraceID[:])
+		s.Sampled = t.sample(s.TraceID)
+	}
+	_, _ = rand.Read(s.SpanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// sample decides from the trace ID so every service makes the same choice.
+func (t *Tracer) sample(id TraceID) bool {
+	if t.opts.SampleRatio >= 1 {
+		return true
+	}
+	if t.opts.SampleRatio <= 0 {
+		return false
+	}
+	return float64(binary.BigEndian.Uint64(id[8:])>>11)/(1<<53) < t.opts.SampleRatio
+}
+
+func (t *Tracer) enqueue(s *Span) {
+	select {
+	case t.queue <- s:
+	default:
+		// Never block a request on the exporter; drop when the queue is full.
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+	tick := time.NewTicker(t.opts.FlushInterval)
+	defer tick.Stop()
+	batch := make([]*Span, 0, t.opts.BatchSize)
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := t.exp.Export(ctx, batch); err != nil {
+			slog.Warn("trace export failed", "spans", len(batch), "error", err)
+		}
+		cancel()
+		batch = make([]*Span, 0, t.opts.BatchSize)
+	}
+	drain := func() {
+		for {
+			select {
+			case s := <-t.queue:
+				batch = append(batch, s)
+			default:
+				return
+			}
+		}
+	}
+	for {
+		select {
+		case s := <-t.queue:
+			batch = append(batch, s)
+			if len(batch) >= t.opts.BatchSize {
+				export()
+			}
+		case ack := <-t.flush:
+			drain()
+			export()
+			close(ack)
+		case <-t.stop:
+			drain()
+			export()
+			return
+		case <-tick.C:
+			export()
+		}
+	}
+}
+
+// Flush exports all finished spans and waits until the export returns.
+func (t *Tracer) Flush(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	ack := make(chan struct{})
+	select {
+	case t.flush <- ack:
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-ack:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown exports remaining spans and stops the tracer. Spans finished
+// afterwards are dropped.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.once.Do(func() { close(t.stop) })
+	select {
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
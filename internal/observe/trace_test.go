@@ -0,0 +1,92 @@
+package observe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	sc, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if !ok || !sc.Sampled || sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+		t.Fatalf("unexpected parse: %+v ok=%v", sc, ok)
+	}
+	if got := sc.Traceparent(); got != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Fatalf("round trip: %s", got)
+	}
+	for _, bad := range []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-xyz92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		if _, ok := ParseTraceparent(bad); ok {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestTracerExportsOTLP(t *testing.T) {
+	got := make(chan otlpRequest, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("X-Token") != "t" {
+			t.Errorf("unexpected request %s headers %v", r.URL.Path, r.Header)
+		}
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		got <- req
+	}))
+	defer collector.Close()
+
+	tr := NewTracer(NewOTLPExporter(collector.URL, "svc", map[string]string{"X-Token": "t"}, time.Second), TracerOptions{})
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, root := tr.Start(WithRemoteParent(context.Background(), remote), "GET", SpanKindServer)
+	_, child := tr.Start(ctx, "blobstore.Has", SpanKindInternal, slog.String("blob.sha256", "abc"), slog.Int("n", 3))
+	child.RecordError(errors.New("disk"))
+	child.Finish()
+	root.Finish()
+	if err := tr.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	req := <-got
+	rs := req.ResourceSpans[0]
+	if v := rs.Resource.Attributes[0].Value.StringValue; v == nil || *v != "svc" {
+		t.Fatalf("expected service.name svc, got %+v", rs.Resource.Attributes)
+	}
+	spans := rs.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	c, p := spans[0], spans[1]
+	if p.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || p.ParentSpanID != "00f067aa0ba902b7" || p.Kind != int(SpanKindServer) {
+		t.Fatalf("root span not linked to remote parent: %+v", p)
+	}
+	if c.TraceID != p.TraceID || c.ParentSpanID != p.SpanID {
+		t.Fatalf("child not linked to root: %+v", c)
+	}
+	if c.Status.Code != otlpStatusError || c.Status.Message != "disk" {
+		t.Fatalf("expected error status, got %+v", c.Status)
+	}
+	if len(c.Attributes) != 2 || c.Attributes[1].Value.IntValue == nil || *c.Attributes[1].Value.IntValue != "3" {
+		t.Fatalf("unexpected attributes: %+v", c.Attributes)
+	}
+}
+
+func TestNilTracerIsNoop(t *testing.T) {
+	var tr *Tracer
+	ctx, span := tr.Start(context.Background(), "x", SpanKindInternal)
+	span.SetAttrs(slog.String("a", "b"))
+	span.Finish()
+	if SpanFromContext(ctx) != nil {
+		t.Fatal("expected no span")
+	}
+}
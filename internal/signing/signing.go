@@ -0,0 +1,56 @@
+// Package signing verifies detached signatures over commit payloads. This is
+// not OpenPGP: keys are raw ed25519 public keys registered by name, and a
+// signature is "<key name>:<base64 ed25519 signature>" over the exact payload
+// bytes, with no armor, hashing or packet framing.
+package signing
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrBadSignature is returned for a signature that is malformed, made by an
+// unknown key or does not match the payload.
+var ErrBadSignature = errors.New("bad signature")
+
+// Verifier checks a detached signature over payload and returns the name of
+// the key that made it.
+type Verifier interface {
+	Verify(payload []byte, signature string) (string, error)
+}
+
+// Keyring is a Verifier for signatures of the form "<key>:<base64 sig>".
+type Keyring map[string]ed25519.PublicKey
+
+// ParseKey decodes a base64 ed25519 public key.
+func ParseKey(s string) (ed25519.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("signing: not a base64 ed25519 public key")
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+// Sign returns the detached signature of payload made by priv under key's name.
+func Sign(key string, priv ed25519.PrivateKey, payload []byte) string {
+	return key + ":" + base64.StdEncoding.EncodeToString(ed25519.Sign(priv, payload))
+}
+
+func (k Keyring) Verify(payload []byte, signature string) (string, error) {
+	name, sig, ok := strings.Cut(signature, ":")
+	if !ok {
+		return "", fmt.Errorf("%w: want <key>:<base64 signature>", ErrBadSignature)
+	}
+	pub, ok := k[name]
+	if !ok {
+		return "", fmt.Errorf("%w: unknown key %q", ErrBadSignature, name)
+	}
+	b, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil || !ed25519.Verify(pub, payload, b) {
+		return "", fmt.Errorf("%w: not made by key %q", ErrBadSignature, name)
+	}
+	return name, nil
+}
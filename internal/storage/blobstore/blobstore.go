@@ -2,9 +2,18 @@ package blobstore
 
 import (
 	"context"
+	"errors"
 	"io"
 )
 
+var (
+	// ErrNotFound is returned by Open when the blob does not exist.
+	ErrNotFound = errors.New("blob not found")
+	// ErrInvalidDigest is returned for a digest that cannot name a blob, such
+	// as one that is empty or would reach outside the store.
+	ErrInvalidDigest = errors.New("invalid blob digest")
+)
+
 type BlobStore interface {
 	Has(ctx context.Context, sha string) (bool, error)
 	Put(ctx context.Context, sha string, r io.Reader, size int64) error
@@ -5,19 +5,34 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 )
 
 type BlobStoreFS struct {
-	root string
+	root        string
+	uploadLocks sync.Map // upload id -> *sync.Mutex
 }
 
 func NewBlobStoreFS(root string) *BlobStoreFS {
 	return &BlobStoreFS{root: root}
 }
 
+// blobPath returns where blob sha is stored. Digests are joined onto the
+// root, so anything that could be read as a path of its own is refused.
+func (b *BlobStoreFS) blobPath(sha string) (string, error) {
+	if sha == "" || sha == "." || strings.Contains(sha, "..") || strings.ContainsAny(sha, `/\`) {
+		return "", ErrInvalidDigest
+	}
+	return filepath.Join(b.root, sha), nil
+}
+
 func (b *BlobStoreFS) Has(ctx context.Context, sha string) (bool, error) {
-	path := filepath.Join(b.root, sha)
-	_, err := os.Stat(path)
+	path, err := b.blobPath(sha)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(path)
 	if err == nil {
 		return true, nil
 	}
@@ -27,21 +42,44 @@ func (b *BlobStoreFS) Has(ctx context.Context, sha string) (bool, error) {
 	return false, err
 }
 
+// Put writes the blob to a temp file and renames it into place once all size
+// bytes arrived, so an interrupted upload never leaves a partial blob behind.
 func (b *BlobStoreFS) Put(ctx context.Context, sha string, r io.Reader, size int64) error {
-	path := filepath.Join(b.root, sha)
-	f, err := os.Create(path)
+	path, err := b.blobPath(sha)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	_, err = io.CopyN(f, r, size)
-	return err
+	f, err := os.CreateTemp(b.root, sha+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if _, err := io.CopyN(f, r, size); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
 }
 
 func (b *BlobStoreFS) Open(ctx context.Context, sha string) (io.ReadCloser, int64, error) {
-	path := filepath.Join(b.root, sha)
+	path, err := b.blobPath(sha)
+	if err != nil {
+		return nil, 0, err
+	}
 	f, err := os.Open(path)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, 0, ErrNotFound
+		}
 		return nil, 0, err
 	}
 	info, err := f.Stat()
@@ -0,0 +1,138 @@
+package blobstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPutIsAtomic(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	b := NewBlobStoreFS(root)
+
+	// A body shorter than the declared size must not leave a blob behind.
+	if err := b.Put(ctx, "short", strings.NewReader("ab"), 3); err == nil {
+		t.Fatal("expected error for truncated body")
+	}
+	if ok, _ := b.Has(ctx, "short"); ok {
+		t.Fatal("truncated upload left a blob")
+	}
+	if files, _ := os.ReadDir(root); len(files) != 0 {
+		t.Fatalf("expected no files, found %d", len(files))
+	}
+
+	if err := b.Put(ctx, "full", strings.NewReader("abc"), 3); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	rc, size, err := b.Open(ctx, "full")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer rc.Close()
+	data, _ := io.ReadAll(rc)
+	if size != 3 || string(data) != "abc" {
+		t.Fatalf("unexpected blob %q (%d)", data, size)
+	}
+	if _, _, err := b.Open(ctx, "missing"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestResumableUploadFS(t *testing.T) {
+	ctx := context.Background()
+	b := NewBlobStoreFS(t.TempDir())
+	data := "hello, resumable world"
+	sum := sha256.Sum256([]byte(data))
+	sha := hex.EncodeToString(sum[:])
+
+	up, err := b.CreateUpload(ctx, sha, int64(len(data)), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if up, err = b.WriteUpload(ctx, up.ID, 0, strings.NewReader(data[:5])); err != nil || up.Offset != 5 {
+		t.Fatalf("write: %v offset %d", err, up.Offset)
+	}
+	if _, err := b.WriteUpload(ctx, up.ID, 0, strings.NewReader(data)); !errors.Is(err, ErrOffsetMismatch) {
+		t.Fatalf("expected ErrOffsetMismatch, got %v", err)
+	}
+	if err := b.CompleteUpload(ctx, up.ID); !errors.Is(err, ErrUploadIncomplete) {
+		t.Fatalf("expected ErrUploadIncomplete, got %v", err)
+	}
+	// Bytes past the declared size are never read.
+	if up, err = b.WriteUpload(ctx, up.ID, 5, strings.NewReader(data[5:]+"extra")); err != nil || up.Offset != up.Size {
+		t.Fatalf("write rest: %v offset %d", err, up.Offset)
+	}
+	if err := b.CompleteUpload(ctx, up.ID); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if ok, _ := b.Has(ctx, sha); !ok {
+		t.Fatal("completed upload not visible")
+	}
+	if _, err := b.GetUpload(ctx, up.ID); !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("session survived completion: %v", err)
+	}
+
+	bad, _ := b.CreateUpload(ctx, sha, 3, time.Now().Add(time.Hour))
+	_, _ = b.WriteUpload(ctx, bad.ID, 0, strings.NewReader("abc"))
+	if err := b.CompleteUpload(ctx, bad.ID); !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("expected ErrDigestMismatch, got %v", err)
+	}
+
+	stale, _ := b.CreateUpload(ctx, sha, 3, time.Now().Add(time.Minute))
+	if n, err := b.ExpireUploads(ctx, time.Now()); err != nil || n != 0 {
+		t.Fatalf("expire before deadline: %d %v", n, err)
+	}
+	if n, err := b.ExpireUploads(ctx, time.Now().Add(2*time.Minute)); err != nil || n != 1 {
+		t.Fatalf("expire after deadline: %d %v", n, err)
+	}
+	if _, err := b.GetUpload(ctx, stale.ID); !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("expired session still present: %v", err)
+	}
+}
+
+func TestUnknownUploadLeavesNoLock(t *testing.T) {
+	ctx := context.Background()
+	b := NewBlobStoreFS(t.TempDir())
+	for _, id := range []string{"not-hex", strings.Repeat("ab", 16)} {
+		if _, err := b.GetUpload(ctx, id); !errors.Is(err, ErrUploadNotFound) {
+			t.Fatalf("GetUpload(%q): expected ErrUploadNotFound, got %v", id, err)
+		}
+		if _, err := b.WriteUpload(ctx, id, 0, strings.NewReader("x")); !errors.Is(err, ErrUploadNotFound) {
+			t.Fatalf("WriteUpload(%q): expected ErrUploadNotFound, got %v", id, err)
+		}
+		if err := b.AbortUpload(ctx, id); !errors.Is(err, ErrUploadNotFound) {
+			t.Fatalf("AbortUpload(%q): expected ErrUploadNotFound, got %v", id, err)
+		}
+	}
+	up, _ := b.CreateUpload(ctx, strings.Repeat("0", 64), 1, time.Now().Add(time.Hour))
+	if err := b.AbortUpload(ctx, up.ID); err != nil {
+		t.Fatalf("abort: %v", err)
+	}
+	b.uploadLocks.Range(func(k, _ any) bool {
+		t.Errorf("lock left behind for %v", k)
+		return true
+	})
+}
+
+func TestDigestsArePlainNames(t *testing.T) {
+	ctx := context.Background()
+	b := NewBlobStoreFS(t.TempDir())
+	for _, sha := range []string{"", ".", "..", "../secret", "a/b", `a\b`} {
+		if _, err := b.Has(ctx, sha); !errors.Is(err, ErrInvalidDigest) {
+			t.Errorf("Has(%q): expected ErrInvalidDigest, got %v", sha, err)
+		}
+		if _, _, err := b.Open(ctx, sha); !errors.Is(err, ErrInvalidDigest) {
+			t.Errorf("Open(%q): expected ErrInvalidDigest, got %v", sha, err)
+		}
+		if err := b.Put(ctx, sha, strings.NewReader("x"), 1); !errors.Is(err, ErrInvalidDigest) {
+			t.Errorf("Put(%q): expected ErrInvalidDigest, got %v", sha, err)
+		}
+	}
+}
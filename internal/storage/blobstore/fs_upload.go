@@ -0,0 +1,249 @@
+package blobstore
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// uploadDir holds in-progress sessions as <id>.json (metadata) and <id>.part
+// (bytes received so far). The dot keeps it apart from blob names.
+const uploadDir = ".uploads"
+
+var _ Uploader = (*BlobStoreFS)(nil)
+
+func (b *BlobStoreFS) uploadPath(id, ext string) string {
+	return filepath.Join(b.root, uploadDir, id+ext)
+}
+
+// lockUpload serializes operations on one session. Only sessions that exist
+// get a lock, so unknown IDs sent by clients leave nothing behind in
+// uploadLocks.
+func (b *BlobStoreFS) lockUpload(id string) (func(), error) {
+	if !validUploadID(id) {
+		return nil, ErrUploadNotFound
+	}
+	if err := b.uploadExists(id); err != nil {
+		return nil, err
+	}
+	v, _ := b.uploadLocks.LoadOrStore(id, new(sync.Mutex))
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	if err := b.uploadExists(id); err != nil {
+		// Removed while we waited for the lock.
+		b.uploadLocks.CompareAndDelete(id, mu)
+		mu.Unlock()
+		return nil, err
+	}
+	return mu.Unlock, nil
+}
+
+// uploadExists returns ErrUploadNotFound if the session has no metadata.
+func (b *BlobStoreFS) uploadExists(id string) error {
+	_, err := os.Stat(b.uploadPath(id, ".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrUploadNotFound
+	}
+	return err
+}
+
+func (b *BlobStoreFS) CreateUpload(ctx context.Context, sha string, size int64, expires time.Time) (Upload, error) {
+	if err := os.MkdirAll(filepath.Join(b.root, uploadDir), 0o755); err != nil {
+		return Upload{}, err
+	}
+	var raw [16]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return Upload{}, err
+	}
+	up := Upload{
+		ID:        hex.EncodeToString(raw[:]),
+		SHA256:    sha,
+		Size:      size,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expires.UTC(),
+	}
+	data, err := json.Marshal(up)
+	if err != nil {
+		return Upload{}, err
+	}
+	if err := os.WriteFile(b.uploadPath(up.ID, ".json"), data, 0o644); err != nil {
+		return Upload{}, err
+	}
+	return up, nil
+}
+
+func (b *BlobStoreFS) GetUpload(ctx context.Context, id string) (Upload, error) {
+	unlock, err := b.lockUpload(id)
+	if err != nil {
+		return Upload{}, err
+	}
+	defer unlock()
+	return b.loadUpload(id, time.Now())
+}
+
+// loadUpload reads a session's metadata and current offset; the caller holds its lock.
+func (b *BlobStoreFS) loadUpload(id string, now time.Time) (Upload, error) {
+	if !validUploadID(id) {
+		return Upload{}, ErrUploadNotFound
+	}
+	data, err := os.ReadFile(b.uploadPath(id, ".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Upload{}, ErrUploadNotFound
+	}
+	if err != nil {
+		return Upload{}, err
+	}
+	var up Upload
+	if err := json.Unmarshal(data, &up); err != nil {
+		return Upload{}, err
+	}
+	if now.After(up.ExpiresAt) {
+		return Upload{}, ErrUploadNotFound
+	}
+	info, err := os.Stat(b.uploadPath(id, ".part"))
+	switch {
+	case err == nil:
+		up.Offset = info.Size()
+	case !errors.Is(err, fs.ErrNotExist):
+		return Upload{}, err
+	}
+	return up, nil
+}
+
+func (b *BlobStoreFS) WriteUpload(ctx context.Context, id string, offset int64, r io.Reader) (Upload, error) {
+	unlock, err := b.lockUpload(id)
+	if err != nil {
+		return Upload{}, err
+	}
+	defer unlock()
+	up, err := b.loadUpload(id, time.Now())
+	if err != nil {
+		return Upload{}, err
+	}
+	if offset != up.Offset {
+		return up, ErrOffsetMismatch
+	}
+	f, err := os.OpenFile(b.uploadPath(id, ".part"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return up, err
+	}
+	n, err := io.Copy(f, io.LimitReader(r, up.Size-up.Offset))
+	up.Offset += n
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return up, err
+}
+
+func (b *BlobStoreFS) CompleteUpload(ctx context.Context, id string) error {
+	unlock, err := b.lockUpload(id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	up, err := b.loadUpload(id, time.Now())
+	if err != nil {
+		return err
+	}
+	if up.Offset != up.Size {
+		return ErrUploadIncomplete
+	}
+	part := b.uploadPath(id, ".part")
+	if up.Size == 0 {
+		// No chunk was ever written; an empty blob needs an empty file.
+		if err := os.WriteFile(part, nil, 0o644); err != nil {
+			return err
+		}
+	}
+	f, err := os.Open(part)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(hex.EncodeToString(h.Sum(nil)), up.SHA256) {
+		b.removeUpload(id)
+		return ErrDigestMismatch
+	}
+	dst, err := b.blobPath(up.SHA256)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(part, dst); err != nil {
+		return err
+	}
+	b.removeUpload(id)
+	return nil
+}
+
+func (b *BlobStoreFS) AbortUpload(ctx context.Context, id string) error {
+	unlock, err := b.lockUpload(id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if _, err := b.loadUpload(id, time.Now()); err != nil {
+		return err
+	}
+	b.removeUpload(id)
+	return nil
+}
+
+func (b *BlobStoreFS) ExpireUploads(ctx context.Context, now time.Time) (int, error) {
+	entries, err := os.ReadDir(filepath.Join(b.root, uploadDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	expired := 0
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || !validUploadID(id) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return expired, err
+		}
+		unlock, err := b.lockUpload(id)
+		if err != nil {
+			continue
+		}
+		if _, err := b.loadUpload(id, now); errors.Is(err, ErrUploadNotFound) {
+			b.removeUpload(id)
+			expired++
+		}
+		unlock()
+	}
+	return expired, nil
+}
+
+// removeUpload deletes a session's files; the caller holds its lock.
+func (b *BlobStoreFS) removeUpload(id string) {
+	os.Remove(b.uploadPath(id, ".part"))
+	os.Remove(b.uploadPath(id, ".json"))
+	b.uploadLocks.Delete(id)
+}
+
+func validUploadID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
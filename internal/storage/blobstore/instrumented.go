@@ -0,0 +1,78 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"fgo/internal/observe"
+)
+
+// instrumented records blob store metrics around another BlobStore.
+type instrumented struct {
+	BlobStore
+	ops      *observe.CounterVec
+	putBytes *observe.CounterVec
+	putTime  *observe.HistogramVec
+}
+
+// Instrument wraps b so its operations are counted in reg:
+// blob_ops_total{op,result}, blob_put_bytes_total and blob_put_duration_seconds.
+func Instrument(b BlobStore, reg *observe.Registry) BlobStore {
+	return &instrumented{
+		BlobStore: b,
+		ops:       reg.Counter("blob_ops_total", "Blob store operations by op and result.", "op", "result"),
+		putBytes:  reg.Counter("blob_put_bytes_total", "Bytes written to the blob store."),
+		putTime:   reg.Histogram("blob_put_duration_seconds", "Blob store write latency in seconds.", observe.DefBuckets),
+	}
+}
+
+func (s *instrumented) Has(ctx context.Context, sha string) (bool, error) {
+	ok, err := s.BlobStore.Has(ctx, sha)
+	s.ops.Inc("has", result(err))
+	return ok, err
+}
+
+func (s *instrumented) Put(ctx context.Context, sha string, r io.Reader, size int64) error {
+	start := time.Now()
+	cr := &countingReader{r: r}
+	err := s.BlobStore.Put(ctx, sha, cr, size)
+	s.ops.Inc("put", result(err))
+	if err == nil {
+		s.putBytes.Add(float64(cr.n))
+		s.putTime.Observe(time.Since(start).Seconds())
+	}
+	return err
+}
+
+func (s *instrumented) Open(ctx context.Context, sha string) (io.ReadCloser, int64, error) {
+	rc, size, err := s.BlobStore.Open(ctx, sha)
+	s.ops.Inc("open", result(err))
+	return rc, size, err
+}
+
+func result(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, ErrNotFound):
+		return "not_found"
+	}
+	return "error"
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Unwrap returns the wrapped store so optional interfaces such as Uploader
+// stay reachable.
+func (s *instrumented) Unwrap() BlobStore { return s.BlobStore }
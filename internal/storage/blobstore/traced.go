@@ -0,0 +1,52 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+
+	"fgo/internal/observe"
+)
+
+// traced wraps each BlobStore call in a child span.
+type traced struct {
+	BlobStore
+	t *observe.Tracer
+}
+
+// Trace wraps b so Has, Put and Open each record a span named
+// "blobstore.<Op>" under the span in the call's context.
+func Trace(b BlobStore, t *observe.Tracer) BlobStore {
+	return &traced{BlobStore: b, t: t}
+}
+
+func (s *traced) Has(ctx context.Context, sha string) (bool, error) {
+	ctx, span := s.t.Start(ctx, "blobstore.Has", observe.SpanKindInternal, slog.String("blob.sha256", sha))
+	defer span.Finish()
+	ok, err := s.BlobStore.Has(ctx, sha)
+	span.RecordError(err)
+	return ok, err
+}
+
+func (s *traced) Put(ctx context.Context, sha string, r io.Reader, size int64) error {
+	ctx, span := s.t.Start(ctx, "blobstore.Put", observe.SpanKindInternal,
+		slog.String("blob.sha256", sha), slog.Int64("blob.size", size))
+	defer span.Finish()
+	err := s.BlobStore.Put(ctx, sha, r, size)
+	span.RecordError(err)
+	return err
+}
+
+func (s *traced) Open(ctx context.Context, sha string) (io.ReadCloser, int64, error) {
+	ctx, span := s.t.Start(ctx, "blobstore.Open", observe.SpanKindInternal, slog.String("blob.sha256", sha))
+	defer span.Finish()
+	rc, size, err := s.BlobStore.Open(ctx, sha)
+	if !errors.Is(err, ErrNotFound) {
+		span.RecordError(err)
+	}
+	return rc, size, err
+}
+
+// Unwrap exposes the underlying store to AsUploader.
+func (s *traced) Unwrap() BlobStore { return s.BlobStore }
@@ -0,0 +1,67 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	// ErrUploadNotFound is returned for unknown, completed or expired upload sessions.
+	ErrUploadNotFound = errors.New("upload session not found")
+	// ErrOffsetMismatch is returned when a chunk does not start at the session's current offset.
+	ErrOffsetMismatch = errors.New("upload offset mismatch")
+	// ErrUploadIncomplete is returned when completing a session before all bytes arrived.
+	ErrUploadIncomplete = errors.New("upload incomplete")
+	// ErrDigestMismatch is returned when the uploaded bytes do not hash to the declared SHA-256.
+	ErrDigestMismatch = errors.New("blob digest mismatch")
+)
+
+// Upload is the state of a resumable upload session.
+type Upload struct {
+	ID        string    `json:"id"`
+	SHA256    string    `json:"sha256"`
+	Size      int64     `json:"size"`
+	Offset    int64     `json:"offset"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Uploader is implemented by blob stores that can receive a blob in chunks
+// across several requests. Bytes written to a session survive disconnects;
+// the blob only becomes visible to Has and Open once CompleteUpload verified
+// its digest. Stores without it only support single Put calls.
+type Uploader interface {
+	// CreateUpload starts a session for size bytes hashing to sha.
+	CreateUpload(ctx context.Context, sha string, size int64, expires time.Time) (Upload, error)
+	// GetUpload returns the session's current state.
+	GetUpload(ctx context.Context, id string) (Upload, error)
+	// WriteUpload appends r at offset, which must equal the session's current
+	// offset. Nothing past the declared size is read. Whatever arrived before
+	// an error is kept and reflected in the returned offset.
+	WriteUpload(ctx context.Context, id string, offset int64, r io.Reader) (Upload, error)
+	// CompleteUpload verifies the digest and moves the blob into place. A
+	// session whose bytes fail verification is discarded.
+	CompleteUpload(ctx context.Context, id string) error
+	// AbortUpload discards a session and its data.
+	AbortUpload(ctx context.Context, id string) error
+	// ExpireUploads removes sessions that expired before now and reports how many.
+	ExpireUploads(ctx context.Context, now time.Time) (int, error)
+}
+
+// AsUploader returns b's Uploader, looking through wrappers that provide
+// Unwrap() BlobStore.
+func AsUploader(b BlobStore) (Uploader, bool) {
+	for b != nil {
+		if u, ok := b.(Uploader); ok {
+			return u, true
+		}
+		w, ok := b.(interface{ Unwrap() BlobStore })
+		if !ok {
+			break
+		}
+		b = w.Unwrap()
+	}
+	return nil, false
+}
@@ -2,6 +2,21 @@ package metastore
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+)
+
+var (
+	// ErrNotFound is returned when a box, commit or ref does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrParentMismatch is returned by UpdateRef when the branch head is not the expected one.
+	ErrParentMismatch = errors.New("parent mismatch")
+	// ErrExists is returned when creating a tag whose name is taken.
+	ErrExists = errors.New("already exists")
+	// ErrNotDirectory is returned by ListTree when the path is not a directory of the commit.
+	ErrNotDirectory = errors.New("not a directory")
+	// ErrQuotaExceeded is returned by SavePush when a namespace would exceed its quota.
+	ErrQuotaExceeded = errors.New("quota exceeded")
 )
 
 type Box struct {
@@ -13,13 +28,23 @@ type Box struct {
 }
 
 type Commit struct {
-	ID        string
-	BoxID     string
-	Branch    string
-	ParentID  *string
+	ID       string
+	BoxID    string
+	Branch   string
+	ParentID *string
+	// Parents lists every parent in order, first parent first; a merge
+	// commit has two. ParentID is the first parent.
+	Parents   []string
 	Message   string
 	Author    string
 	Timestamp string
+	// TreeID is the content address of the commit's root tree; empty for
+	// commits stored before trees.
+	TreeID string
+	// Signature is the detached signature the pusher sent, if any, and
+	// Signer the key that verified it.
+	Signature string
+	Signer    string
 	Entries   []Entry
 }
 
@@ -30,13 +55,190 @@ type Entry struct {
 	Mode   int
 }
 
+// Ref is a branch and the commit it points at.
+type Ref struct {
+	Name     string `json:"name"`
+	CommitID string `json:"commit_id"`
+}
+
+// RefUpdate describes a change to a branch head.
+type RefUpdate struct {
+	BoxID  string
+	Branch string
+	// Old is the head the branch must have for the update to apply; ""
+	// requires the branch not to exist. Ignored when Force is set.
+	Old string
+	// New is the commit to point the branch at; "" deletes the branch.
+	New   string
+	Force bool
+	// Principal and Reason are recorded in the reflog.
+	Principal string
+	Reason    string
+}
+
+// Tag names a commit. A tag with a Message is annotated and also records
+// who created it.
+type Tag struct {
+	Name      string `json:"name"`
+	CommitID  string `json:"commit_id"`
+	Annotated bool   `json:"annotated"`
+	Message   string `json:"message,omitempty"`
+	Tagger    string `json:"tagger,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+// Push is a commit together with the ref update that publishes it and the
+// blobs it charges, stored at once by SavePush.
+type Push struct {
+	Commit Commit
+	// Ref moves the branch; its New is set to the commit's ID.
+	Ref RefUpdate
+	// Charge maps blobs the commit adds to their sizes. Those Namespace
+	// does not hold yet count against Quota (0 for unlimited).
+	Namespace string
+	Charge    map[string]int64
+	Quota     int64
+}
+
+// PushResult is what SavePush stored. Old is the branch's previous head,
+// Used the namespace usage before the push and Added the bytes it charged.
+type PushResult struct {
+	Commit Commit
+	Old    string
+	Used   int64
+	Added  int64
+}
+
+// BranchRule protects the branches of a box whose names match Pattern (see
+// path.Match, so "release/*" covers "release/1.2" but not "release/1/2").
+// The zero value forbids force updates and deletion only.
+type BranchRule struct {
+	Pattern     string `json:"pattern"`
+	AllowForce  bool   `json:"allow_force"`
+	AllowDelete bool   `json:"allow_delete"`
+	// RequireSigned only lets the branch move to commits with a verified
+	// signature.
+	RequireSigned bool `json:"require_signed"`
+	// Principals, if not empty, are the only principals that may move the
+	// branch.
+	Principals []string `json:"principals"`
+	UpdatedAt  string   `json:"updated_at"`
+}
+
+// RefLogEntry is one recorded movement of a branch head. Old is empty when
+// the update created the branch and New when it deleted it.
+type RefLogEntry struct {
+	ID        int64  `json:"id"`
+	BoxID     string `json:"box_id"`
+	Branch    string `json:"branch"`
+	Old       string `json:"old_commit_id,omitempty"`
+	New       string `json:"new_commit_id,omitempty"`
+	Forced    bool   `json:"forced"`
+	Principal string `json:"principal"`
+	Reason    string `json:"reason"`
+	Time      string `json:"time"`
+}
+
+// Tree entry types.
+const (
+	TreeEntryFile = "file"
+	TreeEntryDir  = "dir"
+)
+
+// TreeEntry is a file or directory in a commit's tree. Size and Files of a
+// directory total everything below it.
+type TreeEntry struct {
+	Path   string `json:"path"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	SHA256 string `json:"sha256,omitempty"`
+	TreeID string `json:"tree_id,omitempty"`
+	Size   int64  `json:"size"`
+	Mode   int    `json:"mode,omitempty"`
+	Files  int    `json:"files"`
+}
+
+// AuditEvent is one row of the append-only audit log.
+type AuditEvent struct {
+	ID        int64           `json:"id"`
+	Time      string          `json:"time"`
+	Principal string          `json:"principal"`
+	Action    string          `json:"action"`
+	Target    string          `json:"target"`
+	BoxID     string          `json:"box_id,omitempty"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	RequestID string          `json:"request_id,omitempty"`
+}
+
+// AuditFilter selects audit events. Empty fields match everything; results
+// are newest first and, if BeforeID is set, older than that event.
+type AuditFilter struct {
+	Principal string
+	Action    string
+	Target    string
+	BoxID     string
+	Since     string // TimeFormat, inclusive
+	Until     string // TimeFormat, exclusive
+	BeforeID  int64
+	Limit     int
+}
+
 type MetadataStore interface {
 	CreateBox(ctx context.Context, b Box) (Box, error)
 	GetBox(ctx context.Context, ns, name string) (Box, error)
 	SaveCommit(ctx context.Context, c Commit) (Commit, error)
 	LatestCommit(ctx context.Context, boxID string, branch string) (Commit, error)
-	MoveRef(ctx context.Context, boxID, branch, parentID, newID string) error
+	// GetRef returns the commit a branch points at, or ErrNotFound.
+	GetRef(ctx context.Context, boxID, branch string) (string, error)
+	ListRefs(ctx context.Context, boxID string) ([]Ref, error)
+	// UpdateRef moves, creates or deletes a branch as u describes and logs
+	// the change in the reflog. It returns the previous head ("" if the
+	// branch did not exist) and fails with ErrParentMismatch if the head is
+	// not u.Old, unless u.Force is set, and ErrNotFound when deleting a
+	// branch that does not exist.
+	UpdateRef(ctx context.Context, u RefUpdate) (string, error)
+	// ListRefLog returns the reflog of a branch newest first, up to limit
+	// entries older than beforeID (all if beforeID is 0). Entries outlive
+	// the branch, so a deleted branch still has its history.
+	ListRefLog(ctx context.Context, boxID, branch string, beforeID int64, limit int) ([]RefLogEntry, error)
+	// GetRefLogEntry returns one reflog entry, or ErrNotFound.
+	GetRefLogEntry(ctx context.Context, id int64) (RefLogEntry, error)
+	// CreateTag stores a new tag, failing with ErrExists if the name is taken.
+	CreateTag(ctx context.Context, boxID string, t Tag) (Tag, error)
+	// MoveTag replaces an existing tag and returns the one it replaced.
+	MoveTag(ctx context.Context, boxID string, t Tag) (Tag, error)
+	// DeleteTag removes a tag and returns it.
+	DeleteTag(ctx context.Context, boxID, name string) (Tag, error)
+	GetTag(ctx context.Context, boxID, name string) (Tag, error)
+	ListTags(ctx context.Context, boxID string) ([]Tag, error)
+	// ListBranchRules returns the protection rules of a box by pattern.
+	ListBranchRules(ctx context.Context, boxID string) ([]BranchRule, error)
+	// PutBranchRule creates or replaces the rule for r.Pattern.
+	PutBranchRule(ctx context.Context, boxID string, r BranchRule) (BranchRule, error)
+	// DeleteBranchRule removes a rule, or fails with ErrNotFound.
+	DeleteBranchRule(ctx context.Context, boxID, pattern string) error
 	ListPublicBoxes(ctx context.Context) ([]Box, error)
 	GetCommitByID(ctx context.Context, id string) (Commit, error)
 	ListCommits(ctx context.Context, boxID, branch string, limit int) ([]Commit, error)
+	SetBoxVisibility(ctx context.Context, boxID, visibility string) error
+	AppendAudit(ctx context.Context, e AuditEvent) (AuditEvent, error)
+	ListAudit(ctx context.Context, f AuditFilter) ([]AuditEvent, error)
+	// UnchargedBlobs returns the subset of shas not yet counted against ns.
+	UnchargedBlobs(ctx context.Context, ns string, shas []string) ([]string, error)
+	// SavePush charges p.Charge to p.Namespace, stores p.Commit and applies
+	// p.Ref as UpdateRef would, all or nothing. It fails with
+	// ErrQuotaExceeded (with Used and Added set), ErrParentMismatch or
+	// ErrNotFound.
+	SavePush(ctx context.Context, p Push) (PushResult, error)
+	NamespaceUsage(ctx context.Context, ns string) (int64, error)
+	// ListTree lists directory dir ("" for the root) of commitID in boxID:
+	// its children, or with recursive everything below it, sorted by path.
+	// It fails with ErrNotFound for an unknown commit and ErrNotDirectory if
+	// dir is not a directory.
+	ListTree(ctx context.Context, boxID, commitID, dir string, recursive bool) ([]TreeEntry, error)
+	// MergeBase returns the most recent commit that is an ancestor of both a
+	// and b (a commit is its own ancestor), or ErrNotFound if they share no
+	// history.
+	MergeBase(ctx context.Context, a, b string) (string, error)
 }
@@ -1,7 +1,13 @@
 package metastore
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestBoxModel(t *testing.T) {
@@ -35,3 +41,199 @@ func TestCommitModel(t *testing.T) {
 		t.Errorf("expected 1 entry, got %d", len(c.Entries))
 	}
 }
+
+func TestAuditLogIsAppendOnlyAndFilterable(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewSQLiteMetaStore(":memory:")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer s.Close()
+	for _, e := range []AuditEvent{
+		{Principal: "token:ci", Action: "box.create", Target: "box:a", BoxID: "A"},
+		{Principal: "token:ci", Action: "push.finalize", Target: "commit:1", BoxID: "A", After: []byte(`{"commit_id":"1"}`)},
+		{Principal: "token:bob", Action: "box.create", Target: "box:b", BoxID: "B"},
+	} {
+		if _, err := s.AppendAudit(ctx, e); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+
+	got, err := s.ListAudit(ctx, AuditFilter{Principal: "token:ci"})
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(got) != 2 || got[0].Action != "push.finalize" || string(got[0].After) != `{"commit_id":"1"}` {
+		t.Fatalf("unexpected events: %+v", got)
+	}
+	got, _ = s.ListAudit(ctx, AuditFilter{Action: "box.create", BeforeID: 3})
+	if len(got) != 1 || got[0].BoxID != "A" {
+		t.Fatalf("expected only the first box.create, got %+v", got)
+	}
+
+	if _, err := s.db.ExecContext(ctx, `UPDATE audit_log SET principal='x'`); err == nil {
+		t.Fatal("expected update to be rejected")
+	}
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM audit_log`); err == nil {
+		t.Fatal("expected delete to be rejected")
+	}
+}
+
+func TestCommitsShareUnchangedTrees(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewSQLiteMetaStore(":memory:")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	var entries []Entry
+	for _, dir := range []string{"a", "b", "c"} {
+		for _, f := range []string{"1", "2", "3", "4"} {
+			entries = append(entries, Entry{Path: dir + "/sub/" + f, SHA256: dir + f, Size: 1, Mode: 0o644})
+		}
+	}
+	first, err := s.SaveCommit(ctx, Commit{BoxID: "box", Branch: "main", Entries: entries})
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	rows := func() (n int) {
+		if err := s.db.QueryRow(`SELECT COUNT(*) FROM tree_entries`).Scan(&n); err != nil {
+			t.Fatalf("count: %v", err)
+		}
+		return n
+	}
+	before := rows()
+
+	changed := append([]Entry(nil), entries...)
+	changed[0].SHA256 = "new"
+	second, err := s.SaveCommit(ctx, Commit{BoxID: "box", Branch: "main", Entries: changed})
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	// Only the root, a/ and a/sub/ are rewritten: 3 + 1 + 4 rows.
+	if got := rows() - before; got != 8 {
+		t.Fatalf("second commit wrote %d tree rows, want 8", got)
+	}
+	if first.TreeID == second.TreeID {
+		t.Fatal("different manifests share a root tree")
+	}
+	got, err := s.GetCommitByID(ctx, second.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if got.TreeID != second.TreeID || len(got.Entries) != len(entries) || got.Entries[0].Path != "a/sub/1" || got.Entries[0].SHA256 != "new" {
+		t.Fatalf("unexpected commit %+v", got)
+	}
+	if _, err := s.SaveCommit(ctx, Commit{BoxID: "box", Entries: []Entry{{Path: "x", SHA256: "1"}, {Path: "x/y", SHA256: "2"}}}); err == nil {
+		t.Fatal("expected error for path that is both file and directory")
+	}
+}
+
+func TestSavePushIsAllOrNothing(t *testing.T) {
+	s, err := NewSQLiteMetaStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	entries := []Entry{{Path: "a", SHA256: "aaa", Size: 3}}
+	first, err := s.SavePush(ctx, Push{
+		Commit:    Commit{BoxID: "box", Branch: "main", Entries: entries},
+		Ref:       RefUpdate{BoxID: "box", Branch: "main", Reason: "push"},
+		Namespace: "ns",
+		Charge:    map[string]int64{"aaa": 3},
+	})
+	if err != nil || first.Old != "" || first.Added != 3 {
+		t.Fatalf("first push: %+v, %v", first, err)
+	}
+
+	// A lease that does not hold and a full quota both roll everything back.
+	for name, p := range map[string]Push{
+		"stale lease": {Ref: RefUpdate{Old: "elsewhere"}, Charge: map[string]int64{"bbb": 5}},
+		"over quota":  {Ref: RefUpdate{Old: first.Commit.ID}, Charge: map[string]int64{"bbb": 5}, Quota: 4},
+	} {
+		p.Commit = Commit{ID: "second", BoxID: "box", Branch: "main", Parents: []string{first.Commit.ID}, Entries: []Entry{{Path: "b", SHA256: "bbb", Size: 5}}}
+		p.Ref.BoxID, p.Ref.Branch, p.Namespace = "box", "main", "ns"
+		if _, err := s.SavePush(ctx, p); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+		if used, _ := s.NamespaceUsage(ctx, "ns"); used != 3 {
+			t.Errorf("%s: namespace usage is %d, want 3", name, used)
+		}
+		if _, err := s.GetCommitByID(ctx, "second"); err != ErrNotFound {
+			t.Errorf("%s: commit was stored (%v)", name, err)
+		}
+		if head, _ := s.GetRef(ctx, "box", "main"); head != first.Commit.ID {
+			t.Errorf("%s: branch moved to %s", name, head)
+		}
+	}
+}
+
+func TestAuditTimeBoundsOrderSubSecond(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewSQLiteMetaStore(":memory:")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer s.Close()
+	base := time.Date(2024, 5, 1, 12, 0, 5, 0, time.UTC)
+	for i, d := range []time.Duration{0, 500 * time.Millisecond, time.Second} {
+		e := AuditEvent{Time: base.Add(d).Format(TimeFormat), Principal: "token:ci", Action: "box.create", Target: "box:" + string(rune('a'+i))}
+		if _, err := s.AppendAudit(ctx, e); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+
+	got, err := s.ListAudit(ctx, AuditFilter{Since: base.Format(TimeFormat), Until: base.Add(500 * time.Millisecond).Format(TimeFormat)})
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(got) != 1 || got[0].Target != "box:a" {
+		t.Fatalf("expected only the event at the whole second, got %+v", got)
+	}
+	got, _ = s.ListAudit(ctx, AuditFilter{Since: base.Add(500 * time.Millisecond).Format(TimeFormat)})
+	if len(got) != 2 || got[0].Target != "box:c" || got[1].Target != "box:b" {
+		t.Fatalf("expected the two later events, got %+v", got)
+	}
+}
+
+func TestConcurrentPushesToFileDB(t *testing.T) {
+	s, err := NewSQLiteMetaStore(filepath.Join(t.TempDir(), "meta.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	const n = 8
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sha := fmt.Sprintf("%064d", i)
+			_, err := s.SavePush(ctx, Push{
+				Commit:    Commit{BoxID: "box", Branch: "main", Entries: []Entry{{Path: "a", SHA256: sha, Size: 1}}},
+				Ref:       RefUpdate{BoxID: "box", Branch: "main", Reason: "push"},
+				Namespace: "ns",
+				Charge:    map[string]int64{sha: 1},
+			})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	won := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			won++
+		case !errors.Is(err, ErrParentMismatch):
+			t.Errorf("expected success or ErrParentMismatch, got %v", err)
+		}
+	}
+	if won != 1 {
+		t.Fatalf("%d pushes with the same lease succeeded, want 1", won)
+	}
+	if used, _ := s.NamespaceUsage(ctx, "ns"); used != 1 {
+		t.Fatalf("namespace usage is %d, want 1", used)
+	}
+}
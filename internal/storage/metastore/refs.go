@@ -0,0 +1,129 @@
+package metastore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+)
+
+// GetRef returns the commit branch points at.
+func (s *SQLiteMetaStore) GetRef(ctx context.Context, boxID, branch string) (string, error) {
+	var id string
+	err := s.db.QueryRowContext(ctx, `SELECT commit_id FROM refs WHERE box_id=? AND branch=?`, boxID, branch).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	return id, err
+}
+
+// ListRefs returns the branches of a box by name.
+func (s *SQLiteMetaStore) ListRefs(ctx context.Context, boxID string) ([]Ref, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT branch, commit_id FROM refs WHERE box_id=? ORDER BY branch`, boxID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Ref{}
+	for rows.Next() {
+		var r Ref
+		if err := rows.Scan(&r.Name, &r.CommitID); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// UpdateRef compares and swaps the branch head, then appends to ref_log.
+// The swap itself is conditional so a concurrent update between the read
+// and the write still fails with ErrParentMismatch.
+func (s *SQLiteMetaStore) UpdateRef(ctx context.Context, u RefUpdate) (string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	old, err := updateRef(ctx, tx, u)
+	if err != nil {
+		return "", err
+	}
+	return old, tx.Commit()
+}
+
+func updateRef(ctx context.Context, tx *sql.Tx, u RefUpdate) (string, error) {
+	var cur string
+	err := tx.QueryRowContext(ctx, `SELECT commit_id FROM refs WHERE box_id=? AND branch=?`, u.BoxID, u.Branch).Scan(&cur)
+	exists := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+	if !u.Force && cur != u.Old {
+		slog.DebugContext(ctx, "ref update rejected", "box_id", u.BoxID, "branch", u.Branch, "current", cur, "expected", u.Old, "new_id", u.New)
+		return "", ErrParentMismatch
+	}
+
+	var res sql.Result
+	switch {
+	case u.New == "" && !exists:
+		return "", ErrNotFound
+	case u.New == "":
+		res, err = tx.ExecContext(ctx, `DELETE FROM refs WHERE box_id=? AND branch=? AND commit_id=?`, u.BoxID, u.Branch, cur)
+	case exists:
+		res, err = tx.ExecContext(ctx, `UPDATE refs SET commit_id=? WHERE box_id=? AND branch=? AND commit_id=?`, u.New, u.BoxID, u.Branch, cur)
+	default:
+		res, err = tx.ExecContext(ctx, `INSERT INTO refs(box_id, branch, commit_id) VALUES(?,?,?) ON CONFLICT DO NOTHING`, u.BoxID, u.Branch, u.New)
+	}
+	if err != nil {
+		return "", err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", ErrParentMismatch // moved concurrently
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO ref_log(box_id, branch, old_id, new_id, forced, principal, reason, time) VALUES(?,?,?,?,?,?,?,?)`,
+		u.BoxID, u.Branch, cur, u.New, u.Force, u.Principal, u.Reason, now()); err != nil {
+		return "", err
+	}
+	return cur, nil
+}
+
+const refLogColumns = `id, box_id, branch, old_id, new_id, forced, principal, reason, time`
+
+func scanRefLog(row interface{ Scan(...any) error }) (RefLogEntry, error) {
+	var e RefLogEntry
+	err := row.Scan(&e.ID, &e.BoxID, &e.Branch, &e.Old, &e.New, &e.Forced, &e.Principal, &e.Reason, &e.Time)
+	return e, err
+}
+
+func (s *SQLiteMetaStore) ListRefLog(ctx context.Context, boxID, branch string, beforeID int64, limit int) ([]RefLogEntry, error) {
+	q := `SELECT ` + refLogColumns + ` FROM ref_log WHERE box_id=? AND branch=?`
+	args := []any{boxID, branch}
+	if beforeID > 0 {
+		q += ` AND id < ?`
+		args = append(args, beforeID)
+	}
+	q += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+	rows, err := s.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []RefLogEntry{}
+	for rows.Next() {
+		e, err := scanRefLog(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLiteMetaStore) GetRefLogEntry(ctx context.Context, id int64) (RefLogEntry, error) {
+	e, err := scanRefLog(s.db.QueryRowContext(ctx, `SELECT `+refLogColumns+` FROM ref_log WHERE id=?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return RefLogEntry{}, ErrNotFound
+	}
+	return e, err
+}
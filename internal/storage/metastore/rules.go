@@ -0,0 +1,59 @@
+package metastore
+
+import (
+	"context"
+	"encoding/json"
+)
+
+func (s *SQLiteMetaStore) ListBranchRules(ctx context.Context, boxID string) ([]BranchRule, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT pattern, allow_force, allow_delete, require_signed, principals, updated_at
+		FROM branch_rules WHERE box_id=? ORDER BY pattern`, boxID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []BranchRule{}
+	for rows.Next() {
+		var r BranchRule
+		var principals string
+		if err := rows.Scan(&r.Pattern, &r.AllowForce, &r.AllowDelete, &r.RequireSigned, &principals, &r.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(principals), &r.Principals); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLiteMetaStore) PutBranchRule(ctx context.Context, boxID string, r BranchRule) (BranchRule, error) {
+	if r.Principals == nil {
+		r.Principals = []string{}
+	}
+	principals, err := json.Marshal(r.Principals)
+	if err != nil {
+		return BranchRule{}, err
+	}
+	r.UpdatedAt = now()
+	_, err = s.db.ExecContext(ctx, `INSERT INTO branch_rules(box_id, pattern, allow_force, allow_delete, require_signed, principals, updated_at)
+		VALUES(?,?,?,?,?,?,?)
+		ON CONFLICT(box_id, pattern) DO UPDATE SET allow_force=excluded.allow_force, allow_delete=excluded.allow_delete,
+			require_signed=excluded.require_signed, principals=excluded.principals, updated_at=excluded.updated_at`,
+		boxID, r.Pattern, r.AllowForce, r.AllowDelete, r.RequireSigned, string(principals), r.UpdatedAt)
+	if err != nil {
+		return BranchRule{}, err
+	}
+	return r, nil
+}
+
+func (s *SQLiteMetaStore) DeleteBranchRule(ctx context.Context, boxID, pattern string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM branch_rules WHERE box_id=? AND pattern=?`, boxID, pattern)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
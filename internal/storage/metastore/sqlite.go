@@ -25,13 +25,21 @@ func (s *SQLiteMetaStore) ListCommits(ctx context.Context, boxID, branch string,
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-	var out []Commit
+	var ids []string
 	for rows.Next() {
 		var id string
 		if err := rows.Scan(&id); err != nil {
+			rows.Close()
 			return nil, err
 		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	var out []Commit
+	for _, id := range ids {
 		c, err := s.GetCommitByID(ctx, id)
 		if err != nil {
 			return nil, err
@@ -41,11 +49,25 @@ func (s *SQLiteMetaStore) ListCommits(ctx context.Context, boxID, branch string,
 	return out, nil
 }
 
+// sqliteOptions make concurrent writers queue instead of failing: every
+// connection waits up to ten seconds for a lock, and transactions take the
+// write lock when they begin (BEGIN IMMEDIATE), so two of them never both
+// read under a shared lock and then deadlock upgrading to write.
+const sqliteOptions = "_pragma=busy_timeout(10000)&_txlock=immediate"
+
 func NewSQLiteMetaStore(path string) (*SQLiteMetaStore, error) {
-	db, err := sql.Open("sqlite", path)
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	db, err := sql.Open("sqlite", path+sep+sqliteOptions)
 	if err != nil {
 		return nil, err
 	}
+	if path == ":memory:" {
+		// Every connection to :memory: is a separate database; pin to one.
+		db.SetMaxOpenConns(1)
+	}
 	// Initialize schema from init.sql if available
 	if err := initSchema(db); err != nil {
 		return nil, err
@@ -53,6 +75,11 @@ func NewSQLiteMetaStore(path string) (*SQLiteMetaStore, error) {
 	return &SQLiteMetaStore{db: db}, nil
 }
 
+// Close closes the underlying database.
+func (s *SQLiteMetaStore) Close() error {
+	return s.db.Close()
+}
+
 func initSchema(db *sql.DB) error {
 	// Always ensure minimal schema exists
 	if err := fallbackSchema(db); err != nil {
@@ -102,6 +129,7 @@ func fallbackSchema(db *sql.DB) error {
 			author TEXT,
 			timestamp TEXT NOT NULL
 		);`,
+		// entries holds manifests of commits written before trees existed.
 		`CREATE TABLE IF NOT EXISTS entries (
 			commit_id TEXT NOT NULL,
 			path TEXT NOT NULL,
@@ -110,19 +138,123 @@ func fallbackSchema(db *sql.DB) error {
 			mode INTEGER NOT NULL,
 			PRIMARY KEY (commit_id, path)
 		);`,
+		`CREATE TABLE IF NOT EXISTS trees (
+			id TEXT PRIMARY KEY
+		);`,
+		`CREATE TABLE IF NOT EXISTS tree_entries (
+			tree_id TEXT NOT NULL REFERENCES trees(id),
+			name TEXT NOT NULL,
+			kind TEXT NOT NULL CHECK (kind IN ('blob','tree')),
+			ref TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			mode INTEGER NOT NULL,
+			PRIMARY KEY (tree_id, name)
+		);`,
+		`CREATE TABLE IF NOT EXISTS commit_parents (
+			commit_id TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			parent_id TEXT NOT NULL,
+			PRIMARY KEY (commit_id, position)
+		);`,
+		`CREATE INDEX IF NOT EXISTS commit_parents_parent ON commit_parents(parent_id);`,
 		`CREATE TABLE IF NOT EXISTS refs (
 			box_id TEXT NOT NULL,
 			branch TEXT NOT NULL,
 			commit_id TEXT NOT NULL,
 			PRIMARY KEY (box_id, branch)
 		);`,
+		// ref_log records every branch head movement, oldest first. An empty
+		// old_id is a branch being created, an empty new_id one being deleted.
+		`CREATE TABLE IF NOT EXISTS ref_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			box_id TEXT NOT NULL,
+			branch TEXT NOT NULL,
+			old_id TEXT NOT NULL,
+			new_id TEXT NOT NULL,
+			forced INTEGER NOT NULL DEFAULT 0,
+			principal TEXT NOT NULL,
+			reason TEXT NOT NULL,
+			time TEXT NOT NULL
+		);`,
+		`CREATE INDEX IF NOT EXISTS ref_log_branch ON ref_log(box_id, branch, id);`,
+		`CREATE TABLE IF NOT EXISTS tags (
+			box_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			commit_id TEXT NOT NULL,
+			message TEXT NOT NULL DEFAULT '',
+			tagger TEXT NOT NULL DEFAULT '',
+			created_at TEXT NOT NULL,
+			PRIMARY KEY (box_id, name)
+		);`,
+		`CREATE TABLE IF NOT EXISTS branch_rules (
+			box_id TEXT NOT NULL,
+			pattern TEXT NOT NULL,
+			allow_force INTEGER NOT NULL DEFAULT 0,
+			allow_delete INTEGER NOT NULL DEFAULT 0,
+			require_signed INTEGER NOT NULL DEFAULT 0,
+			principals TEXT NOT NULL DEFAULT '[]',
+			updated_at TEXT NOT NULL,
+			PRIMARY KEY (box_id, pattern)
+		);`,
+		`CREATE TABLE IF NOT EXISTS namespace_blobs (
+			namespace_id TEXT NOT NULL,
+			sha256 TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			PRIMARY KEY (namespace_id, sha256)
+		);`,
+		`CREATE TABLE IF NOT EXISTS audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			time TEXT NOT NULL,
+			principal TEXT NOT NULL,
+			action TEXT NOT NULL,
+			target TEXT NOT NULL,
+			box_id TEXT,
+			before TEXT,
+			after TEXT,
+			request_id TEXT
+		);`,
+		`CREATE INDEX IF NOT EXISTS audit_log_box ON audit_log(box_id, id);`,
+		`CREATE INDEX IF NOT EXISTS audit_log_principal ON audit_log(principal, id);`,
+		// The audit log is append-only: reject edits at the database level.
+		`CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+		BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END;`,
+		`CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+		BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END;`,
 	}
 	for _, stmt := range minimal {
 		if _, err := db.Exec(stmt); err != nil {
 			return fmt.Errorf("fallback schema failed: %w (stmt: %s)", err, firstN(stmt, 120))
 		}
 	}
-	return nil
+	if err := addColumn(db, "commits", "tree_id", "TEXT"); err != nil {
+		return err
+	}
+	if err := addColumn(db, "tree_entries", "files", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumn(db, "commits", "signature", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumn(db, "commits", "signer", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	// Commits written before commit_parents recorded only parent_id.
+	_, err := db.Exec(`INSERT OR IGNORE INTO commit_parents(commit_id, position, parent_id)
+		SELECT id, 0, parent_id FROM commits WHERE parent_id IS NOT NULL`)
+	return err
+}
+
+// addColumn adds a column to a table created by an older version.
+func addColumn(db *sql.DB, table, column, decl string) error {
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name=?`, table, column).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	_, err := db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, decl))
+	return err
 }
 
 // Implement MetadataStore methods
@@ -136,9 +268,9 @@ func (s *SQLiteMetaStore) CreateBox(ctx context.Context, b Box) (Box, error) {
 	if b.Visibility == "" {
 		b.Visibility = "public"
 	}
-	now := time.Now().UTC().Format(time.RFC3339Nano)
+	created := now()
 	_, err := s.db.ExecContext(ctx, `INSERT INTO boxes(id, namespace_id, name, visibility, default_branch, created_at, updated_at) VALUES(?,?,?,?,?,?,?)`,
-		b.ID, b.NamespaceID, b.Name, b.Visibility, b.DefaultBranch, now, now)
+		b.ID, b.NamespaceID, b.Name, b.Visibility, b.DefaultBranch, created, created)
 	if err != nil {
 		return Box{}, err
 	}
@@ -150,7 +282,7 @@ func (s *SQLiteMetaStore) GetBox(ctx context.Context, ns, name string) (Box, err
 	var b Box
 	if err := row.Scan(&b.ID, &b.NamespaceID, &b.Name, &b.Visibility, &b.DefaultBranch); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return Box{}, fmt.Errorf("not found")
+			return Box{}, ErrNotFound
 		}
 		return Box{}, err
 	}
@@ -158,29 +290,85 @@ func (s *SQLiteMetaStore) GetBox(ctx context.Context, ns, name string) (Box, err
 }
 
 func (s *SQLiteMetaStore) SaveCommit(ctx context.Context, c Commit) (Commit, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Commit{}, err
+	}
+	defer tx.Rollback()
+	if c, err = saveCommit(ctx, tx, c); err != nil {
+		return Commit{}, err
+	}
+	return c, tx.Commit()
+}
+
+// SavePush stores p.Commit, charges p.Charge and moves the branch in one
+// transaction, so a lease that no longer holds or a full quota leaves no
+// trace.
+func (s *SQLiteMetaStore) SavePush(ctx context.Context, p Push) (PushResult, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return PushResult{}, err
+	}
+	defer tx.Rollback()
+	var res PushResult
+	if res.Used, res.Added, err = chargeBlobs(ctx, tx, p.Namespace, p.Charge, p.Quota); err != nil {
+		return res, err
+	}
+	if res.Commit, err = saveCommit(ctx, tx, p.Commit); err != nil {
+		return PushResult{}, err
+	}
+	p.Ref.New = res.Commit.ID
+	if res.Old, err = updateRef(ctx, tx, p.Ref); err != nil {
+		return PushResult{}, err
+	}
+	return res, tx.Commit()
+}
+
+func saveCommit(ctx context.Context, tx *sql.Tx, c Commit) (Commit, error) {
 	if c.ID == "" {
 		c.ID = newULID()
 	}
 	if c.Timestamp == "" {
-		c.Timestamp = time.Now().UTC().Format(time.RFC3339Nano)
+		c.Timestamp = now()
 	}
-	// Insert enact
-	_, err := s.db.ExecContext(ctx, `INSERT INTO commits(id, box_id, branch, parent_id, message, author, timestamp) VALUES(?,?,?,?,?,?,?)`,
-		c.ID, c.BoxID, c.Branch, c.ParentID, c.Message, c.Author, c.Timestamp)
+	switch {
+	case len(c.Parents) > 0:
+		c.ParentID = &c.Parents[0]
+	case c.ParentID != nil:
+		c.Parents = []string{*c.ParentID}
+	}
+	root, err := buildTree(c.Entries)
 	if err != nil {
 		return Commit{}, err
 	}
-	// Insert entries
-	for _, e := range c.Entries {
-		_, err := s.db.ExecContext(ctx, `INSERT INTO entries(commit_id, path, sha256, size, mode) VALUES(?,?,?,?,?)`,
-			c.ID, e.Path, e.SHA256, e.Size, e.Mode)
-		if err != nil {
+	tree, err := writeTree(ctx, tx, root)
+	if err != nil {
+		return Commit{}, err
+	}
+	c.TreeID = tree.ref
+	_, err = tx.ExecContext(ctx, `INSERT INTO commits(id, box_id, branch, parent_id, message, author, timestamp, tree_id, signature, signer) VALUES(?,?,?,?,?,?,?,?,?,?)`,
+		c.ID, c.BoxID, c.Branch, c.ParentID, c.Message, c.Author, c.Timestamp, c.TreeID, c.Signature, c.Signer)
+	if err != nil {
+		return Commit{}, err
+	}
+	for i, p := range c.Parents {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO commit_parents(commit_id, position, parent_id) VALUES(?,?,?)`, c.ID, i, p); err != nil {
 			return Commit{}, err
 		}
 	}
 	return c, nil
 }
 
+// TimeFormat is how stored times are written: RFC 3339 in UTC with a fixed
+// nine-digit fraction, so that comparing them as text orders them in time.
+// time.RFC3339Nano drops trailing zeros and would sort "05Z" after "05.5Z".
+const TimeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+// now returns the current time in TimeFormat.
+func now() string {
+	return time.Now().UTC().Format(TimeFormat)
+}
+
 func newULID() string {
 	entropy := ulid.Monotonic(rand.Reader, 0)
 	id := ulid.MustNew(ulid.Timestamp(time.Now()), entropy)
@@ -191,22 +379,41 @@ func (s *SQLiteMetaStore) LatestCommit(ctx context.Context, boxID string, branch
 	row := s.db.QueryRowContext(ctx, `SELECT commit_id FROM refs WHERE box_id=? AND branch=?`, boxID, branch)
 	var id string
 	if err := row.Scan(&id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Commit{}, ErrNotFound
+		}
 		return Commit{}, err
 	}
 	return s.GetCommitByID(ctx, id)
 }
 
 func (s *SQLiteMetaStore) GetCommitByID(ctx context.Context, id string) (Commit, error) {
-	row := s.db.QueryRowContext(ctx, `SELECT id, box_id, branch, parent_id, message, author, timestamp FROM commits WHERE id=?`, id)
+	row := s.db.QueryRowContext(ctx, `SELECT id, box_id, branch, parent_id, message, author, timestamp, tree_id, signature, signer FROM commits WHERE id=?`, id)
 	var c Commit
-	var parent sql.NullString
-	if err := row.Scan(&c.ID, &c.BoxID, &c.Branch, &parent, &c.Message, &c.Author, &c.Timestamp); err != nil {
+	var parent, tree sql.NullString
+	if err := row.Scan(&c.ID, &c.BoxID, &c.Branch, &parent, &c.Message, &c.Author, &c.Timestamp, &tree, &c.Signature, &c.Signer); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Commit{}, ErrNotFound
+		}
 		return Commit{}, err
 	}
 	if parent.Valid {
 		c.ParentID = &parent.String
 	}
-	// Entries
+	parents, err := s.parents(ctx, id)
+	if err != nil {
+		return Commit{}, err
+	}
+	c.Parents = parents
+	if tree.Valid {
+		c.TreeID = tree.String
+		entries, err := s.readTree(ctx, c.TreeID)
+		if err != nil {
+			return Commit{}, err
+		}
+		c.Entries = entries
+		return c, nil
+	}
 	rows, err := s.db.QueryContext(ctx, `SELECT path, sha256, size, mode FROM entries WHERE commit_id=?`, id)
 	if err != nil {
 		return Commit{}, err
@@ -222,27 +429,48 @@ func (s *SQLiteMetaStore) GetCommitByID(ctx context.Context, id string) (Commit,
 	return c, nil
 }
 
-func (s *SQLiteMetaStore) MoveRef(ctx context.Context, boxID, branch, parentID, newID string) error {
-	// Check current ref
-	row := s.db.QueryRowContext(ctx, `SELECT commit_id FROM refs WHERE box_id=? AND branch=?`, boxID, branch)
-	var cur string
-	if err := row.Scan(&cur); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			// No ref yet; allow move only if parentID == "" (not provided)
-			if parentID != "" {
-				return fmt.Errorf("parent mismatch")
-			}
-			_, err := s.db.ExecContext(ctx, `INSERT INTO refs(box_id, branch, commit_id) VALUES(?,?,?)`, boxID, branch, newID)
-			return err
+// parents returns the parents of commit id in order.
+func (s *SQLiteMetaStore) parents(ctx context.Context, id string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT parent_id FROM commit_parents WHERE commit_id=? ORDER BY position`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
 		}
-		return err
+		out = append(out, p)
 	}
-	if cur != parentID {
-		fmt.Printf("[DEBUG] MoveRef: boxID=%s branch=%s current ref=%s, parentID=%s newID=%s\n", boxID, branch, cur, parentID, newID)
-		return fmt.Errorf("parent mismatch")
+	return out, rows.Err()
+}
+
+// MergeBase walks both ancestries through commit_parents and picks the
+// newest commit they share.
+func (s *SQLiteMetaStore) MergeBase(ctx context.Context, a, b string) (string, error) {
+	var id string
+	err := s.db.QueryRowContext(ctx, `
+		WITH RECURSIVE
+		left_side(id) AS (
+			SELECT ?1
+			UNION
+			SELECT p.parent_id FROM commit_parents p JOIN left_side ON p.commit_id = left_side.id
+		),
+		right_side(id) AS (
+			SELECT ?2
+			UNION
+			SELECT p.parent_id FROM commit_parents p JOIN right_side ON p.commit_id = right_side.id
+		)
+		SELECT c.id FROM commits c
+		WHERE c.id IN (SELECT id FROM left_side) AND c.id IN (SELECT id FROM right_side)
+		ORDER BY c.timestamp DESC, c.id DESC
+		LIMIT 1`, a, b).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
 	}
-	_, err := s.db.ExecContext(ctx, `UPDATE refs SET commit_id=? WHERE box_id=? AND branch=?`, newID, boxID, branch)
-	return err
+	return id, err
 }
 
 func (s *SQLiteMetaStore) ListPublicBoxes(ctx context.Context) ([]Box, error) {
@@ -261,3 +489,190 @@ func (s *SQLiteMetaStore) ListPublicBoxes(ctx context.Context) ([]Box, error) {
 	}
 	return out, nil
 }
+
+// SetBoxVisibility changes a box's visibility.
+func (s *SQLiteMetaStore) SetBoxVisibility(ctx context.Context, boxID, visibility string) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE boxes SET visibility=?, updated_at=? WHERE id=?`,
+		visibility, now(), boxID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// AppendAudit stores e, filling in its ID and, if empty, its time.
+func (s *SQLiteMetaStore) AppendAudit(ctx context.Context, e AuditEvent) (AuditEvent, error) {
+	if e.Time == "" {
+		e.Time = now()
+	}
+	res, err := s.db.ExecContext(ctx, `INSERT INTO audit_log(time, principal, action, target, box_id, before, after, request_id) VALUES(?,?,?,?,?,?,?,?)`,
+		e.Time, e.Principal, e.Action, e.Target, nullString(e.BoxID), nullString(string(e.Before)), nullString(string(e.After)), nullString(e.RequestID))
+	if err != nil {
+		return AuditEvent{}, err
+	}
+	if e.ID, err = res.LastInsertId(); err != nil {
+		return AuditEvent{}, err
+	}
+	return e, nil
+}
+
+// ListAudit returns events matching f, newest first.
+func (s *SQLiteMetaStore) ListAudit(ctx context.Context, f AuditFilter) ([]AuditEvent, error) {
+	var where []string
+	var args []any
+	for _, c := range []struct {
+		col string
+		op  string
+		val string
+	}{
+		{"principal", "=", f.Principal},
+		{"action", "=", f.Action},
+		{"target", "=", f.Target},
+		{"box_id", "=", f.BoxID},
+		{"time", ">=", f.Since},
+		{"time", "<", f.Until},
+	} {
+		if c.val != "" {
+			where = append(where, c.col+c.op+"?")
+			args = append(args, c.val)
+		}
+	}
+	if f.BeforeID > 0 {
+		where = append(where, "id<?")
+		args = append(args, f.BeforeID)
+	}
+	q := `SELECT id, time, principal, action, target, box_id, before, after, request_id FROM audit_log`
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	if f.Limit <= 0 {
+		f.Limit = 100
+	}
+	q += " ORDER BY id DESC LIMIT ?"
+	args = append(args, f.Limit)
+
+	rows, err := s.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []AuditEvent{}
+	for rows.Next() {
+		var e AuditEvent
+		var boxID, before, after, rid sql.NullString
+		if err := rows.Scan(&e.ID, &e.Time, &e.Principal, &e.Action, &e.Target, &boxID, &before, &after, &rid); err != nil {
+			return nil, err
+		}
+		e.BoxID, e.RequestID = boxID.String, rid.String
+		if before.Valid {
+			e.Before = []byte(before.String)
+		}
+		if after.Valid {
+			e.After = []byte(after.String)
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// UnchargedBlobs returns the shas that ns does not hold yet, in input order.
+func (s *SQLiteMetaStore) UnchargedBlobs(ctx context.Context, ns string, shas []string) ([]string, error) {
+	held, err := heldBlobs(ctx, s.db, ns, shas)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, sha := range shas {
+		if !held[sha] {
+			out = append(out, sha)
+		}
+	}
+	return out, nil
+}
+
+// chargeBlobs counts the sizes of the blobs ns does not hold yet against
+// it. If quota > 0 and the new total would exceed it, nothing is charged
+// and ErrQuotaExceeded is returned. used is the usage before the call and
+// added the bytes the new blobs need.
+func chargeBlobs(ctx context.Context, tx *sql.Tx, ns string, sizes map[string]int64, quota int64) (used, added int64, err error) {
+	if len(sizes) == 0 {
+		return 0, 0, nil
+	}
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(SUM(size), 0) FROM namespace_blobs WHERE namespace_id=?`, ns).Scan(&used); err != nil {
+		return 0, 0, err
+	}
+	shas := make([]string, 0, len(sizes))
+	for sha := range sizes {
+		shas = append(shas, sha)
+	}
+	held, err := heldBlobs(ctx, tx, ns, shas)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, sha := range shas {
+		if !held[sha] {
+			added += sizes[sha]
+		}
+	}
+	if quota > 0 && used+added > quota {
+		return used, added, ErrQuotaExceeded
+	}
+	for _, sha := range shas {
+		if held[sha] {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO namespace_blobs(namespace_id, sha256, size) VALUES(?,?,?)`, ns, sha, sizes[sha]); err != nil {
+			return 0, 0, err
+		}
+	}
+	return used, added, nil
+}
+
+func (s *SQLiteMetaStore) NamespaceUsage(ctx context.Context, ns string) (int64, error) {
+	var used int64
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(SUM(size), 0) FROM namespace_blobs WHERE namespace_id=?`, ns).Scan(&used)
+	return used, err
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// heldBlobs reports which of shas ns already holds, querying in chunks to
+// stay under SQLite's bound parameter limit.
+func heldBlobs(ctx context.Context, q querier, ns string, shas []string) (map[string]bool, error) {
+	const chunk = 500
+	held := map[string]bool{}
+	for start := 0; start < len(shas); start += chunk {
+		part := shas[start:min(start+chunk, len(shas))]
+		args := make([]any, 0, len(part)+1)
+		args = append(args, ns)
+		for _, sha := range part {
+			args = append(args, sha)
+		}
+		rows, err := q.QueryContext(ctx, `SELECT sha256 FROM namespace_blobs WHERE namespace_id=? AND sha256 IN (?`+strings.Repeat(",?", len(part)-1)+`)`, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var sha string
+			if err := rows.Scan(&sha); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			held[sha] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return held, nil
+}
@@ -0,0 +1,89 @@
+package metastore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+const tagColumns = `name, commit_id, message, tagger, created_at`
+
+func scanTag(row interface{ Scan(...any) error }) (Tag, error) {
+	var t Tag
+	if err := row.Scan(&t.Name, &t.CommitID, &t.Message, &t.Tagger, &t.CreatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Tag{}, ErrNotFound
+		}
+		return Tag{}, err
+	}
+	t.Annotated = t.Message != ""
+	return t, nil
+}
+
+func (s *SQLiteMetaStore) CreateTag(ctx context.Context, boxID string, t Tag) (Tag, error) {
+	t.CreatedAt = now()
+	t.Annotated = t.Message != ""
+	res, err := s.db.ExecContext(ctx, `INSERT INTO tags(box_id, name, commit_id, message, tagger, created_at) VALUES(?,?,?,?,?,?) ON CONFLICT DO NOTHING`,
+		boxID, t.Name, t.CommitID, t.Message, t.Tagger, t.CreatedAt)
+	if err != nil {
+		return Tag{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return Tag{}, ErrExists
+	}
+	return t, nil
+}
+
+func (s *SQLiteMetaStore) MoveTag(ctx context.Context, boxID string, t Tag) (Tag, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Tag{}, err
+	}
+	defer tx.Rollback()
+	prev, err := scanTag(tx.QueryRowContext(ctx, `SELECT `+tagColumns+` FROM tags WHERE box_id=? AND name=?`, boxID, t.Name))
+	if err != nil {
+		return Tag{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE tags SET commit_id=?, message=?, tagger=?, created_at=? WHERE box_id=? AND name=?`,
+		t.CommitID, t.Message, t.Tagger, now(), boxID, t.Name); err != nil {
+		return Tag{}, err
+	}
+	return prev, tx.Commit()
+}
+
+func (s *SQLiteMetaStore) DeleteTag(ctx context.Context, boxID, name string) (Tag, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Tag{}, err
+	}
+	defer tx.Rollback()
+	prev, err := scanTag(tx.QueryRowContext(ctx, `SELECT `+tagColumns+` FROM tags WHERE box_id=? AND name=?`, boxID, name))
+	if err != nil {
+		return Tag{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM tags WHERE box_id=? AND name=?`, boxID, name); err != nil {
+		return Tag{}, err
+	}
+	return prev, tx.Commit()
+}
+
+func (s *SQLiteMetaStore) GetTag(ctx context.Context, boxID, name string) (Tag, error) {
+	return scanTag(s.db.QueryRowContext(ctx, `SELECT `+tagColumns+` FROM tags WHERE box_id=? AND name=?`, boxID, name))
+}
+
+func (s *SQLiteMetaStore) ListTags(ctx context.Context, boxID string) ([]Tag, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+tagColumns+` FROM tags WHERE box_id=? ORDER BY name`, boxID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Tag{}
+	for rows.Next() {
+		t, err := scanTag(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,202 @@
+package metastore
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"fgo/internal/observe"
+)
+
+// traced wraps each MetadataStore call in a child span.
+type traced struct {
+	MetadataStore
+	t *observe.Tracer
+}
+
+// Trace wraps m so every call records a span named "metastore.<Method>"
+// under the span in the call's context. ErrNotFound is not treated as a failure.
+func Trace(m MetadataStore, t *observe.Tracer) MetadataStore {
+	return &traced{MetadataStore: m, t: t}
+}
+
+func (s *traced) start(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, *observe.Span) {
+	return s.t.Start(ctx, "metastore."+name, observe.SpanKindClient,
+		append([]slog.Attr{slog.String("db.system", "sqlite")}, attrs...)...)
+}
+
+func finish(span *observe.Span, err error) {
+	if !errors.Is(err, ErrNotFound) {
+		span.RecordError(err)
+	}
+	span.Finish()
+}
+
+func (s *traced) CreateBox(ctx context.Context, b Box) (_ Box, err error) {
+	ctx, span := s.start(ctx, "CreateBox", slog.String("box.name", b.Name))
+	defer func() { finish(span, err) }()
+	return s.MetadataStore.CreateBox(ctx, b)
+}
+
+func (s *traced) GetBox(ctx context.Context, ns, name string) (_ Box, err error) {
+	ctx, span := s.start(ctx, "GetBox", slog.String("box.name", name))
+	defer func() { finish(span, err) }()
+	return s.MetadataStore.GetBox(ctx, ns, name)
+}
+
+func (s *traced) SaveCommit(ctx context.Context, c Commit) (_ Commit, err error) {
+	ctx, span := s.start(ctx, "SaveCommit", slog.String("box.id", c.BoxID), slog.Int("commit.entries", len(c.Entries)))
+	defer func() { finish(span, err) }()
+	return s.MetadataStore.SaveCommit(ctx, c)
+}
+
+func (s *traced) LatestCommit(ctx context.Context, boxID, branch string) (_ Commit, err error) {
+	ctx, span := s.start(ctx, "LatestCommit", slog.String("box.id", boxID), slog.String("branch", branch))
+	defer func() { finish(span, err) }()
+	return s.MetadataStore.LatestCommit(ctx, boxID, branch)
+}
+
+func (s *traced) GetRef(ctx context.Context, boxID, branch string) (_ string, err error) {
+	ctx, span := s.start(ctx, "GetRef", slog.String("box.id", boxID), slog.String("branch", branch))
+	defer func() { finish(span, err) }()
+	return s.MetadataStore.GetRef(ctx, boxID, branch)
+}
+
+func (s *traced) ListRefs(ctx context.Context, boxID string) (_ []Ref, err error) {
+	ctx, span := s.start(ctx, "ListRefs", slog.String("box.id", boxID))
+	defer func() { finish(span, err) }()
+	return s.MetadataStore.ListRefs(ctx, boxID)
+}
+
+func (s *traced) UpdateRef(ctx context.Context, u RefUpdate) (_ string, err error) {
+	ctx, span := s.start(ctx, "UpdateRef", slog.String("box.id", u.BoxID), slog.String("branch", u.Branch), slog.Bool("force", u.Force))
+	defer func() { finish(span, err) }()
+	return s.MetadataStore.UpdateRef(ctx, u)
+}
+
+func (s *traced) ListRefLog(ctx context.Context, boxID, branch string, beforeID int64, limit int) (_ []RefLogEntry, err error) {
+	ctx, span := s.start(ctx, "ListRefLog", slog.String("box.id", boxID), slog.String("branch", branch))
+	defer func() { finish(span, err) }()
+	return s.MetadataStore.ListRefLog(ctx, boxID, branch, beforeID, limit)
+}
+
+func (s *traced) GetRefLogEntry(ctx context.Context, id int64) (_ RefLogEntry, err error) {
+	ctx, span := s.start(ctx, "GetRefLogEntry", slog.Int64("reflog.id", id))
+	defer func() { finish(span, err) }()
+	return s.MetadataStore.GetRefLogEntry(ctx, id)
+}
+
+func (s *traced) CreateTag(ctx context.Context, boxID string, t Tag) (_ Tag, err error) {
+	ctx, span := s.start(ctx, "CreateTag", slog.String("box.id", boxID), slog.String("tag", t.Name))
+	defer func() { finish(span, err) }()
+	return s.MetadataStore.CreateTag(ctx, boxID, t)
+}
+
+func (s *traced) MoveTag(ctx context.Context, boxID string, t Tag) (_ Tag, err error) {
+	ctx, span := s.start(ctx, "MoveTag", slog.String("box.id", boxID), slog.String("tag", t.Name))
+	defer func() { finish(span, err) }()
+	return s.MetadataStore.MoveTag(ctx, boxID, t)
+}
+
+func (s *traced) DeleteTag(ctx context.Context, boxID, name string) (_ Tag, err error) {
+	ctx, span := s.start(ctx, "DeleteTag", slog.String("box.id", boxID), slog.String("tag", name))
+	defer func() { finish(span, err) }()
+	return s.MetadataStore.DeleteTag(ctx, boxID, name)
+}
+
+func (s *traced) GetTag(ctx context.Context, boxID, name string) (_ Tag, err error) {
+	ctx, span := s.start(ctx, "GetTag", slog.String("box.id", boxID), slog.String("tag", name))
+	defer func() { finish(span, err) }()
+	return s.MetadataStore.GetTag(ctx, boxID, name)
+}
+
+func (s *traced) ListTags(ctx context.Context, boxID string) (_ []Tag, err error) {
+	ctx, span := s.start(ctx, "ListTags", slog.String("box.id", boxID))
+	defer func() { finish(span, err) }()
+	return s.MetadataStore.ListTags(ctx, boxID)
+}
+
+func (s *traced) ListBranchRules(ctx context.Context, boxID string) (_ []BranchRule, err error) {
+	ctx, span := s.start(ctx, "ListBranchRules", slog.String("box.id", boxID))
+	defer func() { finish(span, err) }()
+	return s.MetadataStore.ListBranchRules(ctx, boxID)
+}
+
+func (s *traced) PutBranchRule(ctx context.Context, boxID string, r BranchRule) (_ BranchRule, err error) {
+	ctx, span := s.start(ctx, "PutBranchRule", slog.String("box.id", boxID), slog.String("pattern", r.Pattern))
+	defer func() { finish(span, err) }()
+	return s.MetadataStore.PutBranchRule(ctx, boxID, r)
+}
+
+func (s *traced) DeleteBranchRule(ctx context.Context, boxID, pattern string) (err error) {
+	ctx, span := s.start(ctx, "DeleteBranchRule", slog.String("box.id", boxID), slog.String("pattern", pattern))
+	defer func() { finish(span, err) }()
+	return s.MetadataStore.DeleteBranchRule(ctx, boxID, pattern)
+}
+
+func (s *traced) ListPublicBoxes(ctx context.Context) (_ []Box, err error) {
+	ctx, span := s.start(ctx, "ListPublicBoxes")
+	defer func() { finish(span, err) }()
+	return s.MetadataStore.ListPublicBoxes(ctx)
+}
+
+func (s *traced) GetCommitByID(ctx context.Context, id string) (_ Commit, err error) {
+	ctx, span := s.start(ctx, "GetCommitByID", slog.String("commit.id", id))
+	defer func() { finish(span, err) }()
+	return s.MetadataStore.GetCommitByID(ctx, id)
+}
+
+func (s *traced) ListCommits(ctx context.Context, boxID, branch string, limit int) (_ []Commit, err error) {
+	ctx, span := s.start(ctx, "ListCommits", slog.String("box.id", boxID), slog.String("branch", branch))
+	defer func() { finish(span, err) }()
+	return s.MetadataStore.ListCommits(ctx, boxID, branch, limit)
+}
+
+func (s *traced) SetBoxVisibility(ctx context.Context, boxID, visibility string) (err error) {
+	ctx, span := s.start(ctx, "SetBoxVisibility", slog.String("box.id", boxID))
+	defer func() { finish(span, err) }()
+	return s.MetadataStore.SetBoxVisibility(ctx, boxID, visibility)
+}
+
+func (s *traced) AppendAudit(ctx context.Context, e AuditEvent) (_ AuditEvent, err error) {
+	ctx, span := s.start(ctx, "AppendAudit", slog.String("audit.action", e.Action))
+	defer func() { finish(span, err) }()
+	return s.MetadataStore.AppendAudit(ctx, e)
+}
+
+func (s *traced) ListAudit(ctx context.Context, f AuditFilter) (_ []AuditEvent, err error) {
+	ctx, span := s.start(ctx, "ListAudit")
+	defer func() { finish(span, err) }()
+	return s.MetadataStore.ListAudit(ctx, f)
+}
+
+func (s *traced) UnchargedBlobs(ctx context.Context, ns string, shas []string) (_ []string, err error) {
+	ctx, span := s.start(ctx, "UnchargedBlobs", slog.String("namespace", ns), slog.Int("blobs", len(shas)))
+	defer func() { finish(span, err) }()
+	return s.MetadataStore.UnchargedBlobs(ctx, ns, shas)
+}
+
+func (s *traced) SavePush(ctx context.Context, p Push) (_ PushResult, err error) {
+	ctx, span := s.start(ctx, "SavePush", slog.String("box.id", p.Commit.BoxID), slog.String("branch", p.Ref.Branch),
+		slog.String("namespace", p.Namespace), slog.Int("blobs", len(p.Charge)))
+	defer func() { finish(span, err) }()
+	return s.MetadataStore.SavePush(ctx, p)
+}
+
+func (s *traced) NamespaceUsage(ctx context.Context, ns string) (_ int64, err error) {
+	ctx, span := s.start(ctx, "NamespaceUsage", slog.String("namespace", ns))
+	defer func() { finish(span, err) }()
+	return s.MetadataStore.NamespaceUsage(ctx, ns)
+}
+
+func (s *traced) ListTree(ctx context.Context, boxID, commitID, dir string, recursive bool) (_ []TreeEntry, err error) {
+	ctx, span := s.start(ctx, "ListTree", slog.String("box.id", boxID), slog.String("commit.id", commitID), slog.Bool("recursive", recursive))
+	defer func() { finish(span, err) }()
+	return s.MetadataStore.ListTree(ctx, boxID, commitID, dir, recursive)
+}
+
+func (s *traced) MergeBase(ctx context.Context, a, b string) (_ string, err error) {
+	ctx, span := s.start(ctx, "MergeBase", slog.String("commit.a", a), slog.String("commit.b", b))
+	defer func() { finish(span, err) }()
+	return s.MetadataStore.MergeBase(ctx, a, b)
+}
@@ -0,0 +1,284 @@
+package metastore
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Commits store their manifest as a tree of content-addressed directory
+// objects, like git: a tree's ID is the SHA-256 of its sorted entries, so a
+// commit only writes the trees along changed paths and shares every
+// unchanged subtree with its parent.
+
+// treeNode is one directory while a manifest is turned into trees.
+type treeNode struct {
+	files map[string]Entry
+	dirs  map[string]*treeNode
+}
+
+func newTreeNode() *treeNode {
+	return &treeNode{files: map[string]Entry{}, dirs: map[string]*treeNode{}}
+}
+
+// buildTree arranges entries by directory. Paths must be clean relative
+// slash-separated paths; a name used as both file and directory is an error.
+func buildTree(entries []Entry) (*treeNode, error) {
+	root := newTreeNode()
+	for _, e := range entries {
+		parts := strings.Split(e.Path, "/")
+		n := root
+		for _, dir := range parts[:len(parts)-1] {
+			if _, ok := n.files[dir]; ok {
+				return nil, fmt.Errorf("metastore: %q is both a file and a directory", e.Path)
+			}
+			child, ok := n.dirs[dir]
+			if !ok {
+				child = newTreeNode()
+				n.dirs[dir] = child
+			}
+			n = child
+		}
+		name := parts[len(parts)-1]
+		if _, ok := n.dirs[name]; ok {
+			return nil, fmt.Errorf("metastore: %q is both a file and a directory", e.Path)
+		}
+		if _, ok := n.files[name]; ok {
+			return nil, fmt.Errorf("metastore: duplicate path %q", e.Path)
+		}
+		n.files[name] = e
+	}
+	return root, nil
+}
+
+// treeRow is one row of tree_entries. Subtree rows carry the total size
+// and file count below them so directory listings need not walk them.
+type treeRow struct {
+	name  string
+	kind  string // "blob" or "tree"
+	ref   string // blob SHA-256 or subtree ID
+	size  int64
+	mode  int
+	files int
+}
+
+// rows returns n's entries sorted by name, hashing subtrees with hashTree.
+func (n *treeNode) rows(subtree func(*treeNode) (treeRow, error)) ([]treeRow, error) {
+	rows := make([]treeRow, 0, len(n.files)+len(n.dirs))
+	for name, child := range n.dirs {
+		r, err := subtree(child)
+		if err != nil {
+			return nil, err
+		}
+		r.name = name
+		rows = append(rows, r)
+	}
+	for name, e := range n.files {
+		rows = append(rows, treeRow{name: name, kind: "blob", ref: e.SHA256, size: e.Size, mode: e.Mode, files: 1})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].name < rows[j].name })
+	return rows, nil
+}
+
+// summarize returns the row describing a tree with the given entries.
+func summarize(rows []treeRow) treeRow {
+	h := sha256.New()
+	t := treeRow{kind: "tree"}
+	for _, r := range rows {
+		fmt.Fprintf(h, "%s %o %d %d %s %s\x00", r.kind, r.mode, r.size, r.files, r.ref, r.name)
+		t.size += r.size
+		t.files += r.files
+	}
+	t.ref = hex.EncodeToString(h.Sum(nil))
+	return t
+}
+
+// writeTree stores n and its subtrees in tx, skipping trees that already
+// exist, and returns the row describing n.
+func writeTree(ctx context.Context, tx *sql.Tx, n *treeNode) (treeRow, error) {
+	rows, err := n.rows(func(child *treeNode) (treeRow, error) { return writeTree(ctx, tx, child) })
+	if err != nil {
+		return treeRow{}, err
+	}
+	t := summarize(rows)
+	res, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO trees(id) VALUES(?)`, t.ref)
+	if err != nil {
+		return treeRow{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return t, nil
+	}
+	for _, r := range rows {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO tree_entries(tree_id, name, kind, ref, size, mode, files) VALUES(?,?,?,?,?,?,?)`,
+			t.ref, r.name, r.kind, r.ref, r.size, r.mode, r.files); err != nil {
+			return treeRow{}, err
+		}
+	}
+	return t, nil
+}
+
+// memTree describes n without storing it, for commits kept as flat manifests.
+func memTree(n *treeNode) (treeRow, error) {
+	rows, err := n.rows(memTree)
+	if err != nil {
+		return treeRow{}, err
+	}
+	return summarize(rows), nil
+}
+
+// readTree materializes every file below tree id, sorted by path.
+func (s *SQLiteMetaStore) readTree(ctx context.Context, id string) ([]Entry, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		WITH RECURSIVE walk(tree_id, prefix) AS (
+			SELECT ?, ''
+			UNION ALL
+			SELECT te.ref, walk.prefix || te.name || '/'
+			FROM tree_entries te JOIN walk ON te.tree_id = walk.tree_id
+			WHERE te.kind = 'tree'
+		)
+		SELECT walk.prefix || te.name AS path, te.ref, te.size, te.mode
+		FROM walk JOIN tree_entries te ON te.tree_id = walk.tree_id
+		WHERE te.kind = 'blob'
+		ORDER BY path`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Entry
+	for rows.Next() {
+		var e Entry
+		if err := rows.Scan(&e.Path, &e.SHA256, &e.Size, &e.Mode); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// ListTree lists directory dir ("" for the root) of a commit in box.
+func (s *SQLiteMetaStore) ListTree(ctx context.Context, boxID, commitID, dir string, recursive bool) ([]TreeEntry, error) {
+	var tree sql.NullString
+	err := s.db.QueryRowContext(ctx, `SELECT tree_id FROM commits WHERE id=? AND box_id=?`, commitID, boxID).Scan(&tree)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !tree.Valid {
+		return s.listLegacyTree(ctx, commitID, dir, recursive)
+	}
+
+	id := tree.String
+	if dir != "" {
+		for _, name := range strings.Split(dir, "/") {
+			var kind string
+			err := s.db.QueryRowContext(ctx, `SELECT kind, ref FROM tree_entries WHERE tree_id=? AND name=?`, id, name).Scan(&kind, &id)
+			if errors.Is(err, sql.ErrNoRows) || (err == nil && kind != "tree") {
+				return nil, ErrNotDirectory
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+	query := `SELECT ? || name, kind, ref, size, mode, files FROM tree_entries WHERE tree_id=? ORDER BY name`
+	if recursive {
+		query = `
+		WITH RECURSIVE walk(tree_id, prefix) AS (
+			SELECT ?2, ?1
+			UNION ALL
+			SELECT te.ref, walk.prefix || te.name || '/'
+			FROM tree_entries te JOIN walk ON te.tree_id = walk.tree_id
+			WHERE te.kind = 'tree'
+		)
+		SELECT walk.prefix || te.name AS path, te.kind, te.ref, te.size, te.mode, te.files
+		FROM walk JOIN tree_entries te ON te.tree_id = walk.tree_id
+		ORDER BY path`
+	}
+	rows, err := s.db.QueryContext(ctx, query, prefix, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []TreeEntry{}
+	for rows.Next() {
+		var r treeRow
+		var p string
+		if err := rows.Scan(&p, &r.kind, &r.ref, &r.size, &r.mode, &r.files); err != nil {
+			return nil, err
+		}
+		out = append(out, r.entry(p))
+	}
+	return out, rows.Err()
+}
+
+// listLegacyTree builds the listing of a pre-tree commit from its manifest.
+func (s *SQLiteMetaStore) listLegacyTree(ctx context.Context, commitID, dir string, recursive bool) ([]TreeEntry, error) {
+	c, err := s.GetCommitByID(ctx, commitID)
+	if err != nil {
+		return nil, err
+	}
+	n, err := buildTree(c.Entries)
+	if err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		for _, name := range strings.Split(dir, "/") {
+			if n = n.dirs[name]; n == nil {
+				return nil, ErrNotDirectory
+			}
+		}
+	}
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+	out := []TreeEntry{}
+	var walk func(n *treeNode, prefix string) error
+	walk = func(n *treeNode, prefix string) error {
+		rows, err := n.rows(memTree)
+		if err != nil {
+			return err
+		}
+		for _, r := range rows {
+			out = append(out, r.entry(prefix+r.name))
+			if recursive && r.kind == "tree" {
+				if err := walk(n.dirs[r.name], prefix+r.name+"/"); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(n, prefix); err != nil {
+		return nil, err
+	}
+	if recursive {
+		sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	}
+	return out, nil
+}
+
+func (r treeRow) entry(p string) TreeEntry {
+	e := TreeEntry{Path: p, Name: path.Base(p), Size: r.size, Files: r.files}
+	if r.kind == "tree" {
+		e.Type = TreeEntryDir
+		e.TreeID = r.ref
+	} else {
+		e.Type = TreeEntryFile
+		e.SHA256 = r.ref
+		e.Mode = r.mode
+	}
+	return e
+}